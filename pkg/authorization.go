@@ -0,0 +1,213 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    . "regexp"
+    "sync"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/handler"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Overrides are written through the configuration topic, so it takes a moment before they can be read back. Caching
+// them for a short while keeps Cassandra out of every single call.
+const overridesCacheTime = time.Minute
+
+// When the overrides can't be read, the ones read before are kept, and the read is tried again after this long.
+const overridesRetryTime = 10 * time.Second
+
+type cachedOverrides struct {
+    // loading is held while the overrides are read, so that the calls of the organization that miss the cache at the
+    // same time wait for one read, instead of each querying Cassandra.
+    loading   sync.Mutex
+    overrides map[string]model.Role
+    loaded    time.Time
+}
+
+// Authorizations checks the role of the user against the role required by the link, which an organization can
+// override per route class.
+type Authorizations struct {
+    cassandra client.Cassandra
+    mutex     sync.Mutex // guards cache, not the reads of the overrides
+    cache     map[int64]*cachedOverrides
+}
+
+func CreateAuthorizations(cassandra client.Cassandra) *Authorizations {
+    return &Authorizations{
+        cassandra: cassandra,
+        cache:     make(map[int64]*cachedOverrides),
+    }
+}
+
+// Links returns the routes to read and change the role overrides. These can not be overridden themselves, so that an
+// organization can't lock its admins out.
+func (a *Authorizations) Links() []Link {
+    return []Link{
+        {Name: "authorizations.list", Role: model.Admin, Method: "GET", Fn: a.ListAuthorizations, Pattern: MustCompile(`^_authorizations$`)},
+        {Name: "authorizations.update", Role: model.Admin, Audit: true, Method: "PUT", Fn: a.UpdateAuthorizations, Pattern: MustCompile(`^_authorizations$`)},
+    }
+}
+
+func (a *Authorizations) Authorize(orgId int64, user *backend.User, link Link) error {
+    required, err := a.requiredRole(orgId, link)
+    if err != nil {
+        return err
+    }
+    role := model.NoRole
+    login := ""
+    if user != nil {
+        role = model.Role(user.Role)
+        login = user.Login
+    }
+    if !role.Includes(required) {
+        return fmt.Errorf("%w: %s requires role %s, but %s has %s", model.ErrForbidden, link.Name, required, login, role)
+    }
+    return nil
+}
+
+// AuthorizeRoute is Authorize for the route class, which is how handlers check the routes of the changes they carry
+// out on behalf of another route.
+func (a *Authorizations) AuthorizeRoute(orgId int64, user *backend.User, route string) error {
+    for _, link := range links {
+        if link.Name == route {
+            return a.Authorize(orgId, user, link)
+        }
+    }
+    return fmt.Errorf("%w: unknown route %s", model.ErrServerError, route)
+}
+
+func (a *Authorizations) requiredRole(orgId int64, link Link) (model.Role, error) {
+    if isAuthorizationRoute(link.Name) {
+        return link.Role, nil
+    }
+    overrides, err := a.overrides(orgId)
+    if err != nil {
+        return model.NoRole, err
+    }
+    if role, found := overrides[link.Name]; found {
+        return role, nil
+    }
+    return link.Role, nil
+}
+
+func (a *Authorizations) cached(orgId int64) *cachedOverrides {
+    a.mutex.Lock()
+    defer a.mutex.Unlock()
+    cached, found := a.cache[orgId]
+    if !found {
+        cached = &cachedOverrides{}
+        a.cache[orgId] = cached
+    }
+    return cached
+}
+
+// overrides returns the role overrides of the organization. If they can't be read, the ones read before are used, as
+// the defaults may be less strict than what the organization has set. If there are none read before, it is an error.
+func (a *Authorizations) overrides(orgId int64) (map[string]model.Role, error) {
+    cached := a.cached(orgId)
+    cached.loading.Lock()
+    defer cached.loading.Unlock()
+    if cached.overrides != nil && time.Since(cached.loaded) < overridesCacheTime {
+        return cached.overrides, nil
+    }
+    overrides, err := a.cassandra.GetRoleOverrides(orgId)
+    if err != nil && cached.overrides != nil {
+        log.DefaultLogger.Error(fmt.Sprintf("Unable to read role overrides of %d, using the previous ones: %s", orgId, err.Error()))
+        cached.loaded = time.Now().Add(overridesRetryTime - overridesCacheTime)
+        return cached.overrides, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%w: unable to read the role overrides: %s", model.ErrServerError, err.Error())
+    }
+    if overrides == nil {
+        overrides = map[string]model.Role{}
+    }
+    cached.overrides = overrides
+    cached.loaded = time.Now()
+    return overrides, nil
+}
+
+//goland:noinspection GoUnusedParameter
+func (a *Authorizations) ListAuthorizations(orgId int64, params []string, body []byte, _ *handler.Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    overrides, err := a.overrides(orgId)
+    if err != nil {
+        return nil, err
+    }
+    result := make([]model.RouteAuthorization, 0)
+    seen := map[string]bool{}
+    for _, link := range links {
+        if seen[link.Name] || isAuthorizationRoute(link.Name) {
+            continue
+        }
+        seen[link.Name] = true
+        authorization := model.RouteAuthorization{Route: link.Name, Role: link.Role, Default: link.Role}
+        if role, found := overrides[link.Name]; found {
+            authorization.Role = role
+            authorization.Overridden = true
+        }
+        result = append(result, authorization)
+    }
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// UpdateAuthorizations replaces the role overrides of the organization with the given map from route class to role.
+// Route classes that are left out use their default role.
+//goland:noinspection GoUnusedParameter
+func (a *Authorizations) UpdateAuthorizations(orgId int64, params []string, body []byte, request *handler.Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    var overrides map[string]model.Role
+    err := json.Unmarshal(body, &overrides)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    errs := &model.ValidationError{}
+    for route, role := range overrides {
+        if !isOverridableRoute(route) {
+            errs.Add(route, "unknown or not overridable route")
+        }
+        if role == model.NoRole || !model.Admin.Includes(role) {
+            errs.Add(route, "role must be one of Viewer, Editor or Admin")
+        }
+    }
+    if err = errs.Err(); err != nil {
+        return nil, err
+    }
+    result, err := handler.SubmitConfiguration(orgId, "updateRoleOverrides", overrides, request, clients)
+    if err != nil {
+        return nil, err
+    }
+    cached := a.cached(orgId)
+    cached.loading.Lock()
+    cached.overrides = overrides
+    cached.loaded = time.Now()
+    cached.loading.Unlock()
+    return result, nil
+}
+
+func isAuthorizationRoute(name string) bool {
+    return name == "authorizations.list" || name == "authorizations.update"
+}
+
+func isOverridableRoute(name string) bool {
+    if isAuthorizationRoute(name) {
+        return false
+    }
+    for _, link := range links {
+        if link.Name == name {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "errors"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// slowOverrides is a client.Cassandra whose GetRoleOverrides takes a while, and fails while err is set.
+type slowOverrides struct {
+    client.Cassandra
+    reads int32
+    err   error
+}
+
+func (s *slowOverrides) GetRoleOverrides(_ int64) (map[string]model.Role, error) {
+    atomic.AddInt32(&s.reads, 1)
+    time.Sleep(20 * time.Millisecond)
+    if s.err != nil {
+        return nil, s.err
+    }
+    return map[string]model.Role{"projects.list": model.Editor}, nil
+}
+
+func TestAuthorizeWithOverrides(t *testing.T) {
+    cassandra := &slowOverrides{}
+    authorizations := CreateAuthorizations(cassandra)
+    link := Link{Name: "projects.list", Role: model.Viewer}
+    viewer := &backend.User{Login: "viewer", Role: "Viewer"}
+
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := authorizations.Authorize(1, viewer, link); !errors.Is(err, model.ErrForbidden) {
+                t.Errorf("expected the override to refuse the viewer, got %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+    if cassandra.reads != 1 {
+        t.Errorf("the overrides were read %d times", cassandra.reads)
+    }
+
+    // the cache runs out while Cassandra is down, and the previous overrides are kept
+    cassandra.err = errors.New("gocql: no hosts available in the pool")
+    authorizations.cache[1].loaded = time.Now().Add(-overridesCacheTime)
+    if err := authorizations.Authorize(1, viewer, link); !errors.Is(err, model.ErrForbidden) {
+        t.Errorf("expected the previous override to refuse the viewer, got %v", err)
+    }
+    // another organization has no previous overrides to fall back on
+    if err := authorizations.Authorize(2, viewer, link); !errors.Is(err, model.ErrServerError) {
+        t.Errorf("expected a server error without overrides, got %v", err)
+    }
+}
@@ -2,8 +2,11 @@ package client
 
 import (
     "context"
+    "encoding/json"
     "fmt"
+    "sort"
     "strconv"
+    "strings"
     "time"
 
     "github.com/Sensetif/sensetif-datasource/pkg/model"
@@ -13,15 +16,25 @@ import (
 
 type Cassandra interface {
     QueryTimeseries(org int64, sensor model.SensorRef, from time.Time, to time.Time, maxValue int) []model.TsPair
+    ReadTimeseries(org int64, sensor model.SensorRef, from time.Time, to time.Time) ([]model.TsPair, error)
+    QueryLatestValue(org int64, sensor model.SensorRef, since time.Time) (*model.TsPair, error)
     QueryAlarmHistory(org int64, sensor model.SensorRef, from time.Time, to time.Time, maxValue int) ([]model.TsPair, error)
     QueryAlarmStates(org int64, sensor model.SensorRef) ([]model.TsPair, error)
     FindAllProjects(org int64) ([]model.ProjectSettings, error)
     FindAllSubsystems(org int64, projectName string) ([]model.SubsystemSettings, error)
     FindAllDatapoints(org int64, projectName string, subsystemName string) ([]model.DatapointSettings, error)
+    FindProjectsPage(org int64, pageSize int, pageState []byte) (model.ResultPage, error)
+    FindSubsystemsPage(org int64, projectName string, pageSize int, pageState []byte) (model.ResultPage, error)
+    FindDatapointsPage(org int64, projectName string, subsystemName string, pageSize int, pageState []byte) (model.ResultPage, error)
+    CountDatapointsPerProject(org int64) (map[string]int64, error)
     GetOrganization(orgId int64) (model.OrganizationSettings, error)
     GetProject(orgId int64, name string) (model.ProjectSettings, error)
     GetSubsystem(org int64, projectName string, subsystem string) (model.SubsystemSettings, error)
     GetDatapoint(org int64, projectName string, subsystemName string, datapoint string) (model.DatapointSettings, error)
+    GetRoleOverrides(org int64) (map[string]model.Role, error)
+    GetCurrentLimits(orgId int64) (model.PlanLimits, error)
+    FindAllTemplates(org int64) ([]model.SubsystemTemplate, error)
+    GetTemplate(org int64, name string) (model.SubsystemTemplate, error)
 
     Shutdown()
     Reinitialize()
@@ -74,23 +87,77 @@ func (cass *CassandraClient) QueryTimeseries(org int64, sensor model.SensorRef,
     //log.DefaultLogger.Info(fmt.Sprintf("yearMonths:  start=%d, end=%d", startYearMonth, endYearMonth))
 
     for yearmonth := endYearMonth; yearmonth >= startYearMonth; yearmonth-- {
-        iter := cass.createQuery(timeseriesTablename, tsQuery, org, sensor.Project, sensor.Subsystem, yearmonth, sensor.Datapoint, from, to)
+        samples, err := cass.queryTimeseriesMonth(org, sensor, yearmonth, from, to)
+        if err != nil {
+            log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+        }
+        result = append(samples, result...)
+    }
+    return reduceSize(maxValues, result)
+}
+
+// ReadTimeseries returns all samples of the datapoint between from and to, oldest first. Unlike QueryTimeseries, which
+// leaves out the months it can't read, it returns the error of the first query that fails, for callers that must not
+// pass on a part as the whole.
+func (cass *CassandraClient) ReadTimeseries(org int64, sensor model.SensorRef, from time.Time, to time.Time) ([]model.TsPair, error) {
+    var result []model.TsPair
+    startYearMonth := from.Year()*12 + int(from.Month()) - 1
+    endYearMonth := to.Year()*12 + int(to.Month()) - 1
+    for yearmonth := endYearMonth; yearmonth >= startYearMonth; yearmonth-- {
+        samples, err := cass.queryTimeseriesMonth(org, sensor, yearmonth, from, to)
+        if err != nil {
+            return nil, err
+        }
+        result = append(samples, result...)
+    }
+    return result, nil
+}
+
+// queryTimeseriesMonth returns the samples of one month partition between from and to, oldest first, as far as they
+// could be read.
+func (cass *CassandraClient) queryTimeseriesMonth(org int64, sensor model.SensorRef, yearmonth int, from time.Time, to time.Time) ([]model.TsPair, error) {
+    var result []model.TsPair
+    iter := cass.createQuery(timeseriesTablename, tsQuery, org, sensor.Project, sensor.Subsystem, yearmonth, sensor.Datapoint, from, to)
+    scanner := iter.Scanner()
+    for scanner.Next() {
+        var rowValue model.TsPair
+        err := scanner.Scan(&rowValue.Value, &rowValue.TS)
+        if err != nil {
+            _ = iter.Close()
+            return result, err
+        }
+        p := []model.TsPair{rowValue}
+        result = append(p, result...)
+    }
+    return result, iter.Close()
+}
+
+// QueryLatestValue returns the most recent sample of the datapoint, searching month by month back to since. It returns
+// nil if there is no sample in that range.
+func (cass *CassandraClient) QueryLatestValue(org int64, sensor model.SensorRef, since time.Time) (*model.TsPair, error) {
+    now := time.Now()
+    startYearMonth := since.Year()*12 + int(since.Month()) - 1
+    endYearMonth := now.Year()*12 + int(now.Month()) - 1
+    for yearmonth := endYearMonth; yearmonth >= startYearMonth; yearmonth-- {
+        // Rows are clustered in descending time order, so the first row is the latest one.
+        iter := cass.createQuery(timeseriesTablename, tsLatestQuery, org, sensor.Project, sensor.Subsystem, yearmonth, sensor.Datapoint, now)
         scanner := iter.Scanner()
         for scanner.Next() {
             var rowValue model.TsPair
             err := scanner.Scan(&rowValue.Value, &rowValue.TS)
             if err != nil {
                 log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+                _ = iter.Close()
+                return nil, err
             }
-            p := []model.TsPair{rowValue}
-            result = append(p, result...)
+            return &rowValue, iter.Close()
         }
         err := iter.Close()
         if err != nil {
-            log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+            return nil, err
         }
     }
-    return reduceSize(maxValues, result)
+    return nil, nil
 }
 
 //func (cass *CassandraClient) QueryAlarmHistory(org int64, sensor model.SensorRef, from time.Time, to time.Time, maxValues int) []model.TsPair {
@@ -98,9 +165,35 @@ func (cass *CassandraClient) QueryAlarmHistory(_ int64, _ model.SensorRef, _ tim
     return make([]model.TsPair, 0), nil
 }
 
-//func (cass *CassandraClient) QueryAlarmStates(org int64, sensor model.SensorRef) []model.TsPair {
-func (cass *CassandraClient) QueryAlarmStates(_ int64, _ model.SensorRef) ([]model.TsPair, error) {
-    return make([]model.TsPair, 0), nil
+// QueryAlarmStates returns the current alarm state of the datapoints of the sensor, one per datapoint with the time it
+// was entered, and the level, the index in model.AlarmSeverities, as value. An empty Subsystem or Datapoint returns the
+// states of the whole project or subsystem.
+func (cass *CassandraClient) QueryAlarmStates(org int64, sensor model.SensorRef) ([]model.TsPair, error) {
+    query := alarmStatesQuery
+    args := []interface{}{org, sensor.Project}
+    if sensor.Subsystem != "" {
+        query = query + " AND subsystem = ?"
+        args = append(args, sensor.Subsystem)
+        if sensor.Datapoint != "" {
+            query = query + " AND datapoint = ?"
+            args = append(args, sensor.Datapoint)
+        }
+    }
+    iter := cass.createQuery(alarmsTablename, query+" ALLOW FILTERING;", args...)
+    scanner := iter.Scanner()
+    result := make([]model.TsPair, 0)
+    for scanner.Next() {
+        var state model.TsPair
+        var level int
+        err := scanner.Scan(&level, &state.TS)
+        if err != nil {
+            log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+            continue
+        }
+        state.Value = float64(level)
+        result = append(result, state)
+    }
+    return result, iter.Close()
 }
 
 func (cass *CassandraClient) GetCurrentLimits(orgId int64) (model.PlanLimits, error) {
@@ -147,6 +240,7 @@ func (cass *CassandraClient) GetProject(orgId int64, name string) (model.Project
         if err != nil {
             log.DefaultLogger.Error("Internal Error? Failed to read record", err)
         }
+        checkGeolocation(rowValue)
         return rowValue, iter.Close()
     }
     return model.ProjectSettings{}, iter.Close()
@@ -154,8 +248,25 @@ func (cass *CassandraClient) GetProject(orgId int64, name string) (model.Project
 
 func (cass *CassandraClient) FindAllProjects(org int64) ([]model.ProjectSettings, error) {
     log.DefaultLogger.Info("findAllProjects:  " + strconv.FormatInt(org, 10))
-    result := make([]model.ProjectSettings, 0)
     iter := cass.createQuery(projectsTablename, projectsQuery, org)
+    result := readProjects(iter)
+    log.DefaultLogger.Info(fmt.Sprintf("Found: %d projects", len(result)))
+    return result, iter.Close()
+}
+
+// FindProjectsPage reads one page of projects, starting at the paging state returned with the previous page.
+func (cass *CassandraClient) FindProjectsPage(org int64, pageSize int, pageState []byte) (model.ResultPage, error) {
+    log.DefaultLogger.Info("findProjectsPage:  " + strconv.FormatInt(org, 10))
+    total, err := cass.count(projectsTablename, projectsCountQuery, org)
+    if err != nil {
+        return model.ResultPage{}, err
+    }
+    iter := cass.createPagedQuery(projectsTablename, projectsQuery, pageSize, pageState, org)
+    return model.ResultPage{Items: readProjects(iter), Total: total, PageState: iter.PageState()}, iter.Close()
+}
+
+func readProjects(iter *gocql.Iter) []model.ProjectSettings {
+    result := make([]model.ProjectSettings, 0)
     scanner := iter.Scanner()
     for scanner.Next() {
         var rowValue model.ProjectSettings
@@ -163,10 +274,10 @@ func (cass *CassandraClient) FindAllProjects(org int64) ([]model.ProjectSettings
         if err != nil {
             log.DefaultLogger.Error("Internal Error? Failed to read record", err)
         }
+        checkGeolocation(rowValue)
         result = append(result, rowValue)
     }
-    log.DefaultLogger.Info(fmt.Sprintf("Found: %d projects", len(result)))
-    return result, iter.Close()
+    return result
 }
 
 func (cass *CassandraClient) GetSubsystem(org int64, projectName string, subsystem string) (model.SubsystemSettings, error) {
@@ -187,8 +298,26 @@ func (cass *CassandraClient) GetSubsystem(org int64, projectName string, subsyst
 
 func (cass *CassandraClient) FindAllSubsystems(org int64, projectName string) ([]model.SubsystemSettings, error) {
     log.DefaultLogger.Info("findAllSubsystems:  " + strconv.FormatInt(org, 10) + "/" + projectName)
-    result := make([]model.SubsystemSettings, 0)
     iter := cass.createQuery(subsystemsTablename, subsystemsQuery, org, projectName)
+    result := readSubsystems(iter, projectName)
+    log.DefaultLogger.Info(fmt.Sprintf("Found: %d subsystems", len(result)))
+    return result, iter.Close()
+}
+
+// FindSubsystemsPage reads one page of the subsystems of a project, starting at the paging state returned with the
+// previous page.
+func (cass *CassandraClient) FindSubsystemsPage(org int64, projectName string, pageSize int, pageState []byte) (model.ResultPage, error) {
+    log.DefaultLogger.Info("findSubsystemsPage:  " + strconv.FormatInt(org, 10) + "/" + projectName)
+    total, err := cass.count(subsystemsTablename, subsystemsCountQuery, org, projectName)
+    if err != nil {
+        return model.ResultPage{}, err
+    }
+    iter := cass.createPagedQuery(subsystemsTablename, subsystemsQuery, pageSize, pageState, org, projectName)
+    return model.ResultPage{Items: readSubsystems(iter, projectName), Total: total, PageState: iter.PageState()}, iter.Close()
+}
+
+func readSubsystems(iter *gocql.Iter, projectName string) []model.SubsystemSettings {
+    result := make([]model.SubsystemSettings, 0)
     scanner := iter.Scanner()
     for scanner.Next() {
         var rowValue model.SubsystemSettings
@@ -199,8 +328,7 @@ func (cass *CassandraClient) FindAllSubsystems(org int64, projectName string) ([
         }
         result = append(result, rowValue)
     }
-    log.DefaultLogger.Info(fmt.Sprintf("Found: %d subsystems", len(result)))
-    return result, iter.Close()
+    return result
 }
 
 func (cass *CassandraClient) GetDatapoint(org int64, projectName string, subsystemName string, datapoint string) (model.DatapointSettings, error) {
@@ -215,17 +343,114 @@ func (cass *CassandraClient) GetDatapoint(org int64, projectName string, subsyst
 
 func (cass *CassandraClient) FindAllDatapoints(org int64, projectName string, subsystemName string) ([]model.DatapointSettings, error) {
     log.DefaultLogger.Info("findAllDatapoints:  " + strconv.FormatInt(org, 10) + "/" + projectName + "/" + subsystemName)
-    result := make([]model.DatapointSettings, 0)
     iter := cass.createQuery(datapointsTablename, datapointsQuery, org, projectName, subsystemName)
+    result := cass.readDatapoints(iter)
+    log.DefaultLogger.Info(fmt.Sprintf("Found: %d datapoints", len(result)))
+    return result, iter.Close()
+}
+
+// CountDatapointsPerProject counts the datapoints of all projects in the organization with a single query, so that
+// overviews don't need to read every subsystem.
+func (cass *CassandraClient) CountDatapointsPerProject(org int64) (map[string]int64, error) {
+    iter := cass.createQuery(datapointsTablename, datapointProjectsQuery, org)
+    scanner := iter.Scanner()
+    result := map[string]int64{}
+    for scanner.Next() {
+        var project string
+        err := scanner.Scan(&project)
+        if err != nil {
+            log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+            continue
+        }
+        result[project]++
+    }
+    return result, iter.Close()
+}
+
+// FindDatapointsPage reads one page of the datapoints of a subsystem, starting at the paging state returned with the
+// previous page.
+func (cass *CassandraClient) FindDatapointsPage(org int64, projectName string, subsystemName string, pageSize int, pageState []byte) (model.ResultPage, error) {
+    log.DefaultLogger.Info("findDatapointsPage:  " + strconv.FormatInt(org, 10) + "/" + projectName + "/" + subsystemName)
+    total, err := cass.count(datapointsTablename, datapointsCountQuery, org, projectName, subsystemName)
+    if err != nil {
+        return model.ResultPage{}, err
+    }
+    iter := cass.createPagedQuery(datapointsTablename, datapointsQuery, pageSize, pageState, org, projectName, subsystemName)
+    return model.ResultPage{Items: cass.readDatapoints(iter), Total: total, PageState: iter.PageState()}, iter.Close()
+}
+
+func (cass *CassandraClient) readDatapoints(iter *gocql.Iter) []model.DatapointSettings {
+    result := make([]model.DatapointSettings, 0)
     scanner := iter.Scanner()
     for scanner.Next() {
         datapoint := cass.deserializeDatapointRow(scanner)
         result = append(result, datapoint)
     }
-    log.DefaultLogger.Info(fmt.Sprintf("Found: %d datapoints", len(result)))
+    return result
+}
+
+func (cass *CassandraClient) GetRoleOverrides(org int64) (map[string]model.Role, error) {
+    result := make(map[string]model.Role)
+    iter := cass.createQuery(roleOverridesTablename, roleOverridesQuery, org)
+    scanner := iter.Scanner()
+    for scanner.Next() {
+        var route string
+        var role string
+        err := scanner.Scan(&route, &role)
+        if err != nil {
+            log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+            continue
+        }
+        result[route] = model.Role(role)
+    }
     return result, iter.Close()
 }
 
+// FindAllTemplates returns the subsystem templates of the organization, sorted by name. Each template is stored as its
+// JSON document.
+func (cass *CassandraClient) FindAllTemplates(org int64) ([]model.SubsystemTemplate, error) {
+    result := make([]model.SubsystemTemplate, 0)
+    iter := cass.createQuery(templatesTablename, templatesQuery, org)
+    scanner := iter.Scanner()
+    for scanner.Next() {
+        template, err := readTemplate(scanner)
+        if err != nil {
+            log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+            continue
+        }
+        result = append(result, template)
+    }
+    sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+    return result, iter.Close()
+}
+
+func (cass *CassandraClient) GetTemplate(org int64, name string) (model.SubsystemTemplate, error) {
+    log.DefaultLogger.Info("getTemplate:  " + strconv.FormatInt(org, 10) + "/" + name)
+    iter := cass.createQuery(templatesTablename, templateQuery, org, name)
+    scanner := iter.Scanner()
+    for scanner.Next() {
+        template, err := readTemplate(scanner)
+        if err != nil {
+            log.DefaultLogger.Error("Internal Error? Failed to read record", err)
+        }
+        return template, iter.Close()
+    }
+    return model.SubsystemTemplate{}, iter.Close()
+}
+
+func readTemplate(scanner gocql.Scanner) (model.SubsystemTemplate, error) {
+    var template model.SubsystemTemplate
+    var name string
+    var document string
+    err := scanner.Scan(&name, &document)
+    if err != nil {
+        return template, err
+    }
+    err = json.Unmarshal([]byte(document), &template)
+    template.Name = name
+    return template, err
+}
+
 func (cass *CassandraClient) SelectAllInJournal(org int64, journaltype string, journalname string) (model.Journal, error) {
     log.DefaultLogger.Info("SelectAllInJournal:  " + strconv.FormatInt(org, 10) + "/" + journaltype + "/" + journalname)
     result := model.Journal{
@@ -282,6 +507,21 @@ func (cass *CassandraClient) createQuery(tableName string, query string, args ..
     return q.Iter()
 }
 
+// createPagedQuery returns an iterator over a single page. Setting the paging state, also when it is nil for the first
+// page, stops gocql from fetching the following pages automatically.
+func (cass *CassandraClient) createPagedQuery(tableName string, query string, pageSize int, pageState []byte, args ...interface{}) *gocql.Iter {
+    t := fmt.Sprintf(query, cass.clusterConfig.Keyspace, tableName)
+    q := cass.session.Query(t).Consistency(gocql.One).Idempotent(true).Bind(args...).PageSize(pageSize).PageState(pageState)
+    return q.Iter()
+}
+
+func (cass *CassandraClient) count(tableName string, query string, args ...interface{}) (int, error) {
+    var count int
+    iter := cass.createQuery(tableName, query, args...)
+    iter.Scan(&count)
+    return count, iter.Close()
+}
+
 func (cass *CassandraClient) deserializeDatapointRow(scanner gocql.Scanner) model.DatapointSettings {
     var r model.DatapointSettings
     // project,subsystem,name,pollinterval,datasourcetype,timetolive,proc,ttnv3,web,mqtt
@@ -309,6 +549,15 @@ func (cass *CassandraClient) deserializeDatapointRow(scanner gocql.Scanner) mode
     return r
 }
 
+func checkGeolocation(project model.ProjectSettings) {
+    if strings.TrimSpace(project.Geolocation) == "" {
+        return
+    }
+    if _, err := project.Location(); err != nil {
+        log.DefaultLogger.Warn(fmt.Sprintf("Project %s has an invalid geolocation: %s", project.Name, err.Error()))
+    }
+}
+
 func reduceSize(maxValues int, result []model.TsPair) []model.TsPair {
     resultLength := len(result)
     if resultLength > maxValues && resultLength > 0 && maxValues > 0 {
@@ -341,26 +590,46 @@ const organizationQuery = "SELECT name,email,stripecustomer,currentplan,address1
 
 const projectsQuery = "SELECT name,title,city,country,timezone,geolocation FROM %s.%s WHERE orgid = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
 
+const projectsCountQuery = "SELECT COUNT(*) FROM %s.%s WHERE orgid = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
+
 const subsystemsTablename = "subsystems"
 
 const subsystemQuery = "SELECT name,title,location FROM %s.%s WHERE orgid = ? AND project = ? AND name = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
 
 const subsystemsQuery = "SELECT name,title,location FROM %s.%s WHERE orgid = ? AND project = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
 
+const subsystemsCountQuery = "SELECT COUNT(*) FROM %s.%s WHERE orgid = ? AND project = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
+
 const datapointsTablename = "datapoints"
 
 const datapointQuery = "SELECT project,subsystem,name,pollinterval,datasourcetype,timetolive,proc,ttnv3,web,mqtt FROM %s.%s WHERE orgid = ? AND project = ? AND subsystem = ? AND name = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
 
 const datapointsQuery = "SELECT project,subsystem,name,pollinterval,datasourcetype,timetolive,proc,ttnv3,web,mqtt FROM %s.%s WHERE orgid = ? AND project = ? AND subsystem = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
 
-const planlimitsQuery = "SELECT orgid,created,maxdatapoints,maxstorage,minpollinterval FROM  %s.%s WHERE orgid = 5 AND deleted = '1970-01-01 00:00:00.000000+0000' ALLOW FILTERING;"
+const datapointProjectsQuery = "SELECT project FROM %s.%s WHERE orgid = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
+
+const datapointsCountQuery = "SELECT COUNT(*) FROM %s.%s WHERE orgid = ? AND project = ? AND subsystem = ? AND DELETED = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
+
+const planlimitsQuery = "SELECT maxdatapoints,maxstorage,minpollinterval FROM  %s.%s WHERE orgid = ? AND deleted = '1970-01-01 00:00:00.000000+0000' ALLOW FILTERING;"
 
 const planlimitsTablename = "planlimits"
 
+const roleOverridesTablename = "roleoverrides"
+
+const roleOverridesQuery = "SELECT route,role FROM %s.%s WHERE orgid = ? AND deleted = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
+
+const templatesTablename = "templates"
+
+const templatesQuery = "SELECT name,document FROM %s.%s WHERE orgid = ? AND deleted = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
+
+const templateQuery = "SELECT name,document FROM %s.%s WHERE orgid = ? AND name = ? AND deleted = '1970-01-01 0:00:00+0000' ALLOW FILTERING;"
+
 const timeseriesTablename = "timeseries"
 
 const alarmsTablename = "alarms"
 
+const alarmStatesQuery = "SELECT state,ts FROM %s.%s WHERE orgid = ? AND project = ?"
+
 const tsQuery = "SELECT value,ts FROM %s.%s" +
     " WHERE" +
     " orgId = ?" +
@@ -378,6 +647,21 @@ const tsQuery = "SELECT value,ts FROM %s.%s" +
     " ts <= ?" +
     ";"
 
+const tsLatestQuery = "SELECT value,ts FROM %s.%s" +
+    " WHERE" +
+    " orgId = ?" +
+    " AND" +
+    " project = ?" +
+    " AND" +
+    " subsystem = ?" +
+    " AND" +
+    " yearmonth = ?" +
+    " AND" +
+    " datapoint = ?" +
+    " AND " +
+    " ts <= ?" +
+    " LIMIT 1;"
+
 //const keyValuesTablename = "keyvalues"
 //const keyValuesSelectQuery = "SELECT type, key, created, value FROM %s.%s WHERE orgid = ? AND type = ? AND key = '___ALL___' AND deleted = '1970-01-01 0:00:00+0000';\n"
 
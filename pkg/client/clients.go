@@ -1,7 +1,13 @@
 package client
 
 type Clients struct {
-	Cassandra *CassandraClient
-	Pulsar    *PulsarClient
-	Stripe    *StripeClient
+	Cassandra   Cassandra
+	Pulsar      *PulsarClient
+	Stripe      *StripeClient
+	Commands    *CommandTracker
+	Search      *SearchIndexes
+	Idempotency *IdempotencyCache
+	Web         *WebClient
+	Mqtt        *MqttClient
+	Ttn         *TtnClient
 }
@@ -0,0 +1,225 @@
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/apache/pulsar-client-go/pulsar"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// A command that has not been fully applied within this time is considered failed.
+const commandTimeout = 5 * time.Minute
+
+// How long the status of a command can be looked up after it was submitted.
+const commandRetention = time.Hour
+
+// The delay before the command results are read again after a failure, which doubles up to listenMaxDelay.
+const listenMinDelay = time.Second
+
+const listenMaxDelay = time.Minute
+
+// CommandTracker keeps the status of the configuration commands submitted through this plugin instance, and updates
+// them from the results that the backend publishes on model.CommandResultTopic.
+type CommandTracker struct {
+    mutex     sync.Mutex
+    commands  map[commandKey]*model.CommandStatus
+    listeners map[int64][]chan model.CommandStatus
+    changed   map[int64]time.Time
+}
+
+func CreateCommandTracker() *CommandTracker {
+    return &CommandTracker{
+        commands:  make(map[commandKey]*model.CommandStatus),
+        listeners: make(map[int64][]chan model.CommandStatus),
+        changed:   make(map[int64]time.Time),
+    }
+}
+
+type commandKey struct {
+    orgId int64
+    id    string
+}
+
+// Listen reads the command results, and is intended to run in its own goroutine. When the reader fails, a new one is
+// created after a growing delay, which continues after the last result that was read.
+func (t *CommandTracker) Listen(pulsarClient *PulsarClient) {
+    topic := model.MainNamespace + "/" + model.CommandResultTopic
+    start := pulsar.LatestMessageID()
+    delay := listenMinDelay
+    for {
+        last, read := t.read(pulsarClient, topic, start)
+        if last != nil {
+            start = last
+        }
+        if read {
+            delay = listenMinDelay
+        }
+        log.DefaultLogger.Warn(fmt.Sprintf("Reading command results again in %s", delay))
+        time.Sleep(delay)
+        delay = delay * 2
+        if delay > listenMaxDelay {
+            delay = listenMaxDelay
+        }
+    }
+}
+
+// read applies the command results until the reader fails, and returns the id of the last message and whether any
+// message was read.
+func (t *CommandTracker) read(pulsarClient *PulsarClient, topic string, start pulsar.MessageID) (pulsar.MessageID, bool) {
+    reader := pulsarClient.CreateReaderAt(topic, start)
+    if reader == nil {
+        log.DefaultLogger.Error("Command results can not be read, commands will time out until they can")
+        return nil, false
+    }
+    defer reader.Close()
+    var last pulsar.MessageID
+    for {
+        msg, err := reader.Next(context.Background())
+        if err != nil {
+            log.DefaultLogger.Error(fmt.Sprintf("Unable to read command result: %+v", err))
+            return last, last != nil
+        }
+        last = msg.ID()
+        var result model.CommandResult
+        err = json.Unmarshal(msg.Payload(), &result)
+        if err != nil {
+            log.DefaultLogger.Error(fmt.Sprintf("Could not unmarshall command result: %v", err))
+            continue
+        }
+        t.Apply(result)
+    }
+}
+
+// Submit registers a new pending command of the given number of messages.
+func (t *CommandTracker) Submit(orgId int64, id string, requestId string, operation string, messages int) model.CommandStatus {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    now := time.Now()
+    for key, status := range t.commands {
+        if now.Sub(status.Submitted) > commandRetention {
+            delete(t.commands, key)
+        }
+    }
+    status := &model.CommandStatus{
+        Id:        id,
+        RequestId: requestId,
+        Operation: operation,
+        Messages:  messages,
+        Submitted: now,
+        Updated:   now,
+    }
+    status.Update()
+    t.commands[commandKey{orgId, id}] = status
+    t.notify(orgId, status)
+    return *status
+}
+
+// Fail marks the command as failed, e.g. when a message could not be published.
+func (t *CommandTracker) Fail(orgId int64, id string, reason string) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    status, found := t.commands[commandKey{orgId, id}]
+    if !found {
+        return
+    }
+    status.Failed++
+    status.Errors = append(status.Errors, reason)
+    status.Updated = time.Now()
+    status.Update()
+    t.notify(orgId, status)
+}
+
+// Apply counts a result from the backend. Results of commands submitted through other plugin instances are ignored.
+func (t *CommandTracker) Apply(result model.CommandResult) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    status, found := t.commands[commandKey{result.OrgId, result.CommandId}]
+    if !found {
+        return
+    }
+    if result.Success {
+        status.Applied++
+    } else {
+        status.Failed++
+        status.Errors = append(status.Errors, result.Error)
+    }
+    status.Updated = time.Now()
+    status.Update()
+    t.changed[result.OrgId] = status.Updated
+    t.notify(result.OrgId, status)
+}
+
+// LastChange returns when the backend last reported a result of a command of the organization, which is when cached
+// configuration needs to be read again.
+func (t *CommandTracker) LastChange(orgId int64) time.Time {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.changed[orgId]
+}
+
+// Get returns the status of the command, if it is known.
+func (t *CommandTracker) Get(orgId int64, id string) (model.CommandStatus, bool) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    status, found := t.commands[commandKey{orgId, id}]
+    if !found {
+        return model.CommandStatus{}, false
+    }
+    t.expire(orgId, status)
+    return *status, true
+}
+
+// Subscribe returns a channel with each change of the commands of the organization, and a function to stop it.
+func (t *CommandTracker) Subscribe(orgId int64) (<-chan model.CommandStatus, func()) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    listener := make(chan model.CommandStatus, 16)
+    t.listeners[orgId] = append(t.listeners[orgId], listener)
+    return listener, func() {
+        t.mutex.Lock()
+        defer t.mutex.Unlock()
+        listeners := t.listeners[orgId]
+        for i, l := range listeners {
+            if l == listener {
+                t.listeners[orgId] = append(listeners[:i], listeners[i+1:]...)
+                close(listener)
+                break
+            }
+        }
+    }
+}
+
+// Expire fails the pending commands that have timed out, and notifies the listeners about them.
+func (t *CommandTracker) Expire() {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    for key, status := range t.commands {
+        t.expire(key.orgId, status)
+    }
+}
+
+func (t *CommandTracker) expire(orgId int64, status *model.CommandStatus) {
+    if status.State == model.CommandPending && time.Since(status.Submitted) > commandTimeout {
+        status.Failed += status.Messages - status.Applied
+        status.Errors = append(status.Errors, fmt.Sprintf("no result from the backend within %s", commandTimeout))
+        status.Updated = time.Now()
+        status.Update()
+        t.notify(orgId, status)
+    }
+}
+
+// notify must be called with the mutex held. Listeners that don't keep up miss updates rather than block the tracker.
+func (t *CommandTracker) notify(orgId int64, status *model.CommandStatus) {
+    for _, listener := range t.listeners[orgId] {
+        select {
+        case listener <- *status:
+        default:
+            log.DefaultLogger.Warn(fmt.Sprintf("Dropped update of command %s, the listener is not keeping up", status.Id))
+        }
+    }
+}
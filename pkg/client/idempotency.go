@@ -0,0 +1,87 @@
+package client
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// How long a response is kept for retries with the same Idempotency-Key.
+const idempotencyRetention = 24 * time.Hour
+
+// IdempotentResponse is the stored response of a request with an Idempotency-Key. Fingerprint identifies the request,
+// so that a key reused for a different request is detected.
+type IdempotentResponse struct {
+    Fingerprint string
+    Status      int
+    Body        []byte
+    Created     time.Time
+    completed   bool
+}
+
+// IdempotencyCache remembers the responses of requests with an Idempotency-Key, per organization, so that a client
+// retrying a request gets the original response instead of repeating its effect. Like the CommandTracker, it only
+// knows about the requests handled by this plugin instance.
+type IdempotencyCache struct {
+    mutex     sync.Mutex
+    responses map[idempotencyKey]*IdempotentResponse
+}
+
+func CreateIdempotencyCache() *IdempotencyCache {
+    return &IdempotencyCache{
+        responses: make(map[idempotencyKey]*IdempotentResponse),
+    }
+}
+
+type idempotencyKey struct {
+    orgId int64
+    key   string
+}
+
+// Begin reserves the key for a request. It returns the stored response if the request was already handled, and nil if
+// the caller should handle it and then call Complete or Abandon.
+func (c *IdempotencyCache) Begin(orgId int64, key string, fingerprint string) (*IdempotentResponse, error) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    now := time.Now()
+    for k, response := range c.responses {
+        if now.Sub(response.Created) > idempotencyRetention {
+            delete(c.responses, k)
+        }
+    }
+    response, found := c.responses[idempotencyKey{orgId, key}]
+    if !found {
+        c.responses[idempotencyKey{orgId, key}] = &IdempotentResponse{Fingerprint: fingerprint, Created: now}
+        return nil, nil
+    }
+    if response.Fingerprint != fingerprint {
+        return nil, fmt.Errorf("%w: Idempotency-Key %s was used for a different request", model.ErrUnprocessableEntity, key)
+    }
+    if !response.completed {
+        return nil, fmt.Errorf("%w: a request with Idempotency-Key %s is still in progress", model.ErrConflict, key)
+    }
+    stored := *response
+    return &stored, nil
+}
+
+// Complete stores the response of the request that reserved the key.
+func (c *IdempotencyCache) Complete(orgId int64, key string, status int, body []byte) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    response, found := c.responses[idempotencyKey{orgId, key}]
+    if !found {
+        return
+    }
+    response.Status = status
+    response.Body = body
+    response.completed = true
+}
+
+// Abandon releases the key of a request that failed, so that it can be retried.
+func (c *IdempotencyCache) Abandon(orgId int64, key string) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    delete(c.responses, idempotencyKey{orgId, key})
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/tls"
+    "encoding/hex"
+    "fmt"
+    "net"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    mqtt "github.com/eclipse/paho.mqtt.golang"
+    "golang.org/x/net/websocket"
+)
+
+const mqttKeepAlive = 60 * time.Second
+
+// How long the DISCONNECT may take at the end of a Listen, in milliseconds.
+const mqttQuiesce = 250
+
+// The url schemes of the protocols that Listen can connect with. Protocols that aren't here, like alis, are valid in
+// the settings of a datapoint, but can't be tested.
+var mqttSchemes = map[model.MqttProtocol]string{
+    "mqtt":  "tcp",
+    "tcp":   "tcp",
+    "mqtts": "ssl",
+    "tls":   "ssl",
+    "ws":    "ws",
+    "wss":   "wss",
+    "wxs":   "wss",
+}
+
+var mqttConnectRefusals = map[byte]string{
+    1: "the broker does not support MQTT 3.1.1",
+    2: "the broker rejected the client id",
+    3: "the broker is unavailable",
+    4: "the username or password is wrong",
+    5: "the client is not authorized to connect",
+}
+
+// MqttClient connects to MQTT brokers to test datasources, with MQTT 3.1.1 over TCP, TLS and websockets. Dialer and
+// TLS can be replaced, e.g. to reach a local broker with a self-signed certificate. TLS may be nil.
+type MqttClient struct {
+    Dialer *net.Dialer
+    TLS    *tls.Config
+}
+
+// CreateMqttClient returns a client that, like the WebClient, refuses to connect to private addresses unless
+// SENSETIF_ALLOW_PRIVATE_URLS=true.
+func CreateMqttClient() *MqttClient {
+    dialer := &net.Dialer{Timeout: 10 * time.Second}
+    if os.Getenv("SENSETIF_ALLOW_PRIVATE_URLS") != "true" {
+        dialer.Control = refusePrivateAddresses
+    }
+    return &MqttClient{Dialer: dialer, TLS: &tls.Config{}}
+}
+
+type MqttMessage struct {
+    Topic    string
+    Payload  []byte
+    Retained bool
+    Received time.Time
+}
+
+// MqttSession tells how far a Listen got, and what it received.
+type MqttSession struct {
+    Connected  bool
+    Subscribed bool
+    Messages   []MqttMessage
+}
+
+// MqttRefusedError is returned when the broker refuses the connection or the subscription.
+type MqttRefusedError struct {
+    Code    byte
+    Message string
+}
+
+func (e *MqttRefusedError) Error() string {
+    return e.Message
+}
+
+// CanListen tells if Listen can connect with the protocol.
+func CanListen(protocol model.MqttProtocol) bool {
+    _, found := mqttSchemes[protocol]
+    return found
+}
+
+// Listen connects to the broker of the datasource, subscribes to its topic and collects the messages that arrive until
+// the duration has passed or max messages have been received. Retained messages arrive right after subscribing.
+func (c *MqttClient) Listen(ctx context.Context, ds model.MqttDatasource, duration time.Duration, max int) (MqttSession, error) {
+    var session MqttSession
+    broker, err := mqttBrokerOf(ds)
+    if err != nil {
+        return session, err
+    }
+    ctx, cancel := context.WithTimeout(ctx, duration)
+    defer cancel()
+
+    id := make([]byte, 6)
+    _, _ = rand.Read(id)
+    lost := make(chan error, 1)
+    options := mqtt.NewClientOptions().
+        AddBroker(broker.String()).
+        SetClientID("sensetif-test-" + hex.EncodeToString(id)).
+        SetUsername(ds.Username).
+        SetPassword(ds.Password).
+        SetCleanSession(true).
+        SetProtocolVersion(4).
+        SetKeepAlive(mqttKeepAlive).
+        SetConnectTimeout(duration).
+        SetAutoReconnect(false).
+        SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+            lost <- err
+        }).
+        SetCustomOpenConnectionFn(c.open)
+    conn := mqtt.NewClient(options)
+    connected := conn.Connect()
+    select {
+    case <-connected.Done():
+    case <-ctx.Done():
+        return session, fmt.Errorf("no answer to CONNECT: %w", ctx.Err())
+    }
+    if err = connected.Error(); err != nil {
+        code := connected.(*mqtt.ConnectToken).ReturnCode()
+        if message, found := mqttConnectRefusals[code]; found {
+            return session, &MqttRefusedError{Code: code, Message: message}
+        }
+        return session, err
+    }
+    defer conn.Disconnect(mqttQuiesce)
+    session.Connected = true
+
+    var lock sync.Mutex
+    var messages []MqttMessage
+    full := make(chan struct{})
+    subscribed := conn.Subscribe(ds.Topic, 0, func(_ mqtt.Client, message mqtt.Message) {
+        lock.Lock()
+        defer lock.Unlock()
+        if len(messages) >= max {
+            return
+        }
+        messages = append(messages, MqttMessage{
+            Topic:    message.Topic(),
+            Payload:  message.Payload(),
+            Retained: message.Retained(),
+            Received: time.Now(),
+        })
+        if len(messages) == max {
+            close(full)
+        }
+    })
+    select {
+    case <-subscribed.Done():
+    case <-ctx.Done():
+        return session, fmt.Errorf("no answer to SUBSCRIBE: %w", ctx.Err())
+    }
+    if err = subscribed.Error(); err != nil {
+        return session, err
+    }
+    if subscribed.(*mqtt.SubscribeToken).Result()[ds.Topic] == 0x80 {
+        return session, &MqttRefusedError{Code: 0x80, Message: fmt.Sprintf("the broker refused the subscription to \"%s\", the topic is invalid or not allowed for this user", ds.Topic)}
+    }
+    session.Subscribed = true
+
+    select {
+    case <-full:
+    case <-ctx.Done():
+    case err = <-lost:
+    }
+    lock.Lock()
+    defer lock.Unlock()
+    session.Messages = messages
+    return session, err
+}
+
+// mqttBrokerOf returns the url of the broker. For websockets, the address may have a path, which defaults to /mqtt.
+func mqttBrokerOf(ds model.MqttDatasource) (*url.URL, error) {
+    scheme, found := mqttSchemes[ds.Protocol]
+    if !found {
+        return nil, fmt.Errorf("connecting with protocol \"%s\" is not supported", ds.Protocol)
+    }
+    host, path, _ := strings.Cut(ds.Address, "/")
+    broker := &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(ds.Port)))}
+    if scheme == "ws" || scheme == "wss" {
+        if path == "" {
+            path = "mqtt"
+        }
+        broker.Path = "/" + path
+    }
+    return broker, nil
+}
+
+// open is the mqtt.OpenConnectionFunc of Listen. It dials with the Dialer for every protocol, so that private addresses
+// are refused for websockets too.
+func (c *MqttClient) open(broker *url.URL, _ mqtt.ClientOptions) (net.Conn, error) {
+    tlsConfig := &tls.Config{}
+    if c.TLS != nil {
+        tlsConfig = c.TLS.Clone()
+    }
+    tlsConfig.ServerName = broker.Hostname()
+    switch broker.Scheme {
+    case "tcp":
+        return c.Dialer.Dial("tcp", broker.Host)
+    case "ssl":
+        return tls.DialWithDialer(c.Dialer, "tcp", broker.Host, tlsConfig)
+    default:
+        config, err := websocket.NewConfig(broker.String(), "http://"+broker.Hostname())
+        if err != nil {
+            return nil, err
+        }
+        config.Protocol = []string{"mqtt"}
+        config.TlsConfig = tlsConfig
+        config.Dialer = c.Dialer
+        conn, err := websocket.DialConfig(config)
+        if err != nil {
+            return nil, err
+        }
+        conn.PayloadType = websocket.BinaryFrame
+        return conn, nil
+    }
+}
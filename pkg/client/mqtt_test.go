@@ -0,0 +1,269 @@
+package client
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "net"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/eclipse/paho.mqtt.golang/packets"
+    "golang.org/x/net/websocket"
+)
+
+// fakeBroker plays one MQTT session, as given by the script, for each connection to it.
+type fakeBroker struct {
+    t    *testing.T
+    conn net.Conn
+}
+
+func (b *fakeBroker) read() packets.ControlPacket {
+    packet, err := packets.ReadPacket(b.conn)
+    if err != nil {
+        b.t.Errorf("the broker could not read: %v", err)
+    }
+    return packet
+}
+
+func (b *fakeBroker) send(packet packets.ControlPacket) {
+    if err := packet.Write(b.conn); err != nil {
+        b.t.Errorf("the broker could not send: %v", err)
+    }
+}
+
+// connect reads CONNECT, answers with the return code and returns the CONNECT.
+func (b *fakeBroker) connect(code byte) *packets.ConnectPacket {
+    connect, ok := b.read().(*packets.ConnectPacket)
+    if !ok {
+        b.t.Errorf("the broker expected CONNECT")
+        return &packets.ConnectPacket{}
+    }
+    connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+    connack.ReturnCode = code
+    b.send(connack)
+    return connect
+}
+
+// subscribe reads SUBSCRIBE, answers with the return code and returns the topic.
+func (b *fakeBroker) subscribe(code byte) string {
+    subscribe, ok := b.read().(*packets.SubscribePacket)
+    if !ok || len(subscribe.Topics) != 1 {
+        b.t.Errorf("the broker expected SUBSCRIBE to one topic")
+        return ""
+    }
+    suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+    suback.MessageID = subscribe.MessageID
+    suback.ReturnCodes = []byte{code}
+    b.send(suback)
+    return subscribe.Topics[0]
+}
+
+func (b *fakeBroker) publish(qos byte, retained bool, messageId uint16, topic string, payload string) {
+    publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+    publish.Qos = qos
+    publish.Retain = retained
+    publish.MessageID = messageId
+    publish.TopicName = topic
+    publish.Payload = []byte(payload)
+    b.send(publish)
+}
+
+func (b *fakeBroker) disconnect() {
+    if _, ok := b.read().(*packets.DisconnectPacket); !ok {
+        b.t.Errorf("the broker expected DISCONNECT")
+    }
+}
+
+func startFakeBroker(t *testing.T, script func(b *fakeBroker)) (model.MqttDatasource, chan struct{}) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        conn, err := listener.Accept()
+        _ = listener.Close()
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        defer conn.Close()
+        _ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+        script(&fakeBroker{t: t, conn: conn})
+    }()
+    port := listener.Addr().(*net.TCPAddr).Port
+    return model.MqttDatasource{Protocol: "mqtt", Address: "127.0.0.1", Port: uint16(port), Topic: "garden/shed/#"}, done
+}
+
+func testMqttClient() *MqttClient {
+    return &MqttClient{Dialer: &net.Dialer{Timeout: time.Second}}
+}
+
+func TestListenReportsRefusedConnections(t *testing.T) {
+    for code, message := range map[byte]string{
+        1: "does not support MQTT 3.1.1",
+        2: "rejected the client id",
+        3: "unavailable",
+        4: "username or password is wrong",
+        5: "not authorized",
+    } {
+        ds, done := startFakeBroker(t, func(b *fakeBroker) {
+            b.connect(code)
+        })
+        session, err := testMqttClient().Listen(context.Background(), ds, 2*time.Second, 10)
+        <-done
+        var refused *MqttRefusedError
+        if !errors.As(err, &refused) || refused.Code != code || !strings.Contains(err.Error(), message) {
+            t.Errorf("code %d: expected a refusal with \"%s\", got %v", code, message, err)
+        }
+        if session.Connected || session.Subscribed {
+            t.Errorf("code %d: unexpected session %+v", code, session)
+        }
+    }
+}
+
+func TestListenSendsTheCredentials(t *testing.T) {
+    ds, done := startFakeBroker(t, func(b *fakeBroker) {
+        connect := b.connect(0)
+        if connect.ProtocolName != "MQTT" || connect.ProtocolVersion != 4 || !connect.CleanSession {
+            t.Errorf("CONNECT is not MQTT 3.1.1 with clean session: %v", connect)
+        }
+        if connect.Username != "niclas" || string(connect.Password) != "secret" {
+            t.Errorf("CONNECT does not have the username and password: %v", connect)
+        }
+        b.subscribe(0)
+        b.disconnect()
+    })
+    ds.Username = "niclas"
+    ds.Password = "secret"
+    _, err := testMqttClient().Listen(context.Background(), ds, 200*time.Millisecond, 10)
+    <-done
+    if err != nil {
+        t.Error(err)
+    }
+}
+
+func TestListenReportsRefusedSubscriptions(t *testing.T) {
+    ds, done := startFakeBroker(t, func(b *fakeBroker) {
+        b.connect(0)
+        b.subscribe(0x80)
+    })
+    session, err := testMqttClient().Listen(context.Background(), ds, 2*time.Second, 10)
+    <-done
+    var refused *MqttRefusedError
+    if !errors.As(err, &refused) || refused.Code != 0x80 || !strings.Contains(err.Error(), "garden/shed/#") {
+        t.Errorf("expected the subscription to be refused, got %v", err)
+    }
+    if !session.Connected || session.Subscribed {
+        t.Errorf("unexpected session %+v", session)
+    }
+}
+
+func TestListenAcknowledgesMessages(t *testing.T) {
+    ds, done := startFakeBroker(t, func(b *fakeBroker) {
+        b.connect(0)
+        if topic := b.subscribe(0); topic != "garden/shed/#" {
+            t.Errorf("subscribed to %s", topic)
+        }
+        // QoS 1
+        b.publish(1, false, 7, "garden/shed/a", "1")
+        if puback, ok := b.read().(*packets.PubackPacket); !ok || puback.MessageID != 7 {
+            t.Errorf("expected PUBACK of 7")
+        }
+        // QoS 2
+        b.publish(2, false, 9, "garden/shed/b", "2")
+        if pubrec, ok := b.read().(*packets.PubrecPacket); !ok || pubrec.MessageID != 9 {
+            t.Errorf("expected PUBREC of 9")
+        }
+        pubrel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+        pubrel.MessageID = 9
+        b.send(pubrel)
+        if pubcomp, ok := b.read().(*packets.PubcompPacket); !ok || pubcomp.MessageID != 9 {
+            t.Errorf("expected PUBCOMP of 9")
+        }
+        // QoS 0, retained
+        b.publish(0, true, 0, "garden/shed/c", "3")
+        b.disconnect()
+    })
+    session, err := testMqttClient().Listen(context.Background(), ds, 2*time.Second, 3)
+    <-done
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !session.Connected || !session.Subscribed || len(session.Messages) != 3 {
+        t.Fatalf("unexpected session %+v", session)
+    }
+    for i, expected := range []MqttMessage{
+        {Topic: "garden/shed/a", Payload: []byte("1")},
+        {Topic: "garden/shed/b", Payload: []byte("2")},
+        {Topic: "garden/shed/c", Payload: []byte("3"), Retained: true},
+    } {
+        message := session.Messages[i]
+        if message.Topic != expected.Topic || !bytes.Equal(message.Payload, expected.Payload) || message.Retained != expected.Retained {
+            t.Errorf("message %d is %+v, expected %+v", i, message, expected)
+        }
+    }
+}
+
+func TestListenEndsQuietSessionsAfterTheDuration(t *testing.T) {
+    ds, done := startFakeBroker(t, func(b *fakeBroker) {
+        b.connect(0)
+        b.subscribe(0)
+        b.disconnect()
+    })
+    start := time.Now()
+    session, err := testMqttClient().Listen(context.Background(), ds, 300*time.Millisecond, 10)
+    <-done
+    if err != nil || !session.Subscribed || len(session.Messages) != 0 {
+        t.Errorf("unexpected session %+v, error %v", session, err)
+    }
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Errorf("the session lasted %s", elapsed)
+    }
+}
+
+func TestListenOverWebsocketsDefaultsToTheMqttPath(t *testing.T) {
+    for address, expectedPath := range map[string]string{
+        "127.0.0.1":           "/mqtt",
+        "127.0.0.1/custom/ws": "/custom/ws",
+    } {
+        paths := make(chan string, 1)
+        server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+            paths <- conn.Request().URL.Path
+            conn.PayloadType = websocket.BinaryFrame
+            b := &fakeBroker{t: t, conn: conn}
+            b.connect(0)
+            b.subscribe(0)
+            b.disconnect()
+        }))
+        port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+        ds := model.MqttDatasource{Protocol: "ws", Address: address, Port: uint16(port), Topic: "garden/#"}
+        session, err := testMqttClient().Listen(context.Background(), ds, 300*time.Millisecond, 10)
+        server.Close()
+        if err != nil || !session.Subscribed {
+            t.Errorf("%s: unexpected session %+v, error %v", address, session, err)
+        }
+        select {
+        case path := <-paths:
+            if path != expectedPath {
+                t.Errorf("%s: connected to path %s, expected %s", address, path, expectedPath)
+            }
+        default:
+            t.Errorf("%s: the websocket was not opened", address)
+        }
+    }
+}
+
+func TestListenRefusesUnknownProtocols(t *testing.T) {
+    ds := model.MqttDatasource{Protocol: "alis", Address: "127.0.0.1", Port: 1883, Topic: "#"}
+    _, err := testMqttClient().Listen(context.Background(), ds, time.Second, 10)
+    if err == nil || !strings.Contains(err.Error(), "not supported") {
+        t.Errorf("expected the protocol to be refused, got %v", err)
+    }
+}
@@ -3,6 +3,7 @@ package client
 import (
     "context"
     "fmt"
+    "sync"
     "time"
 
     "github.com/Sensetif/sensetif-datasource/pkg/model"
@@ -28,6 +29,11 @@ func (p *PulsarClient) CreateReader(topic string, earliest bool) pulsar.Reader {
     } else {
         start = pulsar.LatestMessageID()
     }
+    return p.CreateReaderAt(topic, start)
+}
+
+// CreateReaderAt creates a reader that starts after the given message, e.g. to continue where a failed reader stopped.
+func (p *PulsarClient) CreateReaderAt(topic string, start pulsar.MessageID) pulsar.Reader {
     reader, err := p.client.CreateReader(pulsar.ReaderOptions{
         Topic:          topic,
         StartMessageID: start,
@@ -39,6 +45,16 @@ func (p *PulsarClient) CreateReader(topic string, earliest bool) pulsar.Reader {
 }
 
 func (p *PulsarClient) Send(topic string, key string, value []byte) pulsar.MessageID {
+    return p.send(topic, key, nil, value)
+}
+
+// SendCommand sends the value with the command id as a property, which the backend returns on the
+// model.CommandResultTopic when the message has been processed.
+func (p *PulsarClient) SendCommand(topic string, key string, commandId string, value []byte) pulsar.MessageID {
+    return p.send(topic, key, map[string]string{model.CommandIdProperty: commandId}, value)
+}
+
+func (p *PulsarClient) send(topic string, key string, properties map[string]string, value []byte) pulsar.MessageID {
     topic = model.MainNamespace + "/" + topic
     parts, e := p.client.TopicPartitions(topic)
     if e != nil {
@@ -52,8 +68,9 @@ func (p *PulsarClient) Send(topic string, key string, value []byte) pulsar.Messa
         return nil
     }
     message := &pulsar.ProducerMessage{
-        Payload: value,
-        Key:     key,
+        Payload:    value,
+        Key:        key,
+        Properties: properties,
     }
     msgId, err := producer.Send(context.Background(), message)
     if err != nil {
@@ -64,6 +81,67 @@ func (p *PulsarClient) Send(topic string, key string, value []byte) pulsar.Messa
     return msgId
 }
 
+// SendBatch publishes all values asynchronously and waits until they have been acknowledged. It returns the number
+// of values that were sent successfully, and the first error that occurred.
+func (p *PulsarClient) SendBatch(topic string, key string, values [][]byte) (int, error) {
+    errs := p.SendEach(topic, key, values)
+    sent := 0
+    var firstErr error
+    for _, err := range errs {
+        if err == nil {
+            sent++
+        } else if firstErr == nil {
+            firstErr = err
+        }
+    }
+    return sent, firstErr
+}
+
+// SendEach publishes all values asynchronously and waits until they have been acknowledged. It returns the error of
+// each value, which is nil for the values that were sent.
+func (p *PulsarClient) SendEach(topic string, key string, values [][]byte) []error {
+    topic = model.MainNamespace + "/" + topic
+    errs := make([]error, len(values))
+    producer := p.getOrCreateProducer(topic)
+    if producer == nil {
+        for i := range errs {
+            errs[i] = fmt.Errorf("no producer for topic %s", topic)
+        }
+        return errs
+    }
+    var wg sync.WaitGroup
+    var mutex sync.Mutex
+    failed := 0
+    for i, value := range values {
+        wg.Add(1)
+        message := &pulsar.ProducerMessage{
+            Payload: value,
+            Key:     key,
+        }
+        index := i
+        producer.SendAsync(context.Background(), message, func(_ pulsar.MessageID, _ *pulsar.ProducerMessage, err error) {
+            mutex.Lock()
+            defer mutex.Unlock()
+            if err != nil {
+                errs[index] = err
+                failed++
+            }
+            wg.Done()
+        })
+    }
+    err := producer.Flush()
+    if err != nil {
+        log.DefaultLogger.Error(fmt.Sprintf("Failed to flush producer for topic %s - Error=%+v", topic, err))
+    }
+    wg.Wait()
+    if failed > 0 {
+        log.DefaultLogger.Error(fmt.Sprintf("Failed to send %d of %d messages on topic %s", failed, len(values), topic))
+    } else {
+        log.DefaultLogger.Info(fmt.Sprintf("Sent %d messages on topic %s with key %s", len(values), topic, key))
+    }
+    return errs
+}
+
 func (p *PulsarClient) getOrCreateProducer(topic string) pulsar.Producer {
     producer := p.producers[topic]
     if producer == nil {
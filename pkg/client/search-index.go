@@ -0,0 +1,144 @@
+package client
+
+import (
+    "fmt"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// The index is rebuilt when a configuration command has been applied, but changes made through other plugin
+// instances are only picked up when it expires.
+const searchIndexTime = 10 * time.Minute
+
+type searchIndex struct {
+    // building is held while the index is read and built, so that the searches that find it outdated at the same time,
+    // like right after a configuration command, wait for one build instead of each reading all of Cassandra.
+    building sync.Mutex
+    entries  []model.SearchEntry
+    built    time.Time
+}
+
+// SearchIndexes holds an index per organization of the projects, subsystems and datapoints to search through. An
+// index is built on the first search, and rebuilt when it is outdated.
+type SearchIndexes struct {
+    cassandra Cassandra
+    commands  *CommandTracker
+    mutex     sync.Mutex // guards indexes, not the builds
+    indexes   map[int64]*searchIndex
+}
+
+func CreateSearchIndexes(cassandra Cassandra, commands *CommandTracker) *SearchIndexes {
+    return &SearchIndexes{
+        cassandra: cassandra,
+        commands:  commands,
+        indexes:   make(map[int64]*searchIndex),
+    }
+}
+
+// Entries returns the index of the organization, and when it was built.
+func (s *SearchIndexes) Entries(orgId int64) ([]model.SearchEntry, time.Time, error) {
+    s.mutex.Lock()
+    index, found := s.indexes[orgId]
+    if !found {
+        index = &searchIndex{}
+        s.indexes[orgId] = index
+    }
+    s.mutex.Unlock()
+
+    index.building.Lock()
+    defer index.building.Unlock()
+    if index.entries != nil && time.Since(index.built) < searchIndexTime && index.built.After(s.commands.LastChange(orgId)) {
+        return index.entries, index.built, nil
+    }
+    built := time.Now()
+    entries, err := s.build(orgId)
+    if err != nil {
+        return nil, built, err
+    }
+    index.entries = entries
+    index.built = built
+    log.DefaultLogger.Info(fmt.Sprintf("Indexed %d entries of %d", len(entries), orgId))
+    return entries, built, nil
+}
+
+func (s *SearchIndexes) build(orgId int64) ([]model.SearchEntry, error) {
+    entries := make([]model.SearchEntry, 0)
+    projects, err := s.cassandra.FindAllProjects(orgId)
+    if err != nil {
+        return nil, err
+    }
+    for _, project := range projects {
+        projectFields := []model.SearchField{
+            {Name: "project", Text: project.Name, Weight: model.ParentWeight},
+            {Name: "project.title", Text: project.Title, Weight: model.ParentWeight},
+            {Name: "project.city", Text: project.City, Weight: model.ParentWeight},
+        }
+        entries = append(entries, model.SearchEntry{
+            Kind:  "project",
+            Path:  project.Name,
+            Title: project.Title,
+            Fields: []model.SearchField{
+                {Name: "name", Text: project.Name, Weight: model.NameWeight},
+                {Name: "title", Text: project.Title, Weight: model.TitleWeight},
+                {Name: "city", Text: project.City, Weight: model.LocationWeight},
+                {Name: "country", Text: project.Country, Weight: model.LocationWeight},
+            },
+        })
+        subsystems, err := s.cassandra.FindAllSubsystems(orgId, project.Name)
+        if err != nil {
+            return nil, err
+        }
+        for _, subsystem := range subsystems {
+            subsystemPath := project.Name + "/" + subsystem.Name
+            subsystemFields := append([]model.SearchField{
+                {Name: "subsystem", Text: subsystem.Name, Weight: model.ParentWeight},
+                {Name: "subsystem.title", Text: subsystem.Title, Weight: model.ParentWeight},
+                {Name: "subsystem.location", Text: subsystem.Locallocation, Weight: model.ParentWeight},
+            }, projectFields...)
+            entries = append(entries, model.SearchEntry{
+                Kind:  "subsystem",
+                Path:  subsystemPath,
+                Title: subsystem.Title,
+                Fields: append([]model.SearchField{
+                    {Name: "name", Text: subsystem.Name, Weight: model.NameWeight},
+                    {Name: "title", Text: subsystem.Title, Weight: model.TitleWeight},
+                    {Name: "location", Text: subsystem.Locallocation, Weight: model.LocationWeight},
+                }, projectFields...),
+            })
+            datapoints, err := s.cassandra.FindAllDatapoints(orgId, project.Name, subsystem.Name)
+            if err != nil {
+                return nil, err
+            }
+            for _, datapoint := range datapoints {
+                entries = append(entries, model.SearchEntry{
+                    Kind:  "datapoint",
+                    Path:  subsystemPath + "/" + datapoint.Name,
+                    Title: datapoint.Name,
+                    Fields: append([]model.SearchField{
+                        {Name: "name", Text: datapoint.Name, Weight: model.NameWeight},
+                        {Name: "unit", Text: datapoint.Proc.Unit, Weight: model.UnitWeight},
+                        {Name: "address", Text: addressOf(datapoint), Weight: model.AddressWeight},
+                    }, subsystemFields...),
+                })
+            }
+        }
+    }
+    return entries, nil
+}
+
+// addressOf returns where the datapoint reads its values from; the URL, the MQTT topic or the TTN device.
+func addressOf(datapoint model.DatapointSettings) string {
+    switch ds := datapoint.Datasource.(type) {
+    case model.WebDatasource:
+        return ds.URL
+    case model.MqttDatasource:
+        return ds.Address + ":" + strconv.Itoa(int(ds.Port)) + " " + ds.Topic
+    case model.Ttnv3Datasource:
+        return ds.Application + " " + ds.Device + " " + ds.Point
+    }
+    return ""
+}
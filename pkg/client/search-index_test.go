@@ -0,0 +1,46 @@
+package client
+
+import (
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// slowProjects is a Cassandra with one project and nothing in it, which takes a while to list the projects.
+type slowProjects struct {
+    Cassandra
+    reads int32
+}
+
+func (s *slowProjects) FindAllProjects(_ int64) ([]model.ProjectSettings, error) {
+    atomic.AddInt32(&s.reads, 1)
+    time.Sleep(20 * time.Millisecond)
+    return []model.ProjectSettings{{Name: "garden", Title: "Garden"}}, nil
+}
+
+func (s *slowProjects) FindAllSubsystems(_ int64, _ string) ([]model.SubsystemSettings, error) {
+    return nil, nil
+}
+
+func TestConcurrentSearchesBuildTheIndexOnce(t *testing.T) {
+    cassandra := &slowProjects{}
+    indexes := CreateSearchIndexes(cassandra, CreateCommandTracker())
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            entries, _, err := indexes.Entries(1)
+            if err != nil || len(entries) != 1 || entries[0].Path != "garden" {
+                t.Errorf("unexpected index %+v, %v", entries, err)
+            }
+        }()
+    }
+    wg.Wait()
+    if cassandra.reads != 1 {
+        t.Errorf("the index was built %d times", cassandra.reads)
+    }
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// The number of devices in each page that ListDevices reads.
+const ttnDevicesPageSize = 1000
+
+// TtnClient reads devices and stored uplinks from The Things Network v3 API. Http and BaseURL can be replaced, e.g. by
+// those of a local fake server. Without BaseURL, the zone of the datasource decides the server; "eu1" is
+// https://eu1.cloud.thethings.network, and a zone with a dot is taken as the host of a private deployment.
+type TtnClient struct {
+    Http    *http.Client
+    BaseURL string
+}
+
+// TtnError is an error response of the TTN API.
+type TtnError struct {
+    Status  int
+    Message string
+}
+
+func (e *TtnError) Error() string {
+    return fmt.Sprintf("TTN responded %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// CreateTtnClient uses the same guarded transport as the WebClient, as private deployments are given by users.
+func CreateTtnClient() *TtnClient {
+    return &TtnClient{Http: CreateWebClient().Http}
+}
+
+func (c *TtnClient) baseURL(zone string) string {
+    if c.BaseURL != "" {
+        return strings.TrimSuffix(c.BaseURL, "/")
+    }
+    if strings.Contains(zone, ".") {
+        return "https://" + zone
+    }
+    return "https://" + zone + ".cloud.thethings.network"
+}
+
+// ListDevices returns the end devices of the application of the datasource, following the pages of the list until
+// all devices, as counted by the X-Total-Count header, have been read.
+func (c *TtnClient) ListDevices(ctx context.Context, ds model.Ttnv3Datasource) ([]model.TtnDevice, error) {
+    devices := make([]model.TtnDevice, 0)
+    for page := 1; ; page++ {
+        query := url.Values{
+            "field_mask": {"name,description"},
+            "order":      {"device_id"},
+            "limit":      {strconv.Itoa(ttnDevicesPageSize)},
+            "page":       {strconv.Itoa(page)},
+        }
+        response, err := c.get(ctx, ds, "/api/v3/applications/"+url.PathEscape(ds.Application)+"/devices", query)
+        if err != nil {
+            return nil, err
+        }
+        var list struct {
+            EndDevices []struct {
+                Ids struct {
+                    DeviceId string `json:"device_id"`
+                    DevEui   string `json:"dev_eui"`
+                } `json:"ids"`
+                Name        string     `json:"name"`
+                Description string     `json:"description"`
+                LastSeenAt  *time.Time `json:"last_seen_at"`
+            } `json:"end_devices"`
+        }
+        err = json.NewDecoder(response.Body).Decode(&list)
+        _ = response.Body.Close()
+        if err != nil {
+            return nil, fmt.Errorf("unable to read the devices: %s", err.Error())
+        }
+        for _, device := range list.EndDevices {
+            devices = append(devices, model.TtnDevice{
+                Id:          device.Ids.DeviceId,
+                DevEui:      device.Ids.DevEui,
+                Name:        device.Name,
+                Description: device.Description,
+                LastSeen:    device.LastSeenAt,
+            })
+        }
+        total, err := strconv.Atoi(response.Header.Get("X-Total-Count"))
+        if err != nil {
+            total = 0 // without the count, a short page is the last one
+        }
+        if len(list.EndDevices) < ttnDevicesPageSize || (total > 0 && len(devices) >= total) {
+            return devices, nil
+        }
+    }
+}
+
+// RecentUplinks returns up to limit of the latest uplinks of the device of the datasource, or of the whole
+// application if the device is empty, newest first. They are kept by the Storage Integration, which must be enabled
+// for the application.
+func (c *TtnClient) RecentUplinks(ctx context.Context, ds model.Ttnv3Datasource, limit int) ([]model.TtnUplink, error) {
+    path := "/api/v3/as/applications/" + url.PathEscape(ds.Application)
+    if ds.Device != "" {
+        path += "/devices/" + url.PathEscape(ds.Device)
+    }
+    path += "/packages/storage/uplink_message"
+    query := url.Values{"limit": {strconv.Itoa(limit)}, "order": {"-received_at"}, "field_mask": {"up.uplink_message.decoded_payload,up.uplink_message.f_port"}}
+    response, err := c.get(ctx, ds, path, query)
+    if err != nil {
+        return nil, err
+    }
+    defer response.Body.Close()
+    // the response is a stream of JSON objects, one per uplink
+    uplinks := make([]model.TtnUplink, 0, limit)
+    decoder := json.NewDecoder(response.Body)
+    for decoder.More() {
+        var message struct {
+            Result struct {
+                EndDeviceIds struct {
+                    DeviceId string `json:"device_id"`
+                } `json:"end_device_ids"`
+                ReceivedAt    time.Time `json:"received_at"`
+                UplinkMessage struct {
+                    FPort          int32                  `json:"f_port"`
+                    DecodedPayload map[string]interface{} `json:"decoded_payload"`
+                } `json:"uplink_message"`
+            } `json:"result"`
+        }
+        err = decoder.Decode(&message)
+        if err != nil {
+            return nil, fmt.Errorf("unable to read the uplinks: %s", err.Error())
+        }
+        uplinks = append(uplinks, model.TtnUplink{
+            Device:   message.Result.EndDeviceIds.DeviceId,
+            Port:     message.Result.UplinkMessage.FPort,
+            Received: message.Result.ReceivedAt,
+            Fields:   message.Result.UplinkMessage.DecodedPayload,
+        })
+    }
+    sort.SliceStable(uplinks, func(i, j int) bool {
+        return uplinks[i].Received.After(uplinks[j].Received)
+    })
+    return uplinks, nil
+}
+
+// get returns the response if its status is 200, and a TtnError otherwise.
+func (c *TtnClient) get(ctx context.Context, ds model.Ttnv3Datasource, path string, query url.Values) (*http.Response, error) {
+    request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL(ds.Zone)+path+"?"+query.Encode(), nil)
+    if err != nil {
+        return nil, err
+    }
+    request.Header.Set("Authorization", "Bearer "+ds.AuthorizationKey)
+    request.Header.Set("Accept", "application/json")
+    response, err := c.Http.Do(request)
+    if err != nil {
+        return nil, err
+    }
+    if response.StatusCode != http.StatusOK {
+        defer response.Body.Close()
+        var problem struct {
+            Message string `json:"message"`
+        }
+        data, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+        if json.Unmarshal(data, &problem) != nil || problem.Message == "" {
+            problem.Message = strings.TrimSpace(string(data))
+        }
+        return nil, &TtnError{Status: response.StatusCode, Message: problem.Message}
+    }
+    return response, nil
+}
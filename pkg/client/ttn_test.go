@@ -0,0 +1,155 @@
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+var testTtnDatasource = model.Ttnv3Datasource{Zone: "eu1", Application: "garden", AuthorizationKey: "NNSXS.SECRET"}
+
+func startFakeTtn(t *testing.T, handler http.HandlerFunc) *TtnClient {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Authorization") != "Bearer NNSXS.SECRET" {
+            w.WriteHeader(http.StatusUnauthorized)
+            _, _ = w.Write([]byte(`{"code": 16, "message": "error:pkg/auth:token_not_found (token not found)"}`))
+            return
+        }
+        handler(w, r)
+    }))
+    t.Cleanup(server.Close)
+    return &TtnClient{Http: server.Client(), BaseURL: server.URL + "/"}
+}
+
+func TestListDevicesFollowsThePages(t *testing.T) {
+    const total = ttnDevicesPageSize + 500
+    var pages []string
+    ttn := startFakeTtn(t, func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/api/v3/applications/garden/devices" {
+            http.NotFound(w, r)
+            return
+        }
+        page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+        limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+        pages = append(pages, r.URL.Query().Get("page"))
+        devices := []map[string]interface{}{}
+        for i := (page - 1) * limit; i < page*limit && i < total; i++ {
+            devices = append(devices, map[string]interface{}{
+                "ids":  map[string]string{"device_id": fmt.Sprintf("device-%04d", i), "dev_eui": "70B3D57ED0000000"},
+                "name": fmt.Sprintf("Device %d", i),
+            })
+        }
+        w.Header().Set("X-Total-Count", strconv.Itoa(total))
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"end_devices": devices})
+    })
+    devices, err := ttn.ListDevices(context.Background(), testTtnDatasource)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(devices) != total {
+        t.Fatalf("got %d devices, expected %d", len(devices), total)
+    }
+    if devices[0].Id != "device-0000" || devices[total-1].Id != fmt.Sprintf("device-%04d", total-1) || devices[0].DevEui != "70B3D57ED0000000" {
+        t.Errorf("unexpected devices %+v ... %+v", devices[0], devices[total-1])
+    }
+    if len(pages) != 2 || pages[0] != "1" || pages[1] != "2" {
+        t.Errorf("read pages %v", pages)
+    }
+}
+
+func TestListDevicesStopsAtAShortPageWithoutTotalCount(t *testing.T) {
+    requests := 0
+    ttn := startFakeTtn(t, func(w http.ResponseWriter, r *http.Request) {
+        requests++
+        _, _ = w.Write([]byte(`{"end_devices": [{"ids": {"device_id": "shed-sensor"}, "last_seen_at": "2022-03-01T10:15:30Z"}]}`))
+    })
+    devices, err := ttn.ListDevices(context.Background(), testTtnDatasource)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if requests != 1 || len(devices) != 1 || devices[0].Id != "shed-sensor" || devices[0].LastSeen == nil {
+        t.Errorf("unexpected devices %+v after %d requests", devices, requests)
+    }
+}
+
+func TestRecentUplinksReadsTheStream(t *testing.T) {
+    var query string
+    ttn := startFakeTtn(t, func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/api/v3/as/applications/garden/devices/shed-sensor/packages/storage/uplink_message" {
+            http.NotFound(w, r)
+            return
+        }
+        query = r.URL.RawQuery
+        // the Storage Integration streams one object per uplink, not an array
+        _, _ = w.Write([]byte(`{"result": {"end_device_ids": {"device_id": "shed-sensor"}, "received_at": "2022-03-01T10:00:00Z",
+            "uplink_message": {"f_port": 2, "decoded_payload": {"temperature": 20.5}}}}
+{"result": {"end_device_ids": {"device_id": "shed-sensor"}, "received_at": "2022-03-01T10:15:00Z",
+            "uplink_message": {"f_port": 2, "decoded_payload": {"temperature": 21.5, "battery": {"volt": 3.3}}}}}
+`))
+    })
+    ds := testTtnDatasource
+    ds.Device = "shed-sensor"
+    uplinks, err := ttn.RecentUplinks(context.Background(), ds, 20)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(uplinks) != 2 {
+        t.Fatalf("got %d uplinks", len(uplinks))
+    }
+    if !uplinks[0].Received.Equal(time.Date(2022, 3, 1, 10, 15, 0, 0, time.UTC)) || uplinks[0].Fields["temperature"] != 21.5 || uplinks[0].Port != 2 {
+        t.Errorf("the newest uplink is not first: %+v", uplinks)
+    }
+    if query != "field_mask=up.uplink_message.decoded_payload%2Cup.uplink_message.f_port&limit=20&order=-received_at" {
+        t.Errorf("unexpected query %s", query)
+    }
+}
+
+func TestRecentUplinksReportsBrokenStreams(t *testing.T) {
+    ttn := startFakeTtn(t, func(w http.ResponseWriter, r *http.Request) {
+        _, _ = w.Write([]byte(`{"result": {"received_at": "2022-03-01T10:00:00Z"}} {"result": `))
+    })
+    _, err := ttn.RecentUplinks(context.Background(), testTtnDatasource, 20)
+    if err == nil {
+        t.Error("expected an error for the cut stream")
+    }
+}
+
+func TestTtnErrorResponses(t *testing.T) {
+    ttn := startFakeTtn(t, func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusNotFound)
+        _, _ = w.Write([]byte("no such application"))
+    })
+    _, err := ttn.ListDevices(context.Background(), testTtnDatasource)
+    var ttnErr *TtnError
+    if !errors.As(err, &ttnErr) || ttnErr.Status != http.StatusNotFound || ttnErr.Message != "no such application" {
+        t.Errorf("expected a 404 TtnError with the plain text message, got %v", err)
+    }
+
+    ds := testTtnDatasource
+    ds.AuthorizationKey = "NNSXS.WRONG"
+    _, err = ttn.RecentUplinks(context.Background(), ds, 20)
+    if !errors.As(err, &ttnErr) || ttnErr.Status != http.StatusUnauthorized || ttnErr.Message != "error:pkg/auth:token_not_found (token not found)" {
+        t.Errorf("expected a 401 TtnError with the message of the JSON body, got %v", err)
+    }
+}
+
+func TestTtnBaseURLOfZones(t *testing.T) {
+    ttn := &TtnClient{}
+    for zone, expected := range map[string]string{
+        "eu1":                "https://eu1.cloud.thethings.network",
+        "nam1":               "https://nam1.cloud.thethings.network",
+        "lorawan.example.se": "https://lorawan.example.se",
+    } {
+        if baseURL := ttn.baseURL(zone); baseURL != expected {
+            t.Errorf("zone %s has base URL %s, expected %s", zone, baseURL, expected)
+        }
+    }
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// The largest document that is read from a web datasource.
+const maxWebDocumentSize = 1 << 20
+
+const webFetchTimeout = 10 * time.Second
+
+// WebClient fetches the documents of web datasources. Http can be replaced, e.g. by the client of a local
+// httptest.Server.
+type WebClient struct {
+    Http *http.Client
+}
+
+// CreateWebClient returns a client that refuses to connect to loopback, private and link-local addresses, as the URLs
+// are given by users and the plugin runs next to Grafana and the cloud metadata services. Set
+// SENSETIF_ALLOW_PRIVATE_URLS=true where datasources on the local network are expected.
+func CreateWebClient() *WebClient {
+    dialer := &net.Dialer{Timeout: webFetchTimeout}
+    if os.Getenv("SENSETIF_ALLOW_PRIVATE_URLS") != "true" {
+        dialer.Control = refusePrivateAddresses
+    }
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.DialContext = dialer.DialContext
+    transport.Proxy = nil // a proxy would connect on our behalf, without the check
+    return &WebClient{
+        Http: &http.Client{
+            Transport: transport,
+            Timeout:   webFetchTimeout,
+        },
+    }
+}
+
+// refusePrivateAddresses is called with the resolved address, so a host name pointing to a private address is
+// refused too.
+func refusePrivateAddresses(_ string, address string, _ syscall.RawConn) error {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+    ip := net.ParseIP(host)
+    if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+        return fmt.Errorf("connecting to %s is not allowed", host)
+    }
+    return nil
+}
+
+// WebDocument is a fetched document. Body is cut at maxWebDocumentSize, which Truncated tells.
+type WebDocument struct {
+    Status      int
+    ContentType string
+    Body        []byte
+    Truncated   bool
+    Received    time.Time
+    Duration    time.Duration
+}
+
+// Fetch gets the document of the datasource, with the authentication of the datasource.
+func (c *WebClient) Fetch(ctx context.Context, ds model.WebDatasource) (WebDocument, error) {
+    var document WebDocument
+    request, err := http.NewRequestWithContext(ctx, http.MethodGet, ds.URL, nil)
+    if err != nil {
+        return document, err
+    }
+    switch ds.AuthenticationType {
+    case model.Basic:
+        user, password, _ := strings.Cut(ds.Auth, "=")
+        request.SetBasicAuth(user, password)
+    case model.BearerToken:
+        request.Header.Set("Authorization", "Bearer "+ds.Auth)
+    }
+    switch ds.Format {
+    case model.JSON:
+        request.Header.Set("Accept", "application/json")
+    case model.XML:
+        request.Header.Set("Accept", "application/xml, text/xml")
+    }
+    start := time.Now()
+    response, err := c.Http.Do(request)
+    if err != nil {
+        return document, err
+    }
+    defer response.Body.Close()
+    document.Status = response.StatusCode
+    document.ContentType = response.Header.Get("Content-Type")
+    document.Body, err = io.ReadAll(io.LimitReader(response.Body, maxWebDocumentSize+1))
+    document.Received = time.Now()
+    document.Duration = document.Received.Sub(start)
+    if len(document.Body) > maxWebDocumentSize {
+        document.Body = document.Body[:maxWebDocumentSize]
+        document.Truncated = true
+    }
+    return document, err
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+func TestFetchSendsAuthenticationAndAccept(t *testing.T) {
+    tests := []struct {
+        name          string
+        ds            model.WebDatasource
+        authorization string
+        accept        string
+    }{
+        {"none", model.WebDatasource{AuthenticationType: model.None, Format: model.JSON}, "", "application/json"},
+        {"basic", model.WebDatasource{AuthenticationType: model.Basic, Auth: "niclas=se=cret", Format: model.XML}, "Basic bmljbGFzOnNlPWNyZXQ=", "application/xml, text/xml"},
+        {"bearer", model.WebDatasource{AuthenticationType: model.BearerToken, Auth: "abc", Format: model.JSON}, "Bearer abc", "application/json"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var authorization, accept string
+            server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                authorization = r.Header.Get("Authorization")
+                accept = r.Header.Get("Accept")
+                w.Header().Set("Content-Type", "application/json")
+                _, _ = w.Write([]byte(`{"temperature": 21.5}`))
+            }))
+            defer server.Close()
+            test.ds.URL = server.URL
+            document, err := (&WebClient{Http: server.Client()}).Fetch(context.Background(), test.ds)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if authorization != test.authorization {
+                t.Errorf("Authorization is %q, expected %q", authorization, test.authorization)
+            }
+            if accept != test.accept {
+                t.Errorf("Accept is %q, expected %q", accept, test.accept)
+            }
+            if document.Status != http.StatusOK || document.ContentType != "application/json" || string(document.Body) != `{"temperature": 21.5}` || document.Truncated {
+                t.Errorf("unexpected document %+v", document)
+            }
+        })
+    }
+}
+
+func TestFetchTruncatesLargeDocuments(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, _ = w.Write(bytes.Repeat([]byte("x"), maxWebDocumentSize+10))
+    }))
+    defer server.Close()
+    document, err := (&WebClient{Http: server.Client()}).Fetch(context.Background(), model.WebDatasource{URL: server.URL})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !document.Truncated || len(document.Body) != maxWebDocumentSize {
+        t.Errorf("expected %d bytes and truncated, got %d bytes and truncated=%v", maxWebDocumentSize, len(document.Body), document.Truncated)
+    }
+}
+
+func TestFetchReturnsErrorStatuses(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "no such sensor", http.StatusNotFound)
+    }))
+    defer server.Close()
+    document, err := (&WebClient{Http: server.Client()}).Fetch(context.Background(), model.WebDatasource{URL: server.URL})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if document.Status != http.StatusNotFound || !strings.Contains(string(document.Body), "no such sensor") {
+        t.Errorf("unexpected document %+v", document)
+    }
+}
+
+func TestFetchStopsWhenTheContextIsCancelled(t *testing.T) {
+    release := make(chan struct{})
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-r.Context().Done():
+        case <-release:
+        }
+    }))
+    defer server.Close()
+    defer close(release)
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    _, err := (&WebClient{Http: server.Client()}).Fetch(ctx, model.WebDatasource{URL: server.URL})
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("expected context.Canceled, got %v", err)
+    }
+}
+
+func TestCreateWebClientRefusesPrivateAddresses(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        t.Error("the request reached the loopback server")
+    }))
+    defer server.Close()
+    t.Setenv("SENSETIF_ALLOW_PRIVATE_URLS", "")
+    _, err := CreateWebClient().Fetch(context.Background(), model.WebDatasource{URL: server.URL})
+    if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+        t.Errorf("expected the loopback address to be refused, got %v", err)
+    }
+}
+
+func TestRefusePrivateAddresses(t *testing.T) {
+    tests := map[string]bool{
+        "127.0.0.1:80":       false,
+        "[::1]:443":          false,
+        "10.1.2.3:80":        false,
+        "192.168.1.10:8080":  false,
+        "169.254.169.254:80": false,
+        "0.0.0.0:80":         false,
+        "224.0.0.1:80":       false,
+        "93.184.216.34:443":  true,
+        "[2606:4700::1]:443": true,
+    }
+    for address, allowed := range tests {
+        err := refusePrivateAddresses("tcp", address, nil)
+        if (err == nil) != allowed {
+            t.Errorf("%s: allowed=%v, error %v", address, allowed, err)
+        }
+    }
+}
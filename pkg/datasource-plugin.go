@@ -4,11 +4,11 @@ import (
     "context"
     JSON "encoding/json"
     "fmt"
-    "strconv"
     "strings"
     "time"
 
     "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/handler"
     "github.com/Sensetif/sensetif-datasource/pkg/model"
     "github.com/grafana/grafana-plugin-sdk-go/backend"
     "github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
@@ -55,7 +55,7 @@ func (sds *SensetifDatasource) query(queryName string, orgId int64, query backen
     return sds.executeTimeseriesQuery(queryName, maxValues, qm.Parameters, orgId, query)
 }
 
-func (sds *SensetifDatasource) executeTimeseriesQuery(queryName string, maxValues int, /*parameters*/ _ string, orgId int64, query backend.DataQuery) backend.DataResponse {
+func (sds *SensetifDatasource) executeTimeseriesQuery(queryName string, maxValues int, parameters string, orgId int64, query backend.DataQuery) backend.DataResponse {
     from := query.TimeRange.From
     to := query.TimeRange.To
 
@@ -68,18 +68,77 @@ func (sds *SensetifDatasource) executeTimeseriesQuery(queryName string, maxValue
     var frame *data.Frame
     if model_.Project == "_" {
         projects, _ := sds.cassandraClient.FindAllProjects(orgId)
-        frame = formatProjectsQuery(queryName, projects)
+        frame = sds.formatProjectsQuery(queryName, orgId, projects)
     } else if model_.Project == "_alarms" {
         //alarmStates := sds.cassandraClient.QueryAlarmStates(orgId, model_)
         //frame = FormatAlarmsQuery(queryName, alarmStates)
+    } else if model_.Project == "_freshness" {
+        // parameters may narrow the report down to "{project}" or "{project}/{subsystem}"
+        scope := append(strings.SplitN(parameters, "/", 2), "")
+        now := time.Now()
+        datapoints, err := handler.EvaluateFreshness(sds.cassandraClient, orgId, strings.TrimSpace(scope[0]), strings.TrimSpace(scope[1]), now)
+        if err != nil {
+            response.Error = err
+            return response
+        }
+        frame = formatFreshnessQuery(queryName, model.NewFreshnessReport(now, datapoints), datapoints)
     } else {
         timeseries := sds.cassandraClient.QueryTimeseries(orgId, model_, from, to, maxValues)
+        timeseries, notices := sds.scaleTimeseries(orgId, model_, timeseries)
         frame = formatTimeseriesQuery(queryName, timeseries, frame)
+        frame.AppendNotices(notices...)
     }
     response.Frames = append(response.Frames, frame)
     return response
 }
 
+// scaleTimeseries applies the custom scaling function of the datapoint, Processing.ScaleFunc, to the stored values.
+// Values that the function isn't defined for, like 0 for ln(x), are left out, with a notice telling how many. A
+// function that doesn't compile, e.g. one saved before Compile checked what it checks now, leaves the values unscaled,
+// with a notice.
+func (sds *SensetifDatasource) scaleTimeseries(orgId int64, ref model.SensorRef, timeseries []model.TsPair) ([]model.TsPair, []data.Notice) {
+    if len(timeseries) == 0 {
+        return timeseries, nil
+    }
+    datapoint, err := sds.cassandraClient.GetDatapoint(orgId, ref.Project, ref.Subsystem, ref.Datapoint)
+    if err != nil {
+        log.DefaultLogger.Warn(fmt.Sprintf("Unable to read datapoint %s/%s/%s: %s", ref.Project, ref.Subsystem, ref.Datapoint, err.Error()))
+        return timeseries, nil
+    }
+    fn, err := datapoint.Proc.ScaleFunction()
+    if err != nil {
+        log.DefaultLogger.Warn(fmt.Sprintf("The scalefunc of %s/%s/%s is invalid: %s", ref.Project, ref.Subsystem, ref.Datapoint, err.Error()))
+        notice := data.Notice{
+            Severity: data.NoticeSeverityWarning,
+            Text:     fmt.Sprintf("The values are not scaled, as the scalefunc of the datapoint is invalid: %s", err.Error()),
+        }
+        return timeseries, []data.Notice{notice}
+    }
+    if fn == nil {
+        return timeseries, nil
+    }
+    scaled := make([]model.TsPair, 0, len(timeseries))
+    var firstErr error
+    for _, t := range timeseries {
+        value, err := fn.Eval(t.Value)
+        if err != nil {
+            if firstErr == nil {
+                firstErr = err
+            }
+            continue
+        }
+        scaled = append(scaled, model.TsPair{TS: t.TS, Value: value})
+    }
+    if firstErr == nil {
+        return scaled, nil
+    }
+    notice := data.Notice{
+        Severity: data.NoticeSeverityWarning,
+        Text:     fmt.Sprintf("%d of %d values are left out, as the scalefunc is not defined for them, e.g. %s", len(timeseries)-len(scaled), len(timeseries), firstErr.Error()),
+    }
+    return scaled, []data.Notice{notice}
+}
+
 func formatTimeseriesQuery(queryName string, timeseries []model.TsPair, frame *data.Frame) *data.Frame {
     times := []time.Time{}
     values := []float64{}
@@ -94,23 +153,84 @@ func formatTimeseriesQuery(queryName string, timeseries []model.TsPair, frame *d
     return frame
 }
 
-func formatProjectsQuery(queryName string, projects []model.ProjectSettings) *data.Frame {
-    lats := []float64{}
-    longs := []float64{}
+// formatProjectsQuery creates the frame for the Geomap panel. Projects without a valid geolocation are included with
+// empty coordinates, so that they still show up in tables. Severity is the worst current alarm state among the
+// datapoints of the project.
+func (sds *SensetifDatasource) formatProjectsQuery(queryName string, orgId int64, projects []model.ProjectSettings) *data.Frame {
+    datapointCounts, err := sds.cassandraClient.CountDatapointsPerProject(orgId)
+    if err != nil {
+        log.DefaultLogger.Error(fmt.Sprintf("Unable to count datapoints of %d: %s", orgId, err.Error()))
+    }
+    lats := []*float64{}
+    longs := []*float64{}
+    names := []string{}
     titles := []string{}
+    cities := []string{}
+    countries := []string{}
+    counts := []int64{}
+    severities := []string{}
     for _, t := range projects {
-        latlong := strings.Split(t.Geolocation, ",")
-        lat, _ := strconv.ParseFloat(strings.TrimSpace(latlong[0]), 32)
-        lng, _ := strconv.ParseFloat(strings.TrimSpace(latlong[1]), 32)
+        var lat, lng *float64
+        location, err := t.Location()
+        if err == nil {
+            lat = &location.Latitude
+            lng = &location.Longitude
+        } else if strings.TrimSpace(t.Geolocation) != "" {
+            log.DefaultLogger.Warn(fmt.Sprintf("Project %s has an invalid geolocation: %s", t.Name, err.Error()))
+        }
         lats = append(lats, lat)
         longs = append(longs, lng)
+        names = append(names, t.Name)
         titles = append(titles, t.Title)
+        cities = append(cities, t.City)
+        countries = append(countries, t.Country)
+        counts = append(counts, datapointCounts[t.Name])
+        states, err := sds.cassandraClient.QueryAlarmStates(orgId, model.SensorRef{Project: t.Name})
+        if err != nil {
+            log.DefaultLogger.Error(fmt.Sprintf("Unable to read alarm states of %s: %s", t.Name, err.Error()))
+        }
+        severities = append(severities, string(model.WorstAlarmSeverity(states)))
     }
     frame := data.NewFrame(queryName,
         data.NewField("Name", nil, titles),
         data.NewField("latitude", nil, lats),
         data.NewField("longitude", nil, longs),
+        data.NewField("Project", nil, names),
+        data.NewField("City", nil, cities),
+        data.NewField("Country", nil, countries),
+        data.NewField("Datapoints", nil, counts),
+        data.NewField("Severity", nil, severities),
+    )
+    return frame
+}
+
+func formatFreshnessQuery(queryName string, report model.FreshnessReport, datapoints []model.DatapointFreshness) *data.Frame {
+    projects := []string{}
+    subsystems := []string{}
+    names := []string{}
+    intervals := []string{}
+    lastSamples := []*time.Time{}
+    ages := []int64{}
+    statuses := []string{}
+    for _, dp := range datapoints {
+        projects = append(projects, dp.Project)
+        subsystems = append(subsystems, dp.Subsystem)
+        names = append(names, dp.Name)
+        intervals = append(intervals, string(dp.Interval))
+        lastSamples = append(lastSamples, dp.LastSample)
+        ages = append(ages, dp.Age)
+        statuses = append(statuses, string(dp.Status))
+    }
+    frame := data.NewFrame(queryName,
+        data.NewField("Project", nil, projects),
+        data.NewField("Subsystem", nil, subsystems),
+        data.NewField("Datapoint", nil, names),
+        data.NewField("Interval", nil, intervals),
+        data.NewField("Last sample", nil, lastSamples),
+        data.NewField("Age", nil, ages).SetConfig(&data.FieldConfig{Unit: "s"}),
+        data.NewField("Status", nil, statuses),
     )
+    frame.SetMeta(&data.FrameMeta{Custom: report.Counts})
     return frame
 }
 
@@ -0,0 +1,37 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+func TestGeomapFrame(t *testing.T) {
+    cassandra := createFakeCassandra(t)
+    sds := &SensetifDatasource{cassandraClient: cassandra}
+    projects := append(cassandra.projects, model.ProjectSettings{Name: "pond", Title: "Pond", Geolocation: "59.86,NaN"})
+    frame := sds.formatProjectsQuery("A", 1, projects)
+    columns := map[string]interface{}{}
+    for _, field := range frame.Fields {
+        values := make([]interface{}, 0, field.Len())
+        for i := 0; i < field.Len(); i++ {
+            values = append(values, field.At(i))
+        }
+        columns[field.Name] = values
+    }
+    latitude := 59.85
+    expected := map[string]interface{}{
+        "Name":       []interface{}{"Garden", "Pond"},
+        "Project":    []interface{}{"garden", "pond"},
+        "latitude":   []interface{}{&latitude, (*float64)(nil)},
+        "City":       []interface{}{"Uppsala", ""},
+        "Datapoints": []interface{}{int64(2), int64(0)},
+        "Severity":   []interface{}{"minor", "none"},
+    }
+    for name, values := range expected {
+        if !reflect.DeepEqual(columns[name], values) {
+            t.Errorf("%s is %v, expected %v", name, columns[name], values)
+        }
+    }
+}
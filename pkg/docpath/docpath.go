@@ -0,0 +1,133 @@
+// Package docpath evaluates the JSONPath and XPath expressions that web and MQTT datasources use to pick the value
+// and the timestamp out of the documents they receive. Only the parts of the languages that make sense for picking
+// single values are evaluated. JSONPath filters, scripts and functions, and XPath axes and functions other than text(),
+// node() and last(), are checked for their syntax but not evaluated; the paths that use them compile to an
+// UnsupportedError, as the backend that polls the datasources evaluates them.
+package docpath
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Path is a compiled expression.
+type Path interface {
+    // Select returns the values matching the path in a document returned by ParseJson or ParseXml. For JSON documents
+    // these are what encoding/json decodes to, with numbers as json.Number, and for XML documents strings.
+    Select(document interface{}) []interface{}
+    // Expression returns the source of the path.
+    Expression() string
+}
+
+// SyntaxError tells where in the expression the problem is. Position is the byte offset, counted from 0.
+type SyntaxError struct {
+    Expression string
+    Position   int
+    Message    string
+}
+
+func (e *SyntaxError) Error() string {
+    return fmt.Sprintf("%s at position %d of \"%s\"", e.Message, e.Position, e.Expression)
+}
+
+// UnsupportedError is returned for a valid expression that uses a part of the language that is not evaluated here.
+// Position is where the first such part starts.
+type UnsupportedError struct {
+    Expression string
+    Position   int
+    Message    string
+}
+
+func (e *UnsupportedError) Error() string {
+    return fmt.Sprintf("%s at position %d of \"%s\", so it can only be tried by the backend", e.Message, e.Position, e.Expression)
+}
+
+// skipParentheses returns the position after the ) that closes the ( at pos, skipping quoted text and nested
+// parentheses, or -1 if it is not closed.
+func skipParentheses(expression string, pos int) int {
+    depth := 0
+    for i := pos; i < len(expression); i++ {
+        switch c := expression[i]; c {
+        case '(':
+            depth++
+        case ')':
+            depth--
+            if depth == 0 {
+                return i + 1
+            }
+        case '\'', '"':
+            end := strings.IndexByte(expression[i+1:], c)
+            if end < 0 {
+                return -1
+            }
+            i += end + 1
+        }
+    }
+    return -1
+}
+
+// Single returns the only value matching the path, or an error if there is none or more than one.
+func Single(path Path, document interface{}) (interface{}, error) {
+    values := path.Select(document)
+    switch len(values) {
+    case 0:
+        return nil, fmt.Errorf("\"%s\" matches nothing in the document", path.Expression())
+    case 1:
+        return values[0], nil
+    default:
+        return nil, fmt.Errorf("\"%s\" matches %d values, it must match exactly one", path.Expression(), len(values))
+    }
+}
+
+// Text returns a selected value as text, which is how timestamps are parsed.
+func Text(value interface{}) (string, error) {
+    switch v := value.(type) {
+    case string:
+        return v, nil
+    case json.Number:
+        return v.String(), nil
+    case bool:
+        return fmt.Sprintf("%t", v), nil
+    case nil:
+        return "", fmt.Errorf("the value is null")
+    default:
+        return "", fmt.Errorf("the value is %s, not a single value", kindOf(value))
+    }
+}
+
+// Number returns a selected value as a number. Strings are accepted if they contain only a number.
+func Number(value interface{}) (float64, error) {
+    switch v := value.(type) {
+    case json.Number:
+        return v.Float64()
+    case string:
+        number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+        if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+            return 0, fmt.Errorf("\"%s\" is not a number", v)
+        }
+        return number, nil
+    case bool:
+        if v {
+            return 1, nil
+        }
+        return 0, nil
+    default:
+        return 0, fmt.Errorf("the value is %s, not a number", kindOf(value))
+    }
+}
+
+func kindOf(value interface{}) string {
+    switch value.(type) {
+    case nil:
+        return "null"
+    case map[string]interface{}:
+        return "an object"
+    case []interface{}:
+        return "an array"
+    default:
+        return fmt.Sprintf("a %T", value)
+    }
+}
@@ -0,0 +1,410 @@
+package docpath
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// ParseJson decodes a JSON document, keeping numbers as json.Number so that no precision is lost before scaling.
+func ParseJson(data []byte) (interface{}, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    var document interface{}
+    err := decoder.Decode(&document)
+    if err != nil {
+        return nil, fmt.Errorf("not a JSON document: %s", err.Error())
+    }
+    if decoder.More() {
+        return nil, fmt.Errorf("not a JSON document: there is more than one value")
+    }
+    return document, nil
+}
+
+type jsonSelector int
+
+const (
+    selectNames jsonSelector = iota
+    selectIndexes
+    selectSlice
+    selectAll
+)
+
+// jsonStep is one step of a JSONPath, e.g. .name, ..name, [0], ['a','b'], [1:3] or [*].
+type jsonStep struct {
+    recursive bool
+    selector  jsonSelector
+    names     []string
+    indexes   []int
+    slice     [2]*int
+}
+
+type jsonPath struct {
+    expression string
+    steps      []jsonStep
+}
+
+// CompileJsonPath compiles a JSONPath. Like the backend, which uses Jayway JsonPath, a path that doesn't start with
+// $ is relative to the root, i.e. "a.b" is the same as "$.a.b". Filters, [?(...)], scripts, [(...)], and functions,
+// like .length(), are not evaluated; a path with them is an *UnsupportedError if its syntax is right.
+func CompileJsonPath(expression string) (Path, error) {
+    p := jsonParser{expression: expression}
+    path, err := p.parse()
+    if err != nil {
+        return nil, err
+    }
+    if p.unsupported != nil {
+        return nil, p.unsupported
+    }
+    return path, nil
+}
+
+func (p *jsonPath) Expression() string {
+    return p.expression
+}
+
+func (p *jsonPath) Select(document interface{}) []interface{} {
+    current := []interface{}{document}
+    for _, step := range p.steps {
+        next := make([]interface{}, 0)
+        for _, value := range current {
+            if step.recursive {
+                walkJson(value, func(v interface{}) {
+                    next = step.apply(v, next)
+                })
+            } else {
+                next = step.apply(value, next)
+            }
+        }
+        current = next
+    }
+    return current
+}
+
+// walkJson calls fn with the value and all values nested in it, in document order.
+func walkJson(value interface{}, fn func(interface{})) {
+    fn(value)
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for _, key := range sortedKeys(v) {
+            walkJson(v[key], fn)
+        }
+    case []interface{}:
+        for _, item := range v {
+            walkJson(item, fn)
+        }
+    }
+}
+
+func (s *jsonStep) apply(value interface{}, result []interface{}) []interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        switch s.selector {
+        case selectNames:
+            for _, name := range s.names {
+                if child, found := v[name]; found {
+                    result = append(result, child)
+                }
+            }
+        case selectAll:
+            for _, key := range sortedKeys(v) {
+                result = append(result, v[key])
+            }
+        }
+    case []interface{}:
+        switch s.selector {
+        case selectIndexes:
+            for _, index := range s.indexes {
+                if index < 0 {
+                    index += len(v)
+                }
+                if index >= 0 && index < len(v) {
+                    result = append(result, v[index])
+                }
+            }
+        case selectSlice:
+            start, end := sliceBounds(s.slice, len(v))
+            for i := start; i < end; i++ {
+                result = append(result, v[i])
+            }
+        case selectAll:
+            result = append(result, v...)
+        }
+    }
+    return result
+}
+
+func sliceBounds(slice [2]*int, length int) (int, int) {
+    bounds := [2]int{0, length}
+    for i, bound := range slice {
+        if bound == nil {
+            continue
+        }
+        bounds[i] = *bound
+        if bounds[i] < 0 {
+            bounds[i] += length
+        }
+        if bounds[i] < 0 {
+            bounds[i] = 0
+        }
+        if bounds[i] > length {
+            bounds[i] = length
+        }
+    }
+    return bounds[0], bounds[1]
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+    keys := make([]string, 0, len(object))
+    for key := range object {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+type jsonParser struct {
+    expression  string
+    pos         int
+    unsupported *UnsupportedError // the first part that is not evaluated
+}
+
+func (p *jsonParser) fail(pos int, format string, args ...interface{}) error {
+    return &SyntaxError{Expression: p.expression, Position: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// skipUnsupported skips the parenthesized part at the position, and remembers the first one.
+func (p *jsonParser) skipUnsupported(start int, what string) error {
+    open := strings.IndexByte(p.expression[p.pos:], '(') + p.pos
+    end := skipParentheses(p.expression, open)
+    if end < 0 {
+        return p.fail(open, "the ( is not closed")
+    }
+    p.pos = end
+    if p.unsupported == nil {
+        p.unsupported = &UnsupportedError{Expression: p.expression, Position: start, Message: what + " are not evaluated"}
+    }
+    return nil
+}
+
+func (p *jsonParser) parse() (*jsonPath, error) {
+    path := &jsonPath{expression: p.expression}
+    if strings.TrimSpace(p.expression) == "" {
+        return nil, p.fail(0, "the expression is empty")
+    }
+    if strings.HasPrefix(p.expression, "$") {
+        p.pos = 1
+    } else if strings.HasPrefix(p.expression, "@") {
+        return nil, p.fail(0, "@ is only allowed in filters, start with $")
+    } else if !strings.HasPrefix(p.expression, "[") && !strings.HasPrefix(p.expression, ".") {
+        // relative to the root, as in Jayway JsonPath
+        step, err := p.parseDotStep(false)
+        if err != nil {
+            return nil, err
+        }
+        path.steps = append(path.steps, step)
+    }
+    for p.pos < len(p.expression) {
+        start := p.pos
+        switch p.expression[p.pos] {
+        case '.':
+            p.pos++
+            recursive := false
+            if p.pos < len(p.expression) && p.expression[p.pos] == '.' {
+                recursive = true
+                p.pos++
+            }
+            if p.pos < len(p.expression) && p.expression[p.pos] == '[' {
+                if !recursive {
+                    return nil, p.fail(start, "a . must be followed by a name or *")
+                }
+                step, err := p.parseBracketStep()
+                if err != nil {
+                    return nil, err
+                }
+                step.recursive = true
+                path.steps = append(path.steps, step)
+                continue
+            }
+            step, err := p.parseDotStep(recursive)
+            if err != nil {
+                return nil, err
+            }
+            path.steps = append(path.steps, step)
+        case '[':
+            step, err := p.parseBracketStep()
+            if err != nil {
+                return nil, err
+            }
+            path.steps = append(path.steps, step)
+        default:
+            return nil, p.fail(p.pos, "expected . or [ but found '%c'", p.expression[p.pos])
+        }
+    }
+    return path, nil
+}
+
+// parseDotStep parses the name or * after . or ..
+func (p *jsonParser) parseDotStep(recursive bool) (jsonStep, error) {
+    start := p.pos
+    if p.pos < len(p.expression) && p.expression[p.pos] == '*' {
+        p.pos++
+        return jsonStep{recursive: recursive, selector: selectAll}, nil
+    }
+    for p.pos < len(p.expression) && !strings.ContainsRune(".[]()'\" ?*", rune(p.expression[p.pos])) {
+        p.pos++
+    }
+    if p.pos == start {
+        if p.pos == len(p.expression) {
+            return jsonStep{}, p.fail(p.pos, "the expression ends with a . but a name or * must follow")
+        }
+        return jsonStep{}, p.fail(p.pos, "a name or * must follow . but found '%c'", p.expression[p.pos])
+    }
+    if p.pos < len(p.expression) && p.expression[p.pos] == '(' {
+        return jsonStep{}, p.skipUnsupported(start, "functions")
+    }
+    return jsonStep{recursive: recursive, selector: selectNames, names: []string{p.expression[start:p.pos]}}, nil
+}
+
+// parseBracketStep parses [*], ['name', ...], [index, ...] or [start:end].
+func (p *jsonParser) parseBracketStep() (jsonStep, error) {
+    open := p.pos
+    p.pos++
+    p.skipSpace()
+    if p.pos >= len(p.expression) {
+        return jsonStep{}, p.fail(open, "the [ is not closed")
+    }
+    var step jsonStep
+    switch c := p.expression[p.pos]; {
+    case c == '*':
+        p.pos++
+        step.selector = selectAll
+    case c == '?' && p.pos+1 < len(p.expression) && p.expression[p.pos+1] == '(':
+        err := p.skipUnsupported(p.pos, "filters")
+        if err != nil {
+            return jsonStep{}, err
+        }
+    case c == '(':
+        err := p.skipUnsupported(p.pos, "script expressions")
+        if err != nil {
+            return jsonStep{}, err
+        }
+    case c == '\'' || c == '"':
+        step.selector = selectNames
+        for {
+            name, err := p.parseQuoted()
+            if err != nil {
+                return jsonStep{}, err
+            }
+            step.names = append(step.names, name)
+            if !p.skipComma() {
+                break
+            }
+            if p.pos >= len(p.expression) || (p.expression[p.pos] != '\'' && p.expression[p.pos] != '"') {
+                return jsonStep{}, p.fail(p.pos, "a quoted name must follow the ,")
+            }
+        }
+    default:
+        first, err := p.parseOptionalInt()
+        if err != nil {
+            return jsonStep{}, err
+        }
+        p.skipSpace()
+        if p.pos < len(p.expression) && p.expression[p.pos] == ':' {
+            p.pos++
+            p.skipSpace()
+            end, err := p.parseOptionalInt()
+            if err != nil {
+                return jsonStep{}, err
+            }
+            step.selector = selectSlice
+            step.slice = [2]*int{first, end}
+            break
+        }
+        if first == nil {
+            return jsonStep{}, p.fail(p.pos, "expected *, a quoted name, an index or a slice")
+        }
+        step.selector = selectIndexes
+        step.indexes = append(step.indexes, *first)
+        for p.skipComma() {
+            index, err := p.parseOptionalInt()
+            if err != nil {
+                return jsonStep{}, err
+            }
+            if index == nil {
+                return jsonStep{}, p.fail(p.pos, "an index must follow the ,")
+            }
+            step.indexes = append(step.indexes, *index)
+        }
+    }
+    p.skipSpace()
+    if p.pos >= len(p.expression) {
+        return jsonStep{}, p.fail(open, "the [ is not closed")
+    }
+    if p.expression[p.pos] != ']' {
+        return jsonStep{}, p.fail(p.pos, "expected ] but found '%c'", p.expression[p.pos])
+    }
+    p.pos++
+    return step, nil
+}
+
+func (p *jsonParser) parseQuoted() (string, error) {
+    quote := p.expression[p.pos]
+    start := p.pos
+    p.pos++
+    var name strings.Builder
+    for p.pos < len(p.expression) {
+        c := p.expression[p.pos]
+        switch {
+        case c == '\\' && p.pos+1 < len(p.expression):
+            name.WriteByte(p.expression[p.pos+1])
+            p.pos += 2
+        case c == quote:
+            p.pos++
+            p.skipSpace()
+            return name.String(), nil
+        default:
+            name.WriteByte(c)
+            p.pos++
+        }
+    }
+    return "", p.fail(start, "the quoted name is not closed")
+}
+
+func (p *jsonParser) parseOptionalInt() (*int, error) {
+    start := p.pos
+    if p.pos < len(p.expression) && p.expression[p.pos] == '-' {
+        p.pos++
+    }
+    for p.pos < len(p.expression) && p.expression[p.pos] >= '0' && p.expression[p.pos] <= '9' {
+        p.pos++
+    }
+    if p.pos == start {
+        return nil, nil
+    }
+    value, err := strconv.Atoi(p.expression[start:p.pos])
+    if err != nil {
+        return nil, p.fail(start, "\"%s\" is not an index", p.expression[start:p.pos])
+    }
+    p.skipSpace()
+    return &value, nil
+}
+
+func (p *jsonParser) skipComma() bool {
+    p.skipSpace()
+    if p.pos < len(p.expression) && p.expression[p.pos] == ',' {
+        p.pos++
+        p.skipSpace()
+        return true
+    }
+    return false
+}
+
+func (p *jsonParser) skipSpace() {
+    for p.pos < len(p.expression) && p.expression[p.pos] == ' ' {
+        p.pos++
+    }
+}
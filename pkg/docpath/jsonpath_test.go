@@ -0,0 +1,192 @@
+package docpath
+
+import (
+    "encoding/json"
+    "errors"
+    "reflect"
+    "testing"
+)
+
+const testJsonDocument = `{
+    "temperature": 21.5,
+    "unit": "C",
+    "ok": true,
+    "missing": null,
+    "sensor.name": "shed",
+    "readings": [
+        {"id": 1, "value": 10, "time": "2022-03-01T10:00:00Z"},
+        {"id": 2, "value": 20, "time": "2022-03-01T10:15:00Z"},
+        {"id": 3, "value": 30, "time": "2022-03-01T10:30:00Z"}
+    ],
+    "nested": {"deep": {"value": 42}}
+}`
+
+func TestJsonPathSelect(t *testing.T) {
+    document, err := ParseJson([]byte(testJsonDocument))
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        expression string
+        expected   []interface{}
+    }{
+        {"$.temperature", []interface{}{json.Number("21.5")}},
+        {"temperature", []interface{}{json.Number("21.5")}},
+        {"$['unit']", []interface{}{"C"}},
+        {`$["sensor.name"]`, []interface{}{"shed"}},
+        {"$.ok", []interface{}{true}},
+        {"$.missing", []interface{}{nil}},
+        {"$.nothing", []interface{}{}},
+        {"$.readings[0].value", []interface{}{json.Number("10")}},
+        {"$.readings[-1].value", []interface{}{json.Number("30")}},
+        {"$.readings[5].value", []interface{}{}},
+        {"readings[1].time", []interface{}{"2022-03-01T10:15:00Z"}},
+        {"$.readings[0,2].id", []interface{}{json.Number("1"), json.Number("3")}},
+        {"$.readings[1:].id", []interface{}{json.Number("2"), json.Number("3")}},
+        {"$.readings[:1].id", []interface{}{json.Number("1")}},
+        {"$.readings[-2:].id", []interface{}{json.Number("2"), json.Number("3")}},
+        {"$.readings[*].id", []interface{}{json.Number("1"), json.Number("2"), json.Number("3")}},
+        {"$.nested.*.value", []interface{}{json.Number("42")}},
+        {"$..deep.value", []interface{}{json.Number("42")}},
+        {"$..['id']", []interface{}{json.Number("1"), json.Number("2"), json.Number("3")}},
+        {"$[ 'nested' ][ 'deep' ].value", []interface{}{json.Number("42")}},
+    }
+    for _, test := range tests {
+        path, err := CompileJsonPath(test.expression)
+        if err != nil {
+            t.Errorf("%s: %v", test.expression, err)
+            continue
+        }
+        if selected := path.Select(document); !reflect.DeepEqual(selected, test.expected) {
+            t.Errorf("%s selected %v, expected %v", test.expression, selected, test.expected)
+        }
+    }
+}
+
+func TestJsonPathSyntaxErrors(t *testing.T) {
+    tests := []struct {
+        expression string
+        position   int
+    }{
+        {"", 0},
+        {"   ", 0},
+        {"@.value", 0},
+        {"$.", 2},
+        {"$.readings[", 10},
+        {"$.readings[0", 10},
+        {"$.readings[x]", 11},
+        {"$.readings[0,]", 13},
+        {"$['unit", 2},
+        {"$['a',]", 6},
+        {"$.a b", 3},
+        {"$.[0]", 1},
+        {"$[?(@.id == 1]", 3},
+        {"$[?(@.id == 1)", 1},
+        {"$.length(", 8},
+    }
+    for _, test := range tests {
+        _, err := CompileJsonPath(test.expression)
+        var syntaxErr *SyntaxError
+        if !errors.As(err, &syntaxErr) {
+            t.Errorf("%q: expected a syntax error, got %v", test.expression, err)
+            continue
+        }
+        if syntaxErr.Position != test.position {
+            t.Errorf("%q: error at %d, expected at %d: %v", test.expression, syntaxErr.Position, test.position, err)
+        }
+    }
+}
+
+func TestJsonPathUnsupported(t *testing.T) {
+    tests := []struct {
+        expression string
+        position   int
+    }{
+        {"$.readings[?(@.id == 2)].value", 11},
+        {"$.readings[?(@.time > '2022-03-01T10:00:00Z' && @.unit != ')')].value", 11},
+        {"$.readings[(@.length-1)].value", 11},
+        {"$.readings.length()", 11},
+        {"$.readings[*].value.max()", 20},
+        {"$..readings[?(@.id)].value", 12},
+    }
+    for _, test := range tests {
+        _, err := CompileJsonPath(test.expression)
+        var unsupported *UnsupportedError
+        if !errors.As(err, &unsupported) {
+            t.Errorf("%q: expected an unsupported error, got %v", test.expression, err)
+            continue
+        }
+        if unsupported.Position != test.position {
+            t.Errorf("%q: unsupported at %d, expected at %d", test.expression, unsupported.Position, test.position)
+        }
+    }
+}
+
+func TestSingle(t *testing.T) {
+    document, _ := ParseJson([]byte(testJsonDocument))
+    for expression, expectError := range map[string]bool{
+        "$.temperature":        false,
+        "$.nothing":            true,
+        "$.readings[*].value":  true,
+        "$.readings[-1].value": false,
+    } {
+        path, _ := CompileJsonPath(expression)
+        if _, err := Single(path, document); (err != nil) != expectError {
+            t.Errorf("%s: error %v", expression, err)
+        }
+    }
+}
+
+func TestNumberAndText(t *testing.T) {
+    numbers := []struct {
+        value    interface{}
+        expected float64
+        ok       bool
+    }{
+        {json.Number("21.5"), 21.5, true},
+        {" 7 ", 7, true},
+        {true, 1, true},
+        {false, 0, true},
+        {"NaN", 0, false},
+        {"warm", 0, false},
+        {nil, 0, false},
+        {map[string]interface{}{}, 0, false},
+    }
+    for _, test := range numbers {
+        number, err := Number(test.value)
+        if (err == nil) != test.ok || number != test.expected {
+            t.Errorf("Number(%v) is %v, %v", test.value, number, err)
+        }
+    }
+    texts := []struct {
+        value    interface{}
+        expected string
+        ok       bool
+    }{
+        {"2022-03-01T10:00:00Z", "2022-03-01T10:00:00Z", true},
+        {json.Number("1646128800"), "1646128800", true},
+        {true, "true", true},
+        {nil, "", false},
+        {[]interface{}{}, "", false},
+    }
+    for _, test := range texts {
+        text, err := Text(test.value)
+        if (err == nil) != test.ok || text != test.expected {
+            t.Errorf("Text(%v) is %q, %v", test.value, text, err)
+        }
+    }
+}
+
+func TestParseJson(t *testing.T) {
+    for document, ok := range map[string]bool{
+        `{"a": 1}`:          true,
+        `[1, 2]`:            true,
+        `{"a": 1} {"b": 2}`: false,
+        `{"a": `:            false,
+        ``:                  false,
+    } {
+        if _, err := ParseJson([]byte(document)); (err == nil) != ok {
+            t.Errorf("%q: error %v", document, err)
+        }
+    }
+}
@@ -0,0 +1,147 @@
+package docpath
+
+import (
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// At most this many candidates are collected from a document.
+const maxCandidates = 500
+
+var (
+    plainJsonName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+    quoteEscaper  = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+)
+
+// Candidate is a path to a single value in a sample document, which an expression could be.
+type Candidate struct {
+    Path  string
+    Value interface{}
+}
+
+// JsonCandidates returns the paths to all values of a document returned by ParseJson that are not objects or arrays,
+// in document order.
+func JsonCandidates(document interface{}) []Candidate {
+    candidates := make([]Candidate, 0)
+    var collect func(path string, value interface{})
+    collect = func(path string, value interface{}) {
+        if len(candidates) >= maxCandidates {
+            return
+        }
+        switch v := value.(type) {
+        case map[string]interface{}:
+            for _, key := range sortedKeys(v) {
+                if plainJsonName.MatchString(key) {
+                    collect(path+"."+key, v[key])
+                } else {
+                    collect(path+"['"+quoteEscaper.Replace(key)+"']", v[key])
+                }
+            }
+        case []interface{}:
+            for i, item := range v {
+                collect(path+"["+strconv.Itoa(i)+"]", item)
+            }
+        default:
+            candidates = append(candidates, Candidate{Path: path, Value: value})
+        }
+    }
+    collect("$", document)
+    return candidates
+}
+
+// XmlCandidates returns the paths to all elements with text and all attributes of a document returned by ParseXml,
+// in document order. Positions are only given where an element has siblings of the same name.
+func XmlCandidates(document interface{}) []Candidate {
+    candidates := make([]Candidate, 0)
+    root, ok := document.(*xmlNode)
+    if !ok {
+        return candidates
+    }
+    var collect func(path string, node *xmlNode)
+    collect = func(path string, node *xmlNode) {
+        for _, attr := range node.attrs {
+            candidates = append(candidates, Candidate{Path: path + "/@" + attr.name, Value: attr.value})
+        }
+        elements := node.elements()
+        if len(elements) == 0 && node.kind == elementNode && len(candidates) < maxCandidates {
+            candidates = append(candidates, Candidate{Path: path, Value: node.text()})
+        }
+        counts := map[string]int{}
+        for _, element := range elements {
+            counts[element.name]++
+        }
+        positions := map[string]int{}
+        for _, element := range elements {
+            if len(candidates) >= maxCandidates {
+                return
+            }
+            positions[element.name]++
+            step := path + "/" + element.name
+            if counts[element.name] > 1 {
+                step += "[" + strconv.Itoa(positions[element.name]) + "]"
+            }
+            collect(step, element)
+        }
+    }
+    collect("", root)
+    return candidates
+}
+
+// Closest returns up to n of the candidates whose paths are most like the expression, the closest first. Numbers come
+// before other values that are as close, as value expressions must select numbers.
+func Closest(expression string, candidates []Candidate, n int) []Candidate {
+    type scored struct {
+        candidate Candidate
+        distance  int
+        number    bool
+    }
+    all := make([]scored, 0, len(candidates))
+    for _, candidate := range candidates {
+        _, err := Number(candidate.Value)
+        all = append(all, scored{candidate, editDistance(expression, candidate.Path), err == nil})
+    }
+    sort.SliceStable(all, func(i, j int) bool {
+        if all[i].distance != all[j].distance {
+            return all[i].distance < all[j].distance
+        }
+        return all[i].number && !all[j].number
+    })
+    result := make([]Candidate, 0, n)
+    for i := 0; i < len(all) && i < n; i++ {
+        result = append(result, all[i].candidate)
+    }
+    return result
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+    previous := make([]int, len(b)+1)
+    current := make([]int, len(b)+1)
+    for j := range previous {
+        previous[j] = j
+    }
+    for i := 1; i <= len(a); i++ {
+        current[0] = i
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i-1] == b[j-1] {
+                cost = 0
+            }
+            current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+        }
+        previous, current = current, previous
+    }
+    return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+    if b < a {
+        a = b
+    }
+    if c < a {
+        a = c
+    }
+    return a
+}
@@ -0,0 +1,521 @@
+package docpath
+
+import (
+    "bytes"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+type xmlKind int
+
+const (
+    documentNode xmlKind = iota
+    elementNode
+    attributeNode
+    textNode
+)
+
+// xmlNode is a node of a parsed XML document. Names are local names; namespaces are ignored, which is what users
+// of a value expression expect in practice.
+type xmlNode struct {
+    kind     xmlKind
+    name     string
+    value    string // of attributes and text nodes
+    attrs    []*xmlNode
+    children []*xmlNode
+    parent   *xmlNode
+}
+
+// ParseXml reads an XML document into a tree that paths compiled with CompileXPath select from.
+func ParseXml(data []byte) (interface{}, error) {
+    decoder := xml.NewDecoder(bytes.NewReader(data))
+    decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+        // values are numbers and timestamps, which are the same in all charsets that devices use
+        return input, nil
+    }
+    document := &xmlNode{kind: documentNode}
+    current := document
+    for {
+        token, err := decoder.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, fmt.Errorf("not an XML document: %s", err.Error())
+        }
+        switch t := token.(type) {
+        case xml.StartElement:
+            element := &xmlNode{kind: elementNode, name: t.Name.Local, parent: current}
+            for _, attr := range t.Attr {
+                if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+                    continue
+                }
+                element.attrs = append(element.attrs, &xmlNode{kind: attributeNode, name: attr.Name.Local, value: attr.Value, parent: element})
+            }
+            current.children = append(current.children, element)
+            current = element
+        case xml.EndElement:
+            current = current.parent
+        case xml.CharData:
+            if current != document {
+                current.children = append(current.children, &xmlNode{kind: textNode, value: string(t), parent: current})
+            }
+        }
+    }
+    if len(document.elements()) != 1 {
+        return nil, fmt.Errorf("not an XML document: there must be exactly one root element")
+    }
+    return document, nil
+}
+
+func (n *xmlNode) elements() []*xmlNode {
+    result := make([]*xmlNode, 0, len(n.children))
+    for _, child := range n.children {
+        if child.kind == elementNode {
+            result = append(result, child)
+        }
+    }
+    return result
+}
+
+// text is the string-value of the node, i.e. all text within it, trimmed.
+func (n *xmlNode) text() string {
+    if n.kind == attributeNode || n.kind == textNode {
+        return strings.TrimSpace(n.value)
+    }
+    var builder strings.Builder
+    var collect func(node *xmlNode)
+    collect = func(node *xmlNode) {
+        for _, child := range node.children {
+            if child.kind == textNode {
+                builder.WriteString(child.value)
+            } else {
+                collect(child)
+            }
+        }
+    }
+    collect(n)
+    return strings.TrimSpace(builder.String())
+}
+
+type xmlTest int
+
+const (
+    testElement xmlTest = iota
+    testAttribute
+    testText
+    testNode
+    testSelf
+    testParent
+)
+
+type xmlPredicate struct {
+    position int    // 1-based, or 0 if not a position
+    last     int    // for last() and last()-n, the n, or -1 if not last()
+    path     *xpath // relative path that must exist, or be equal to value
+    equal    *bool
+    value    string
+}
+
+type xmlStep struct {
+    descendant bool // the step follows //
+    test       xmlTest
+    name       string // "*" for any
+    predicates []xmlPredicate
+}
+
+type xpath struct {
+    expression string
+    absolute   bool
+    steps      []xmlStep
+}
+
+// CompileXPath compiles an XPath location path, like /response/sensor[@id='4']/value, //temperature/text() or
+// /data/item[last()]/@value. Explicit axes, functions other than text(), node() and last(), and comparisons other
+// than = and != are not evaluated; a path with them is an *UnsupportedError if its syntax is right.
+func CompileXPath(expression string) (Path, error) {
+    p := xpathParser{expression: expression}
+    if strings.TrimSpace(expression) == "" {
+        return nil, p.fail(0, "the expression is empty")
+    }
+    path, err := p.parsePath(false)
+    if err != nil {
+        return nil, err
+    }
+    if p.pos < len(expression) {
+        return nil, p.fail(p.pos, "unexpected '%c'", expression[p.pos])
+    }
+    if p.unsupported != nil {
+        return nil, p.unsupported
+    }
+    return path, nil
+}
+
+func (x *xpath) Expression() string {
+    return x.expression
+}
+
+func (x *xpath) Select(document interface{}) []interface{} {
+    root, ok := document.(*xmlNode)
+    if !ok {
+        return nil
+    }
+    nodes := x.selectNodes(root)
+    result := make([]interface{}, 0, len(nodes))
+    for _, node := range nodes {
+        result = append(result, node.text())
+    }
+    return result
+}
+
+func (x *xpath) selectNodes(context *xmlNode) []*xmlNode {
+    if x.absolute {
+        for context.parent != nil {
+            context = context.parent
+        }
+    }
+    current := []*xmlNode{context}
+    for _, step := range x.steps {
+        seen := map[*xmlNode]bool{}
+        next := make([]*xmlNode, 0)
+        for _, node := range current {
+            parents := []*xmlNode{node}
+            if step.descendant {
+                parents = descendantsOrSelf(node)
+            }
+            for _, parent := range parents {
+                for _, selected := range step.apply(parent) {
+                    if !seen[selected] {
+                        seen[selected] = true
+                        next = append(next, selected)
+                    }
+                }
+            }
+        }
+        current = next
+    }
+    return current
+}
+
+func descendantsOrSelf(node *xmlNode) []*xmlNode {
+    result := []*xmlNode{node}
+    for _, child := range node.children {
+        if child.kind == elementNode {
+            result = append(result, descendantsOrSelf(child)...)
+        }
+    }
+    return result
+}
+
+// apply selects the nodes of the step from one context node, and filters them with the predicates.
+func (s *xmlStep) apply(node *xmlNode) []*xmlNode {
+    var candidates []*xmlNode
+    switch s.test {
+    case testSelf:
+        candidates = []*xmlNode{node}
+    case testParent:
+        if node.parent != nil {
+            candidates = []*xmlNode{node.parent}
+        }
+    case testAttribute:
+        for _, attr := range node.attrs {
+            if s.name == "*" || attr.name == s.name {
+                candidates = append(candidates, attr)
+            }
+        }
+    default:
+        for _, child := range node.children {
+            switch {
+            case s.test == testNode,
+                s.test == testText && child.kind == textNode,
+                s.test == testElement && child.kind == elementNode && (s.name == "*" || child.name == s.name):
+                candidates = append(candidates, child)
+            }
+        }
+    }
+    for _, predicate := range s.predicates {
+        filtered := make([]*xmlNode, 0, len(candidates))
+        for i, candidate := range candidates {
+            if predicate.matches(candidate, i+1, len(candidates)) {
+                filtered = append(filtered, candidate)
+            }
+        }
+        candidates = filtered
+    }
+    return candidates
+}
+
+func (p *xmlPredicate) matches(node *xmlNode, position int, size int) bool {
+    switch {
+    case p.position > 0:
+        return position == p.position
+    case p.last >= 0:
+        return position == size-p.last
+    }
+    nodes := p.path.selectNodes(node)
+    if p.equal == nil {
+        return len(nodes) > 0
+    }
+    for _, n := range nodes {
+        if (n.text() == p.value) == *p.equal {
+            return true
+        }
+    }
+    return false
+}
+
+type xpathParser struct {
+    expression  string
+    pos         int
+    unsupported *UnsupportedError // the first part that is not evaluated
+}
+
+func (p *xpathParser) fail(pos int, format string, args ...interface{}) error {
+    return &SyntaxError{Expression: p.expression, Position: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *xpathParser) unsupport(pos int, format string, args ...interface{}) {
+    if p.unsupported == nil {
+        p.unsupported = &UnsupportedError{Expression: p.expression, Position: pos, Message: fmt.Sprintf(format, args...)}
+    }
+}
+
+// parsePath parses steps until the end of the expression, or until ], = or ! within a predicate.
+func (p *xpathParser) parsePath(inPredicate bool) (*xpath, error) {
+    path := &xpath{expression: p.expression}
+    p.skipSpace()
+    descendant := false
+    if p.peek("//") {
+        path.absolute = true
+        descendant = true
+        p.pos += 2
+    } else if p.peek("/") {
+        path.absolute = true
+        p.pos++
+        if p.pos >= len(p.expression) {
+            return nil, p.fail(p.pos, "a step must follow the /")
+        }
+    }
+    for {
+        step, err := p.parseStep()
+        if err != nil {
+            return nil, err
+        }
+        step.descendant = descendant
+        path.steps = append(path.steps, step)
+        p.skipSpace()
+        if p.peek("//") {
+            descendant = true
+            p.pos += 2
+        } else if p.peek("/") {
+            descendant = false
+            p.pos++
+        } else {
+            break
+        }
+    }
+    if inPredicate && path.absolute {
+        return nil, p.fail(p.pos, "paths in predicates must be relative")
+    }
+    return path, nil
+}
+
+func (p *xpathParser) parseStep() (xmlStep, error) {
+    p.skipSpace()
+    start := p.pos
+    var step xmlStep
+    switch {
+    case p.peek(".."):
+        p.pos += 2
+        return xmlStep{test: testParent}, nil
+    case p.peek("."):
+        p.pos++
+        return xmlStep{test: testSelf}, nil
+    case p.peek("@"):
+        p.pos++
+        step.test = testAttribute
+        step.name = p.parseName()
+        if step.name == "" {
+            return step, p.fail(p.pos, "an attribute name or * must follow @")
+        }
+    case p.peek("text()"):
+        p.pos += len("text()")
+        step.test = testText
+    case p.peek("node()"):
+        p.pos += len("node()")
+        step.test = testNode
+    default:
+        step.test = testElement
+        step.name = p.parseName()
+        if step.name == "" {
+            if p.pos >= len(p.expression) {
+                return step, p.fail(p.pos, "the expression ends where a step is expected")
+            }
+            return step, p.fail(p.pos, "expected an element name, *, @, text() or . but found '%c'", p.expression[p.pos])
+        }
+        if p.peek("::") {
+            p.unsupport(start, "the axis %s:: is not evaluated, use /, //, @, . or .. instead", step.name)
+            p.pos += 2
+            return p.parseStep()
+        }
+        if p.peek("(") {
+            end := skipParentheses(p.expression, p.pos)
+            if end < 0 {
+                return step, p.fail(p.pos, "the ( is not closed")
+            }
+            p.unsupport(start, "the function %s() is not evaluated", step.name)
+            p.pos = end
+        }
+    }
+    for p.skipSpace(); p.peek("["); p.skipSpace() {
+        predicate, err := p.parsePredicate()
+        if err != nil {
+            return step, err
+        }
+        step.predicates = append(step.predicates, predicate)
+    }
+    return step, nil
+}
+
+// parseName parses a name or *. A namespace prefix is dropped, as names are matched on their local part.
+func (p *xpathParser) parseName() string {
+    if p.peek("*") {
+        p.pos++
+        return "*"
+    }
+    start := p.pos
+    for p.pos < len(p.expression) && isNameChar(p.expression[p.pos], p.pos == start) {
+        p.pos++
+    }
+    if p.pos > start && p.pos+1 < len(p.expression) && p.expression[p.pos] == ':' && p.expression[p.pos+1] != ':' {
+        p.pos++
+        return p.parseName()
+    }
+    return p.expression[start:p.pos]
+}
+
+func isNameChar(c byte, first bool) bool {
+    switch {
+    case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c >= 0x80:
+        return true
+    case c >= '0' && c <= '9', c == '-', c == '.':
+        return !first
+    }
+    return false
+}
+
+func (p *xpathParser) parsePredicate() (xmlPredicate, error) {
+    open := p.pos
+    p.pos++
+    p.skipSpace()
+    predicate := xmlPredicate{last: -1}
+    switch {
+    case p.pos < len(p.expression) && p.expression[p.pos] >= '0' && p.expression[p.pos] <= '9':
+        start := p.pos
+        for p.pos < len(p.expression) && p.expression[p.pos] >= '0' && p.expression[p.pos] <= '9' {
+            p.pos++
+        }
+        predicate.position, _ = strconv.Atoi(p.expression[start:p.pos])
+        if predicate.position == 0 {
+            return predicate, p.fail(start, "positions start at 1")
+        }
+    case p.peek("last()"):
+        p.pos += len("last()")
+        predicate.last = 0
+        p.skipSpace()
+        if p.peek("-") {
+            p.pos++
+            p.skipSpace()
+            start := p.pos
+            for p.pos < len(p.expression) && p.expression[p.pos] >= '0' && p.expression[p.pos] <= '9' {
+                p.pos++
+            }
+            if p.pos == start {
+                return predicate, p.fail(p.pos, "a number must follow last()-")
+            }
+            predicate.last, _ = strconv.Atoi(p.expression[start:p.pos])
+        }
+    default:
+        path, err := p.parsePath(true)
+        if err != nil {
+            return predicate, err
+        }
+        predicate.path = path
+        p.skipSpace()
+        if comparison := p.peekComparison(); comparison != "" {
+            p.unsupport(p.pos, "the comparison %s is not evaluated, only = and != are", comparison)
+            p.pos += len(comparison)
+            p.skipSpace()
+            _, err := p.parseLiteral()
+            if err != nil {
+                return predicate, err
+            }
+        } else if p.peek("=") || p.peek("!=") {
+            equal := p.peek("=")
+            predicate.equal = &equal
+            if equal {
+                p.pos++
+            } else {
+                p.pos += 2
+            }
+            p.skipSpace()
+            value, err := p.parseLiteral()
+            if err != nil {
+                return predicate, err
+            }
+            predicate.value = value
+        }
+    }
+    p.skipSpace()
+    if p.pos >= len(p.expression) {
+        return predicate, p.fail(open, "the [ is not closed")
+    }
+    if p.expression[p.pos] != ']' {
+        return predicate, p.fail(p.pos, "expected ] but found '%c'", p.expression[p.pos])
+    }
+    p.pos++
+    return predicate, nil
+}
+
+// parseLiteral parses a quoted string or a number, which is compared as text.
+func (p *xpathParser) parseLiteral() (string, error) {
+    start := p.pos
+    if p.pos < len(p.expression) && (p.expression[p.pos] == '\'' || p.expression[p.pos] == '"') {
+        end := strings.IndexByte(p.expression[p.pos+1:], p.expression[p.pos])
+        if end < 0 {
+            return "", p.fail(start, "the quoted value is not closed")
+        }
+        p.pos += end + 2
+        return p.expression[start+1 : p.pos-1], nil
+    }
+    for p.pos < len(p.expression) && strings.IndexByte("0123456789.-", p.expression[p.pos]) >= 0 {
+        p.pos++
+    }
+    if p.pos == start {
+        return "", p.fail(start, "expected a quoted value or a number")
+    }
+    return p.expression[start:p.pos], nil
+}
+
+// peekComparison returns the comparison operator other than = and != at the position, if there is one.
+func (p *xpathParser) peekComparison() string {
+    for _, operator := range []string{"<=", ">=", "<", ">"} {
+        if p.peek(operator) {
+            return operator
+        }
+    }
+    return ""
+}
+
+func (p *xpathParser) peek(prefix string) bool {
+    return strings.HasPrefix(p.expression[p.pos:], prefix)
+}
+
+func (p *xpathParser) skipSpace() {
+    for p.pos < len(p.expression) && p.expression[p.pos] == ' ' {
+        p.pos++
+    }
+}
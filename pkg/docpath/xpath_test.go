@@ -0,0 +1,131 @@
+package docpath
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+const testXmlDocument = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<response xmlns="http://example.com/weather" xmlns:w="http://example.com/w">
+    <station id="shed" kind="indoor">
+        <sensor id="4" unit="C"><value> 21.5 </value><time>2022-03-01T10:15:30Z</time></sensor>
+        <sensor id="5" unit="%"><value>40</value><time>2022-03-01T10:15:30Z</time></sensor>
+        <w:sensor id="6"><w:value>7</w:value></w:sensor>
+    </station>
+    <item value="1"/>
+    <item value="2"/>
+    <item value="3"/>
+</response>`
+
+func TestXPathSelect(t *testing.T) {
+    document, err := ParseXml([]byte(testXmlDocument))
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        expression string
+        expected   []interface{}
+    }{
+        {"/response/station/sensor[@id='4']/value", []interface{}{"21.5"}},
+        {"/response/station/sensor[@id=\"5\"]/value/text()", []interface{}{"40"}},
+        {"/response/station/sensor[@id!='4']/value", []interface{}{"40", "7"}},
+        {"//sensor[@unit]/@id", []interface{}{"4", "5"}},
+        {"//sensor[1]/value", []interface{}{"21.5"}},
+        {"//sensor[last()]/value", []interface{}{"7"}},
+        {"//sensor[last()-1]/value", []interface{}{"40"}},
+        {"/response/item[2]/@value", []interface{}{"2"}},
+        {"/response/item[last()]/@value", []interface{}{"3"}},
+        {"//w:sensor/w:value", []interface{}{"21.5", "40", "7"}},
+        {"//sensor[value='40']/time", []interface{}{"2022-03-01T10:15:30Z"}},
+        {"//sensor[value=40]/@unit", []interface{}{"%"}},
+        {"//value[.='7']/../@id", []interface{}{"6"}},
+        {"/response/station/@*", []interface{}{"shed", "indoor"}},
+        {"/response/*[@id='shed']/@kind", []interface{}{"indoor"}},
+        {"response/station/@id", []interface{}{"shed"}},
+        {"/response/nothing", []interface{}{}},
+        {"/response/station/sensor[9]/value", []interface{}{}},
+    }
+    for _, test := range tests {
+        path, err := CompileXPath(test.expression)
+        if err != nil {
+            t.Errorf("%s: %v", test.expression, err)
+            continue
+        }
+        if selected := path.Select(document); !reflect.DeepEqual(selected, test.expected) {
+            t.Errorf("%s selected %v, expected %v", test.expression, selected, test.expected)
+        }
+    }
+}
+
+func TestXPathSyntaxErrors(t *testing.T) {
+    tests := []struct {
+        expression string
+        position   int
+    }{
+        {"", 0},
+        {"/", 1},
+        {"/response/", 10},
+        {"/response/#", 10},
+        {"/response[", 10},
+        {"/response[0]", 10},
+        {"/response[@id='a]", 14},
+        {"/response[@id=]", 14},
+        {"/response[last()-]", 17},
+        {"/response[/a]", 12},
+        {"/response/@", 11},
+        {"/response)", 9},
+        {"/response/count(", 15},
+    }
+    for _, test := range tests {
+        _, err := CompileXPath(test.expression)
+        var syntaxErr *SyntaxError
+        if !errors.As(err, &syntaxErr) {
+            t.Errorf("%q: expected a syntax error, got %v", test.expression, err)
+            continue
+        }
+        if syntaxErr.Position != test.position {
+            t.Errorf("%q: error at %d, expected at %d: %v", test.expression, syntaxErr.Position, test.position, err)
+        }
+    }
+}
+
+func TestXPathUnsupported(t *testing.T) {
+    tests := []struct {
+        expression string
+        position   int
+    }{
+        {"/response/child::station/@id", 10},
+        {"//sensor[contains(@unit, 'C')]/value", 9},
+        {"//sensor[position() = 2]/value", 9},
+        {"//sensor[value > 30]/value", 15},
+        {"//sensor[@id >= '5']/value", 13},
+        {"/response/station/sensor/value[normalize-space(.) != '']", 31},
+        {"string(/response/station/@id)", 0},
+    }
+    for _, test := range tests {
+        _, err := CompileXPath(test.expression)
+        var unsupported *UnsupportedError
+        if !errors.As(err, &unsupported) {
+            t.Errorf("%q: expected an unsupported error, got %v", test.expression, err)
+            continue
+        }
+        if unsupported.Position != test.position {
+            t.Errorf("%q: unsupported at %d, expected at %d", test.expression, unsupported.Position, test.position)
+        }
+    }
+}
+
+func TestParseXml(t *testing.T) {
+    for document, ok := range map[string]bool{
+        `<a>1</a>`:     true,
+        `<a>1</a><b/>`: false,
+        `<a>1`:         false,
+        `just text`:    false,
+        `{"a": 1}`:     false,
+    } {
+        if _, err := ParseXml([]byte(document)); (err == nil) != ok {
+            t.Errorf("%q: error %v", document, err)
+        }
+    }
+}
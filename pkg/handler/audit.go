@@ -0,0 +1,210 @@
+package handler
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const defaultAuditPeriod = 30 * 24 * time.Hour
+
+const defaultAuditLimit = 100
+
+const maxAuditLimit = 1000
+
+// Snapshot returns the current settings of the template, project, subsystem or datapoint that the route and path
+// parameters point at, so that it can be recorded as the state before a change, and checked against If-Match. It
+// returns nil if there is nothing there (yet), and an error if the settings can't be read, as nil would let changes
+// through without If-Match.
+func Snapshot(orgId int64, route string, params []string, clients *client.Clients) (json.RawMessage, error) {
+    var current interface{}
+    var err error
+    switch {
+    case strings.HasSuffix(route, ".instantiate"):
+        // an instantiation creates a new subsystem; the path points at the template, which doesn't change
+    case strings.HasPrefix(route, "templates."):
+        if len(params) < 2 {
+            return nil, nil
+        }
+        var template model.SubsystemTemplate
+        template, err = clients.Cassandra.GetTemplate(orgId, params[1])
+        if template.Name != "" {
+            current = template
+        }
+    case strings.HasSuffix(route, ".clone"):
+        // a clone creates new settings; the path points at the original, which doesn't change
+    case len(params) == 2:
+        var project model.ProjectSettings
+        project, err = clients.Cassandra.GetProject(orgId, params[1])
+        if project.Name != "" {
+            current = project
+        }
+    case len(params) == 3:
+        var subsystem model.SubsystemSettings
+        subsystem, err = clients.Cassandra.GetSubsystem(orgId, params[1], params[2])
+        if subsystem.Name != "" {
+            current = subsystem
+        }
+    case len(params) == 4:
+        var datapoint model.DatapointSettings
+        datapoint, err = clients.Cassandra.GetDatapoint(orgId, params[1], params[2], params[3])
+        if datapoint.Name != "" {
+            current = datapoint
+        }
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%w: unable to read the current settings: %s", model.ErrServerError, err.Error())
+    }
+    if current == nil {
+        return nil, nil
+    }
+    result, err := json.Marshal(current)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return result, nil
+}
+
+// RecordAudit publishes the change on the audit topic of the organization. after is the request body, which is kept
+// as is if it is JSON, and otherwise recorded as a JSON string.
+func RecordAudit(orgId int64, action string, params []string, before json.RawMessage, after []byte, request *Request, clients *client.Clients) {
+    entry := model.AuditEntry{
+        Time:      time.Now(),
+        RequestId: request.Id,
+        Action:    action,
+        Before:    before,
+    }
+    if request.User != nil {
+        entry.Login = request.User.Login
+        entry.Email = request.User.Email
+        entry.Role = model.Role(request.User.Role)
+    }
+    if len(params) > 1 {
+        entry.Project = params[1]
+    }
+    if len(params) > 2 {
+        entry.Subsystem = params[2]
+    }
+    if len(params) > 3 {
+        entry.Datapoint = params[3]
+    }
+    if len(after) > 0 {
+        if json.Valid(after) {
+            entry.After = after
+        } else {
+            entry.After, _ = json.Marshal(string(after))
+        }
+    }
+    data, err := json.Marshal(entry)
+    if err != nil {
+        log.DefaultLogger.Error(fmt.Sprintf("Unable to record audit entry for %s: %s", action, err.Error()))
+        return
+    }
+    key := "2:" + strconv.FormatInt(orgId, 10) + ":" + action
+    if clients.Pulsar.Send(model.AuditTopics+strconv.FormatInt(orgId, 10), key, data) == nil {
+        log.DefaultLogger.Error(fmt.Sprintf("Audit entry was not recorded: %s", string(data)))
+    }
+}
+
+// ListAudit returns the most recent audit entries, newest first. The query parameters from and to (RFC3339 or epoch
+// milliseconds), login, action, project, subsystem, datapoint and limit narrow down the result.
+//goland:noinspection GoUnusedParameter
+func ListAudit(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    filter, err := parseAuditFilter(request)
+    if err != nil {
+        return nil, err
+    }
+    topic := model.MainNamespace + "/" + model.AuditTopics + strconv.FormatInt(orgId, 10)
+    reader := clients.Pulsar.CreateReader(topic, true)
+    if reader == nil {
+        return nil, fmt.Errorf("%w: unable to read the audit trail", model.ErrServerError)
+    }
+    defer reader.Close()
+    err = reader.SeekByTime(filter.From)
+    if err != nil {
+        log.DefaultLogger.Error(fmt.Sprintf("Unable to seek to %s: %+v", filter.From, err))
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    entries := make([]model.AuditEntry, 0)
+    for reader.HasNext() {
+        msg, err := reader.Next(ctx)
+        if err != nil {
+            log.DefaultLogger.Error(fmt.Sprintf("Unable to read audit entry: %+v", err))
+            break
+        }
+        var entry model.AuditEntry
+        err = json.Unmarshal(msg.Payload(), &entry)
+        if err != nil {
+            log.DefaultLogger.Error(fmt.Sprintf("Could not unmarshall audit entry: %v", err))
+            continue
+        }
+        if !filter.To.IsZero() && entry.Time.After(filter.To) {
+            break
+        }
+        if filter.Matches(&entry) {
+            entries = append(entries, entry)
+            if len(entries) > filter.Limit {
+                entries = entries[1:]
+            }
+        }
+    }
+    for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+        entries[i], entries[j] = entries[j], entries[i]
+    }
+    rawJson, err := json.Marshal(entries)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+func parseAuditFilter(request *Request) (model.AuditFilter, error) {
+    filter := model.AuditFilter{
+        From:      time.Now().Add(-defaultAuditPeriod),
+        Login:     request.Query.Get("login"),
+        Action:    request.Query.Get("action"),
+        Project:   request.Query.Get("project"),
+        Subsystem: request.Query.Get("subsystem"),
+        Datapoint: request.Query.Get("datapoint"),
+        Limit:     defaultAuditLimit,
+    }
+    var err error
+    if from := request.Query.Get("from"); from != "" {
+        if filter.From, err = parseTime(from); err != nil {
+            return filter, fmt.Errorf("%w: from: %s", model.ErrBadRequest, err.Error())
+        }
+    }
+    if to := request.Query.Get("to"); to != "" {
+        if filter.To, err = parseTime(to); err != nil {
+            return filter, fmt.Errorf("%w: to: %s", model.ErrBadRequest, err.Error())
+        }
+    }
+    if limit := request.Query.Get("limit"); limit != "" {
+        filter.Limit, err = strconv.Atoi(limit)
+        if err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+            return filter, fmt.Errorf("%w: limit must be between 1 and %d", model.ErrBadRequest, maxAuditLimit)
+        }
+    }
+    return filter, nil
+}
+
+// parseTime accepts RFC3339 and epoch milliseconds, which is what Grafana uses for time ranges.
+func parseTime(text string) (time.Time, error) {
+    if millis, err := strconv.ParseInt(text, 10, 64); err == nil {
+        return time.UnixMilli(millis), nil
+    }
+    return time.Parse(time.RFC3339, text)
+}
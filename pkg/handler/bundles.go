@@ -0,0 +1,404 @@
+package handler
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "reflect"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+    "gopkg.in/yaml.v3"
+)
+
+// ExportProject returns the project with all subsystems and datapoints as a model.ProjectBundle. The query parameter
+// format is "json" (default) or "yaml", and credentials are redacted unless redact=false is given.
+//goland:noinspection GoUnusedParameter
+func ExportProject(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("ExportProject()")
+    if len(params) < 2 {
+        return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+    }
+    format := request.Query.Get("format")
+    if format == "" {
+        format = "json"
+    }
+    if format != "json" && format != "yaml" {
+        return nil, fmt.Errorf("%w: format must be json or yaml", model.ErrBadRequest)
+    }
+    bundle, err := exportBundle(orgId, params[1], request.Query.Get("redact") != "false", clients)
+    if err != nil {
+        return nil, err
+    }
+    document, contentType, err := encodeBundle(bundle, format)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Headers: map[string][]string{
+            "Content-Type":        {contentType},
+            "Content-Disposition": {"attachment; filename=\"" + params[1] + "." + format + "\""},
+        },
+        Body: document,
+    }, nil
+}
+
+func exportBundle(orgId int64, projectName string, redact bool, clients *client.Clients) (model.ProjectBundle, error) {
+    project, err := clients.Cassandra.GetProject(orgId, projectName)
+    if err != nil {
+        return model.ProjectBundle{}, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if project.Name == "" {
+        return model.ProjectBundle{}, fmt.Errorf("%w: project %s", model.ErrNotFound, projectName)
+    }
+    bundle := model.ProjectBundle{
+        Version:    model.BundleVersion,
+        Exported:   time.Now().UTC(),
+        Project:    project,
+        Subsystems: make([]model.SubsystemBundle, 0),
+    }
+    subsystems, err := clients.Cassandra.FindAllSubsystems(orgId, projectName)
+    if err != nil {
+        return model.ProjectBundle{}, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    for _, subsystem := range subsystems {
+        datapoints, err := clients.Cassandra.FindAllDatapoints(orgId, projectName, subsystem.Name)
+        if err != nil {
+            return model.ProjectBundle{}, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        if redact {
+            for i := range datapoints {
+                datapoints[i] = datapoints[i].Redacted()
+            }
+        }
+        bundle.Subsystems = append(bundle.Subsystems, model.SubsystemBundle{
+            Subsystem:  subsystem,
+            Datapoints: datapoints,
+        })
+    }
+    return bundle, nil
+}
+
+// encodeBundle writes the bundle as JSON or YAML. The YAML document is converted from the JSON one, so that both use
+// the same field names.
+func encodeBundle(bundle model.ProjectBundle, format string) ([]byte, string, error) {
+    document, err := json.MarshalIndent(bundle, "", "  ")
+    if err != nil || format == "json" {
+        return document, "application/json", err
+    }
+    var generic interface{}
+    err = json.Unmarshal(document, &generic)
+    if err != nil {
+        return nil, "", err
+    }
+    document, err = yaml.Marshal(generic)
+    return document, "application/yaml", err
+}
+
+// ImportBundle compares a model.ProjectBundle, in JSON or YAML, with the current configuration of the project and
+// returns the model.BundlePlan. Everything in the project that is not in the bundle is deleted, which requires the roles
+// of subsystems.delete and datapoints.delete. The changes are only published to the configuration topic if the query
+// parameter apply=true is given. Unlike the routes that change one thing at a time, the bundle is not checked against
+// If-Match; it replaces whatever is there, so the plan that comes without apply=true is the way to see what that is.
+//goland:noinspection GoUnusedParameter
+func ImportBundle(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("ImportBundle()")
+    bundle, err := decodeBundle(body)
+    if err != nil {
+        return nil, err
+    }
+    err = validateBundle(&bundle)
+    if err != nil {
+        return nil, err
+    }
+    current, err := exportBundle(orgId, bundle.Project.Name, false, clients)
+    if errors.Is(err, model.ErrNotFound) {
+        current = model.ProjectBundle{}
+    } else if err != nil {
+        return nil, err
+    }
+    return planAndApply(orgId, &bundle, &current, request, clients)
+}
+
+// planAndApply plans the changes from current to bundle and checks them against the plan limits. The changes are
+// published to the configuration topic if the query parameter apply=true is given, and the user is allowed to delete
+// what the plan deletes. The response is the model.BundlePlan.
+func planAndApply(orgId int64, bundle *model.ProjectBundle, current *model.ProjectBundle, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    plan, messages, err := planBundle(orgId, bundle, current)
+    if err != nil {
+        return nil, err
+    }
+    err = checkBundleLimits(orgId, bundle, &plan, clients)
+    if err != nil {
+        return nil, err
+    }
+    status := http.StatusOK
+    if request.Query.Get("apply") == "true" {
+        err = authorizeDeletes(&plan, request)
+        if err != nil {
+            return nil, err
+        }
+        command, err := submitCommand(orgId, request, clients, messages...)
+        if err != nil {
+            return nil, err
+        }
+        plan.Applied = true
+        plan.Command = &command
+        status = http.StatusAccepted
+    }
+    rawJson, err := json.Marshal(plan)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: status,
+        Body:   rawJson,
+    }, nil
+}
+
+// authorizeDeletes checks the deletes of the plan against the roles of the routes that delete one at a time, so that
+// leaving things out of a bundle doesn't get around them.
+func authorizeDeletes(plan *model.BundlePlan, request *Request) error {
+    checked := map[string]bool{}
+    for _, change := range plan.Changes {
+        if change.Action != model.Delete || checked[change.Kind] {
+            continue
+        }
+        checked[change.Kind] = true
+        err := request.Allow(change.Kind + "s.delete")
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// decodeBundle accepts JSON, and otherwise tries YAML, which is converted to JSON so that the same field names apply.
+func decodeBundle(body []byte) (model.ProjectBundle, error) {
+    var bundle model.ProjectBundle
+    document := body
+    if !json.Valid(body) {
+        var generic interface{}
+        err := yaml.Unmarshal(body, &generic)
+        if err != nil {
+            return bundle, fmt.Errorf("%w: neither JSON nor YAML: %s", model.ErrBadRequest, err.Error())
+        }
+        document, err = json.Marshal(generic)
+        if err != nil {
+            return bundle, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+        }
+    }
+    err := json.Unmarshal(document, &bundle)
+    if err != nil {
+        return bundle, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    return bundle, nil
+}
+
+func validateBundle(bundle *model.ProjectBundle) error {
+    errs := &model.ValidationError{}
+    if bundle.Version != model.BundleVersion {
+        errs.Add("version", "version %d is not supported, expected %d", bundle.Version, model.BundleVersion)
+    }
+    addValidation(errs, "project.", bundle.Project.Validate())
+    subsystems := map[string]bool{}
+    for i, sb := range bundle.Subsystems {
+        prefix := "subsystems[" + strconv.Itoa(i) + "]."
+        addValidation(errs, prefix+"subsystem.", sb.Subsystem.Validate())
+        if sb.Subsystem.Project != bundle.Project.Name {
+            errs.Add(prefix+"subsystem.project", "must be %s", bundle.Project.Name)
+        }
+        if subsystems[sb.Subsystem.Name] {
+            errs.Add(prefix+"subsystem.name", "%s is given more than once", sb.Subsystem.Name)
+        }
+        subsystems[sb.Subsystem.Name] = true
+        datapoints := map[string]bool{}
+        for j, dp := range sb.Datapoints {
+            dpPrefix := prefix + "datapoints[" + strconv.Itoa(j) + "]."
+            addValidation(errs, dpPrefix, dp.Validate())
+            if dp.Project != bundle.Project.Name || dp.Subsystem != sb.Subsystem.Name {
+                errs.Add(dpPrefix+"subsystem", "must be %s/%s", bundle.Project.Name, sb.Subsystem.Name)
+            }
+            if datapoints[dp.Name] {
+                errs.Add(dpPrefix+"name", "%s is given more than once", dp.Name)
+            }
+            datapoints[dp.Name] = true
+        }
+    }
+    return errs.Err()
+}
+
+// addValidation adds the field errors of a model.ValidationError, with the fields prefixed.
+func addValidation(errs *model.ValidationError, prefix string, err error) {
+    var validationErr *model.ValidationError
+    if errors.As(err, &validationErr) {
+        for _, fe := range validationErr.Errors {
+            fe.Field = prefix + fe.Field
+            errs.Errors = append(errs.Errors, fe)
+        }
+    } else if err != nil {
+        errs.Add(prefix, "%s", err.Error())
+    }
+}
+
+// planBundle returns the changes from current to bundle, and the configuration messages that carry them out. Datapoints
+// are deleted before subsystems, and created after them. Redacted credentials are taken from the current datapoints,
+// and it is a model.ValidationError if there is no current credential to take.
+func planBundle(orgId int64, bundle *model.ProjectBundle, current *model.ProjectBundle) (model.BundlePlan, []configurationMessage, error) {
+    plan := model.BundlePlan{
+        Project: bundle.Project.Name,
+        Changes: make([]model.BundleChange, 0),
+    }
+    var messages []configurationMessage
+    var deletes []configurationMessage
+    errs := &model.ValidationError{}
+    projectName := bundle.Project.Name
+
+    if current.Project.Name == "" {
+        plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Create, Kind: "project", Path: projectName})
+        messages = append(messages, configurationMessage{operation: "updateProject", payload: bundle.Project})
+    } else if fields := changedFields(current.Project, bundle.Project); len(fields) > 0 {
+        plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Update, Kind: "project", Path: projectName, Fields: fields})
+        messages = append(messages, configurationMessage{operation: "updateProject", payload: bundle.Project})
+    } else {
+        plan.Unchanged++
+    }
+
+    currentSubsystems := map[string]model.SubsystemBundle{}
+    for _, sb := range current.Subsystems {
+        currentSubsystems[sb.Subsystem.Name] = sb
+    }
+    wanted := map[string]bool{}
+    for i, sb := range bundle.Subsystems {
+        wanted[sb.Subsystem.Name] = true
+        path := projectName + "/" + sb.Subsystem.Name
+        existing, found := currentSubsystems[sb.Subsystem.Name]
+        if !found {
+            plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Create, Kind: "subsystem", Path: path})
+            messages = append(messages, configurationMessage{operation: "updateSubsystem", payload: sb.Subsystem})
+        } else if fields := changedFields(existing.Subsystem, sb.Subsystem); len(fields) > 0 {
+            plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Update, Kind: "subsystem", Path: path, Fields: fields})
+            messages = append(messages, configurationMessage{operation: "updateSubsystem", payload: sb.Subsystem})
+        } else {
+            plan.Unchanged++
+        }
+
+        currentDatapoints := map[string]model.DatapointSettings{}
+        for _, dp := range existing.Datapoints {
+            currentDatapoints[dp.Name] = dp
+        }
+        wantedDatapoints := map[string]bool{}
+        for j, dp := range sb.Datapoints {
+            wantedDatapoints[dp.Name] = true
+            dpPath := path + "/" + dp.Name
+            old, found := currentDatapoints[dp.Name]
+            dp = dp.WithSecretsFrom(old)
+            if field := dp.RedactedField(); field != "" {
+                errs.Add("subsystems["+strconv.Itoa(i)+"].datapoints["+strconv.Itoa(j)+"]."+field, "is %s, but there is no saved credential to keep", model.RedactedSecret)
+            }
+            if !found {
+                plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Create, Kind: "datapoint", Path: dpPath})
+                messages = append(messages, configurationMessage{operation: "updateDatapoint", payload: dp})
+            } else if fields := changedFields(old, dp); len(fields) > 0 {
+                plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Update, Kind: "datapoint", Path: dpPath, Fields: fields})
+                messages = append(messages, configurationMessage{operation: "updateDatapoint", payload: dp})
+            } else {
+                plan.Unchanged++
+            }
+        }
+        for _, dp := range existing.Datapoints {
+            if !wantedDatapoints[dp.Name] {
+                plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Delete, Kind: "datapoint", Path: path + "/" + dp.Name})
+                deletes = append(deletes, deleteDatapointMessage(orgId, projectName, sb.Subsystem.Name, dp.Name))
+            }
+        }
+    }
+    for _, sb := range current.Subsystems {
+        if wanted[sb.Subsystem.Name] {
+            continue
+        }
+        path := projectName + "/" + sb.Subsystem.Name
+        for _, dp := range sb.Datapoints {
+            plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Delete, Kind: "datapoint", Path: path + "/" + dp.Name})
+            deletes = append(deletes, deleteDatapointMessage(orgId, projectName, sb.Subsystem.Name, dp.Name))
+        }
+        plan.Changes = append(plan.Changes, model.BundleChange{Action: model.Delete, Kind: "subsystem", Path: path})
+        deletes = append(deletes, configurationMessage{operation: "deleteSubsystem", payload: map[string]string{
+            "project":   projectName,
+            "subsystem": sb.Subsystem.Name,
+        }})
+    }
+    return plan, append(messages, deletes...), errs.Err()
+}
+
+func deleteDatapointMessage(orgId int64, project string, subsystem string, datapoint string) configurationMessage {
+    return configurationMessage{operation: "deleteDatapoint", payload: model.DatapointIdentifier{
+        OrgId:     orgId,
+        Project:   project,
+        Subsystem: subsystem,
+        Datapoint: datapoint,
+    }}
+}
+
+// changedFields compares the JSON form of two settings, and returns the names of the top level fields that differ.
+func changedFields(before interface{}, after interface{}) []string {
+    var b, a map[string]interface{}
+    beforeJson, _ := json.Marshal(before)
+    afterJson, _ := json.Marshal(after)
+    _ = json.Unmarshal(beforeJson, &b)
+    _ = json.Unmarshal(afterJson, &a)
+    var result []string
+    for name, value := range a {
+        if !reflect.DeepEqual(value, b[name]) {
+            result = append(result, name)
+        }
+    }
+    for name := range b {
+        if _, found := a[name]; !found {
+            result = append(result, name)
+        }
+    }
+    sort.Strings(result)
+    return result
+}
+
+// checkBundleLimits applies the plan limits to the created and updated datapoints, and to the number of datapoints in
+// the organization after the plan has been carried out.
+func checkBundleLimits(orgId int64, bundle *model.ProjectBundle, plan *model.BundlePlan, clients *client.Clients) error {
+    limits, err := clients.Cassandra.GetCurrentLimits(orgId)
+    if err != nil {
+        return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    errs := &model.ValidationError{Cause: model.ErrPlanLimitExceeded}
+    for i, sb := range bundle.Subsystems {
+        for j, dp := range sb.Datapoints {
+            prefix := "subsystems[" + strconv.Itoa(i) + "].datapoints[" + strconv.Itoa(j) + "]."
+            dpErrs := &model.ValidationError{}
+            checkDatapointLimits(dpErrs, limits, dp)
+            addValidation(errs, prefix, dpErrs.Err())
+        }
+    }
+    count, err := countDatapointsInOrg(clients.Cassandra, orgId)
+    if err != nil {
+        return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    total := int64(count)
+    for _, change := range plan.Changes {
+        if change.Kind == "datapoint" && change.Action == model.Create {
+            total++
+        } else if change.Kind == "datapoint" && change.Action == model.Delete {
+            total--
+        }
+    }
+    if total > int64(limits.MaxDatapoints) {
+        errs.Add("datapoints", "%d datapoints exceeds the maximum of %d", total, limits.MaxDatapoints)
+    }
+    return errs.Err()
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// CloneProject copies the project in the path, with all subsystems and datapoints, to a new project, as told by the
+// model.CloneOptions in the body. Like ImportBundle, it returns the model.BundlePlan, and the copy is only published to
+// the configuration topic if the query parameter apply=true is given. A copy never overwrites anything, a project
+// with the new name is a model.ErrConflict, so there is no version to check.
+//goland:noinspection GoUnusedParameter
+func CloneProject(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("CloneProject()")
+    if len(params) < 2 {
+        return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+    }
+    options, err := decodeCloneOptions(body)
+    if err != nil {
+        return nil, err
+    }
+    original, err := exportBundle(orgId, params[1], false, clients)
+    if err != nil {
+        return nil, err
+    }
+    existing, err := clients.Cassandra.GetProject(orgId, options.Name)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if existing.Name != "" {
+        return nil, fmt.Errorf("%w: project %s already exists", model.ErrConflict, options.Name)
+    }
+
+    bundle := model.ProjectBundle{Version: model.BundleVersion, Project: original.Project, Subsystems: make([]model.SubsystemBundle, 0)}
+    bundle.Project.Name = options.Name
+    if options.Title != "" {
+        bundle.Project.Title = options.Title
+    }
+    substitute, used := options.Substituter()
+    for _, sb := range original.Subsystems {
+        subsystem := sb.Subsystem
+        subsystem.Project = options.Name
+        subsystem.Name = options.NamePrefix.Apply(subsystem.Name)
+        bundle.Subsystems = append(bundle.Subsystems, clonedSubsystem(subsystem, sb.Datapoints, &options, substitute))
+    }
+    return applyClone(orgId, &bundle, &model.ProjectBundle{}, &options, used, request, clients)
+}
+
+// CloneSubsystem copies the subsystem in the path, with all datapoints, to a new subsystem in the same or another
+// project, as told by the model.CloneOptions in the body. The response is the same as from CloneProject.
+//goland:noinspection GoUnusedParameter
+func CloneSubsystem(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("CloneSubsystem()")
+    if len(params) < 3 {
+        return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+    }
+    options, err := decodeCloneOptions(body)
+    if err != nil {
+        return nil, err
+    }
+    if options.Project == "" {
+        options.Project = params[1]
+    }
+    original, err := clients.Cassandra.GetSubsystem(orgId, params[1], params[2])
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if original.Name == "" {
+        return nil, fmt.Errorf("%w: subsystem %s/%s", model.ErrNotFound, params[1], params[2])
+    }
+    datapoints, err := clients.Cassandra.FindAllDatapoints(orgId, params[1], params[2])
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    project, err := clients.Cassandra.GetProject(orgId, options.Project)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if project.Name == "" {
+        return nil, fmt.Errorf("%w: project %s", model.ErrNotFound, options.Project)
+    }
+    existing, err := clients.Cassandra.GetSubsystem(orgId, options.Project, options.Name)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if existing.Name != "" {
+        return nil, fmt.Errorf("%w: subsystem %s/%s already exists", model.ErrConflict, options.Project, options.Name)
+    }
+
+    subsystem := original
+    subsystem.Project = options.Project
+    subsystem.Name = options.Name
+    if options.Title != "" {
+        subsystem.Title = options.Title
+    }
+    substitute, used := options.Substituter()
+    bundle := model.ProjectBundle{Version: model.BundleVersion, Project: project, Subsystems: []model.SubsystemBundle{
+        clonedSubsystem(subsystem, datapoints, &options, substitute),
+    }}
+    // the rest of the project is left out of the current configuration, so that the plan only creates the copy
+    return applyClone(orgId, &bundle, &model.ProjectBundle{Project: project}, &options, used, request, clients)
+}
+
+func decodeCloneOptions(body []byte) (model.CloneOptions, error) {
+    var options model.CloneOptions
+    err := json.Unmarshal(body, &options)
+    if err != nil {
+        return options, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    if options.Name == "" {
+        errs := &model.ValidationError{}
+        errs.Add("name", "must not be empty")
+        return options, errs
+    }
+    return options, nil
+}
+
+func clonedSubsystem(subsystem model.SubsystemSettings, datapoints []model.DatapointSettings, options *model.CloneOptions, substitute func(string) string) model.SubsystemBundle {
+    sb := model.SubsystemBundle{Subsystem: subsystem, Datapoints: make([]model.DatapointSettings, 0, len(datapoints))}
+    for _, dp := range datapoints {
+        sb.Datapoints = append(sb.Datapoints, dp.Cloned(subsystem.Project, subsystem.Name, options, substitute))
+    }
+    return sb
+}
+
+// applyClone validates the copy and plans it like an imported bundle. Substitutions that match nothing are errors,
+// as a mistyped device id would otherwise give a copy that polls the same device as the original.
+func applyClone(orgId int64, bundle *model.ProjectBundle, current *model.ProjectBundle, options *model.CloneOptions, used map[string]bool, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    errs := &model.ValidationError{}
+    addValidation(errs, "", validateBundle(bundle))
+    unused := make([]string, 0)
+    for key := range options.Substitutions {
+        if !used[key] {
+            unused = append(unused, key)
+        }
+    }
+    sort.Strings(unused)
+    for _, key := range unused {
+        errs.Add("substitutions."+key, "matches no device, topic or URL of the datapoints")
+    }
+    err := errs.Err()
+    if err != nil {
+        return nil, err
+    }
+    return planAndApply(orgId, bundle, current, request, clients)
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// configurationMessage is one message on the configuration topic. The payload is sent as is if it is a []byte, and
+// otherwise as JSON. The key defaults to "2:{orgId}:{operation}".
+type configurationMessage struct {
+    operation string
+    payload   interface{}
+    key       string
+}
+
+func (m *configurationMessage) keyOf(orgId int64) string {
+    if m.key != "" {
+        return m.key
+    }
+    return "2:" + strconv.FormatInt(orgId, 10) + ":" + m.operation
+}
+
+func (m *configurationMessage) data() ([]byte, error) {
+    if raw, ok := m.payload.([]byte); ok {
+        return raw, nil
+    }
+    return json.Marshal(m.payload)
+}
+
+// SubmitConfiguration publishes a single configuration message as a command, see submitCommand.
+func SubmitConfiguration(orgId int64, operation string, payload interface{}, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    return commandAccepted(submitCommand(orgId, request, clients, configurationMessage{operation: operation, payload: payload}))
+}
+
+// submitCommand publishes the messages on the configuration topic as one command, with a new command id. The request id
+// is kept in the status, to correlate the command with the call, but it is chosen by the client and so can't be the id.
+// The backend reports the outcome of each message on model.CommandResultTopic, which is what GET _commands/{id} and
+// the _commands stream show. If a message can't be published, the command fails and the remaining ones are not sent.
+func submitCommand(orgId int64, request *Request, clients *client.Clients, messages ...configurationMessage) (model.CommandStatus, error) {
+    operation := ""
+    if len(messages) > 0 {
+        operation = messages[0].operation
+    }
+    id := newCommandId()
+    status := clients.Commands.Submit(orgId, id, request.Id, operation, len(messages))
+    for _, message := range messages {
+        data, err := message.data()
+        if err == nil && clients.Pulsar.SendCommand(model.ConfigurationTopic, message.keyOf(orgId), id, data) == nil {
+            err = fmt.Errorf("unable to publish %s", message.operation)
+        }
+        if err != nil {
+            clients.Commands.Fail(orgId, id, err.Error())
+            return status, fmt.Errorf("%w: command %s: %s", model.ErrServerError, id, err.Error())
+        }
+    }
+    return status, nil
+}
+
+func newCommandId() string {
+    id := make([]byte, 16)
+    _, _ = rand.Read(id)
+    return hex.EncodeToString(id)
+}
+
+// commandAccepted is the 202 Accepted response of a submitted command, with its status and where to follow it.
+func commandAccepted(status model.CommandStatus, err error) (*backend.CallResourceResponse, error) {
+    if err != nil {
+        return nil, err
+    }
+    rawJson, err := json.Marshal(status)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusAccepted,
+        Headers: map[string][]string{
+            "Location": {"_commands/" + status.Id},
+        },
+        Body: rawJson,
+    }, nil
+}
+
+// GetCommand returns the model.CommandStatus of a command submitted within the last hour.
+//goland:noinspection GoUnusedParameter
+func GetCommand(orgId int64, params []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("GetCommand()")
+    if len(params) < 2 {
+        return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+    }
+    status, found := clients.Commands.Get(orgId, params[1])
+    if !found {
+        return nil, fmt.Errorf("%w: command %s", model.ErrNotFound, params[1])
+    }
+    rawJson, err := json.Marshal(status)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
@@ -1,5 +1,67 @@
 package handler
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Sensetif/sensetif-datasource/pkg/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Request holds the parts of a resource call that are not captured by the path pattern.
+type Request struct {
+	Id      string
+	User    *backend.User
+	Query   url.Values
+	Headers map[string][]string
+	// Authorize checks the role of the user against another route class, for calls that carry out the changes of
+	// several routes at once.
+	Authorize func(route string) error
+	// Stream sends the response in parts, for handlers that return nil instead of a response. The first part has the
+	// status and headers, and the following ones more of the body.
+	Stream backend.CallResourceResponseSender
+	// Ctx is cancelled when the caller goes away, which ends the calls to outside services.
+	Ctx context.Context
+}
+
+// Context returns the context of the call, or the background context if there is none.
+func (r *Request) Context() context.Context {
+	if r.Ctx == nil {
+		return context.Background()
+	}
+	return r.Ctx
+}
+
+// Header returns the first value of the header, ignoring the case of the name.
+func (r *Request) Header(name string) string {
+	for key, values := range r.Headers {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// Allow checks that the user may also call the route, which is refused if the request doesn't know how to authorize.
+func (r *Request) Allow(route string) error {
+	if r.Authorize == nil {
+		return fmt.Errorf("%w: %s can not be authorized", model.ErrForbidden, route)
+	}
+	return r.Authorize(route)
+}
+
+// Login returns the login of the calling user, or an empty string if unknown.
+func (r *Request) Login() string {
+	if r.User == nil {
+		return ""
+	}
+	return r.User.Login
+}
+
 func getParams(params map[string]string, names ...string) (values, missing []string) {
 	for _, paramName := range names {
 		if value, ok := params[paramName]; ok {
@@ -12,3 +74,44 @@ func getParams(params map[string]string, names ...string) (values, missing []str
 
 	return values, missing
 }
+
+type validatable interface {
+	Validate() error
+}
+
+// pathField is a field of the settings that must be equal to a path parameter.
+type pathField struct {
+	field string
+	value string
+}
+
+// decodeSettings unmarshals the body into settings and validates them. Malformed JSON is a bad request, while invalid
+// settings result in a model.ValidationError.
+func decodeSettings(body []byte, settings validatable) error {
+	err := json.Unmarshal(body, settings)
+	if err != nil {
+		return fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+	}
+	return settings.Validate()
+}
+
+// checkPath adds an error to the validation result for each field that differs from the path parameter at the same
+// position. params[0] is the full match of the path and is skipped.
+func checkPath(validation error, params []string, fields ...pathField) error {
+	errs := &model.ValidationError{}
+	var validationErr *model.ValidationError
+	if errors.As(validation, &validationErr) {
+		errs.Errors = append(errs.Errors, validationErr.Errors...)
+	} else if validation != nil {
+		return validation
+	}
+	for i, f := range fields {
+		if i+1 >= len(params) {
+			return fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+		}
+		if params[i+1] != f.value {
+			errs.Add(f.field, "\"%s\" does not match \"%s\" in the path", f.value, params[i+1])
+		}
+	}
+	return errs.Err()
+}
@@ -0,0 +1,360 @@
+package handler
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const importBatchSize = 500
+
+// importTimeLayouts are tried when the timestamp format is detected. Every row must match, and only one layout may,
+// so a file where no day is larger than 12 must say with timeformat whether it is day-first or month-first.
+var importTimeLayouts = []string{
+    time.RFC3339Nano,
+    "2006-01-02T15:04:05",
+    "2006-01-02 15:04:05",
+    "2006-01-02T15:04",
+    "2006-01-02 15:04",
+    "01/02/2006 15:04:05",
+    "01/02/2006 15:04",
+    "02/01/2006 15:04:05",
+    "02/01/2006 15:04",
+    "02.01.2006 15:04:05",
+    "02.01.2006 15:04",
+    "2006-01-02",
+}
+
+type importColumn struct {
+    index  int
+    name   string
+    sensor model.SensorRef
+    check  sampleCheck
+    values [][]byte
+    series model.ImportedSeries
+}
+
+// ImportTimeseries reads historical samples from a CSV file with a time column and one column per datapoint, and
+// publishes them on the timeseries topic. The samples are checked like those of UpdateTimeseries, and those that are
+// rejected are reported as model.RowError. Query parameters;
+//   map        {column}={project}/{subsystem}/{datapoint}, repeated for each column. If not given, all columns with a
+//              {project}/{subsystem}/{datapoint} header are imported, which is what the wide CSV export produces.
+//   time       name of the time column, defaults to the first column
+//   timeformat rfc3339, iso8601, excel, epochMillis, epochSeconds or a Go layout. Detected if not given.
+//   tz         timezone for timestamps without offset, defaults to the Timezone of the project of the first datapoint
+//   delimiter  ",", ";" or "tab". Detected from the header if not given.
+//   decimal    "." (default) or ","
+//   dryrun     true to only validate the file and return the summary
+//goland:noinspection GoUnusedParameter
+func ImportTimeseries(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("ImportTimeseries()")
+    body = bytes.TrimPrefix(body, []byte("\uFEFF"))
+    summary := model.TimeseriesImportSummary{
+        DryRun: request.Query.Get("dryrun") == "true",
+        Series: make([]model.ImportedSeries, 0),
+        Errors: make([]model.RowError, 0),
+    }
+    delimiter, err := importDelimiter(request.Query.Get("delimiter"), body)
+    if err != nil {
+        return nil, err
+    }
+    summary.Delimiter = string(delimiter)
+    decimal := request.Query.Get("decimal")
+    if decimal != "" && decimal != "." && decimal != "," {
+        return nil, fmt.Errorf("%w: decimal must be \".\" or \",\"", model.ErrBadRequest)
+    }
+    reader := csv.NewReader(bytes.NewReader(body))
+    reader.Comma = delimiter
+    reader.FieldsPerRecord = -1
+    records, err := reader.ReadAll()
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    if len(records) < 2 {
+        return nil, fmt.Errorf("%w: a header and at least one row is required", model.ErrBadRequest)
+    }
+    header := records[0]
+    timeIndex := 0
+    if name := request.Query.Get("time"); name != "" {
+        timeIndex = indexOf(header, name)
+        if timeIndex < 0 {
+            return nil, fmt.Errorf("%w: there is no time column \"%s\"", model.ErrBadRequest, name)
+        }
+    }
+    columns, err := importColumns(orgId, header, timeIndex, request.Query["map"], clients)
+    if err != nil {
+        return nil, err
+    }
+    rows := records[1:]
+    layout := request.Query.Get("timeformat")
+    if layout == "" {
+        layout, err = detectTimeFormat(rows, timeIndex)
+        if err != nil {
+            return nil, err
+        }
+    } else if known, found := csvTimeFormats[layout]; found {
+        layout = known
+    }
+    summary.TimeFormat = layout
+    timezone := request.Query.Get("tz")
+    if timezone == "" {
+        project, err := clients.Cassandra.GetProject(orgId, columns[0].sensor.Project)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        timezone = project.Timezone
+    }
+    if timezone == "" {
+        timezone = "UTC"
+    }
+    location, err := time.LoadLocation(timezone)
+    if err != nil {
+        return nil, fmt.Errorf("%w: unknown timezone \"%s\"", model.ErrBadRequest, timezone)
+    }
+    summary.Timezone = timezone
+
+    for r, row := range rows {
+        rowNumber := r + 2
+        summary.Rows++
+        if timeIndex >= len(row) {
+            addRowError(&summary, rowNumber, header[timeIndex], "missing timestamp")
+            summary.Rejected += len(columns)
+            continue
+        }
+        ts, err := parseImportTime(strings.TrimSpace(row[timeIndex]), layout, location)
+        if err != nil {
+            addRowError(&summary, rowNumber, header[timeIndex], err.Error())
+            summary.Rejected += len(columns)
+            continue
+        }
+        for i := range columns {
+            column := &columns[i]
+            if column.index >= len(row) || strings.TrimSpace(row[column.index]) == "" {
+                continue
+            }
+            text := strings.TrimSpace(row[column.index])
+            if decimal == "," {
+                text = strings.Replace(text, ",", ".", 1)
+            }
+            value, err := strconv.ParseFloat(text, 64)
+            if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+                addRowError(&summary, rowNumber, column.name, fmt.Sprintf("\"%s\" is not a number", row[column.index]))
+                summary.Rejected++
+                continue
+            }
+            if problem := column.check.problem(model.TsPair{TS: ts, Value: value}); problem != "" {
+                addRowError(&summary, rowNumber, column.name, problem)
+                summary.Rejected++
+                continue
+            }
+            message, _ := json.Marshal(TsDatapoint{
+                Organization: orgId,
+                Project:      column.sensor.Project,
+                Subsystem:    column.sensor.Subsystem,
+                Name:         column.sensor.Datapoint,
+                Timestamp:    ts,
+                Value:        value,
+            })
+            column.values = append(column.values, message)
+            if column.series.Samples == 0 || ts.Before(column.series.First) {
+                column.series.First = ts
+            }
+            if ts.After(column.series.Last) {
+                column.series.Last = ts
+            }
+            column.series.Samples++
+            summary.Samples++
+        }
+    }
+
+    for i := range columns {
+        column := &columns[i]
+        summary.Series = append(summary.Series, column.series)
+        if summary.DryRun {
+            continue
+        }
+        key := "2:" + strconv.FormatInt(orgId, 10) + ":" + column.series.Datapoint
+        for start := 0; start < len(column.values); start += importBatchSize {
+            end := start + importBatchSize
+            if end > len(column.values) {
+                end = len(column.values)
+            }
+            sent, err := clients.Pulsar.SendBatch(model.TimeseriesTopic, key, column.values[start:end])
+            summary.Published += sent
+            if err != nil {
+                return nil, fmt.Errorf("%w: published %d of %d samples: %s", model.ErrServerError, summary.Published, summary.Samples, err.Error())
+            }
+        }
+    }
+    rawJson, err := json.Marshal(summary)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    status := http.StatusAccepted
+    if summary.DryRun {
+        status = http.StatusOK
+    }
+    return &backend.CallResourceResponse{
+        Status: status,
+        Body:   rawJson,
+    }, nil
+}
+
+// importDelimiter returns the requested delimiter, or the most frequent candidate in the header line.
+func importDelimiter(requested string, body []byte) (rune, error) {
+    switch requested {
+    case ",":
+        return ',', nil
+    case ";":
+        return ';', nil
+    case "tab":
+        return '\t', nil
+    case "":
+    default:
+        return 0, fmt.Errorf("%w: delimiter must be \",\", \";\" or \"tab\"", model.ErrBadRequest)
+    }
+    headerLine := string(body)
+    if end := strings.IndexByte(headerLine, '\n'); end >= 0 {
+        headerLine = headerLine[:end]
+    }
+    result := ','
+    count := strings.Count(headerLine, ",")
+    for _, candidate := range []rune{';', '\t'} {
+        if c := strings.Count(headerLine, string(candidate)); c > count {
+            result = candidate
+            count = c
+        }
+    }
+    return result, nil
+}
+
+// importColumns maps the CSV columns to existing datapoints.
+func importColumns(orgId int64, header []string, timeIndex int, mappings []string, clients *client.Clients) ([]importColumn, error) {
+    var columns []importColumn
+    errs := &model.ValidationError{}
+    if len(mappings) == 0 {
+        for i, name := range header {
+            if i == timeIndex {
+                continue
+            }
+            if sensors, err := parseSensorRefs([]string{name}); err == nil {
+                columns = append(columns, importColumn{index: i, name: name, sensor: sensors[0]})
+            }
+        }
+    }
+    for _, mapping := range mappings {
+        name, path, found := strings.Cut(mapping, "=")
+        index := indexOf(header, name)
+        sensors, err := parseSensorRefs([]string{path})
+        if !found || err != nil {
+            errs.Add("map", "\"%s\" is not {column}={project}/{subsystem}/{datapoint}", mapping)
+        } else if index < 0 {
+            errs.Add("map", "there is no column \"%s\"", name)
+        } else {
+            columns = append(columns, importColumn{index: index, name: name, sensor: sensors[0]})
+        }
+    }
+    if len(columns) == 0 && len(errs.Errors) == 0 {
+        errs.Add("map", "no columns are mapped to datapoints")
+    }
+    now := time.Now()
+    for i := range columns {
+        sensor := columns[i].sensor
+        path := sensor.Project + "/" + sensor.Subsystem + "/" + sensor.Datapoint
+        columns[i].series = model.ImportedSeries{Datapoint: path, Column: columns[i].name}
+        datapoint, err := clients.Cassandra.GetDatapoint(orgId, sensor.Project, sensor.Subsystem, sensor.Datapoint)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        if datapoint.Name == "" {
+            errs.Add("map", "datapoint %s does not exist", path)
+        }
+        columns[i].check = newSampleCheck(datapoint, now)
+    }
+    return columns, errs.Err()
+}
+
+// detectTimeFormat finds the one format that parses the timestamps of all rows.
+func detectTimeFormat(rows [][]string, timeIndex int) (string, error) {
+    var samples []string
+    for _, row := range rows {
+        if timeIndex < len(row) && strings.TrimSpace(row[timeIndex]) != "" {
+            samples = append(samples, strings.TrimSpace(row[timeIndex]))
+        }
+    }
+    if len(samples) == 0 {
+        return "", fmt.Errorf("%w: there are no timestamps", model.ErrBadRequest)
+    }
+    candidates := append([]string{string(model.EpochMillis), string(model.EpochSeconds)}, importTimeLayouts...)
+    var matching []string
+    for _, candidate := range candidates {
+        matches := true
+        for _, sample := range samples {
+            if _, err := parseImportTime(sample, candidate, time.UTC); err != nil {
+                matches = false
+                break
+            }
+        }
+        if matches {
+            matching = append(matching, candidate)
+        }
+    }
+    switch len(matching) {
+    case 0:
+        return "", fmt.Errorf("%w: unable to detect the format of timestamps like \"%s\", use timeformat", model.ErrBadRequest, samples[0])
+    case 1:
+        return matching[0], nil
+    }
+    return "", fmt.Errorf("%w: timestamps like \"%s\" are ambiguous, they match %s, use timeformat", model.ErrBadRequest, samples[0], strings.Join(matching, " and "))
+}
+
+// parseImportTime parses the timestamp with a Go layout, or as epoch seconds or milliseconds. Epoch values are told
+// apart by magnitude, so that millisecond values are not mistaken for seconds far in the future.
+func parseImportTime(text string, layout string, location *time.Location) (time.Time, error) {
+    switch layout {
+    case string(model.EpochMillis), string(model.EpochSeconds):
+        value, err := strconv.ParseInt(text, 10, 64)
+        if err != nil {
+            return time.Time{}, fmt.Errorf("\"%s\" is not %s", text, layout)
+        }
+        isMillis := value > 100000000000 || value < -100000000000
+        if layout == string(model.EpochMillis) && isMillis {
+            return time.UnixMilli(value), nil
+        }
+        if layout == string(model.EpochSeconds) && !isMillis {
+            return time.Unix(value, 0), nil
+        }
+        return time.Time{}, fmt.Errorf("\"%s\" is not %s", text, layout)
+    }
+    ts, err := time.ParseInLocation(layout, text, location)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("\"%s\" does not match %s", text, layout)
+    }
+    return ts, nil
+}
+
+func addRowError(summary *model.TimeseriesImportSummary, row int, column string, message string) {
+    if len(summary.Errors) < model.MaxRowErrors {
+        summary.Errors = append(summary.Errors, model.RowError{Row: row, Column: column, Message: message})
+    }
+}
+
+func indexOf(values []string, value string) int {
+    for i, v := range values {
+        if strings.TrimSpace(v) == value {
+            return i
+        }
+    }
+    return -1
+}
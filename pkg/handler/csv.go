@@ -0,0 +1,315 @@
+package handler
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// csvTimeFormats are the selectable timestamp formats. "excel" is understood by spreadsheets without conversion.
+var csvTimeFormats = map[string]string{
+    "rfc3339": time.RFC3339,
+    "iso8601": "2006-01-02T15:04:05.000Z07:00",
+    "excel":   "2006-01-02 15:04:05",
+}
+
+type csvOptions struct {
+    timeFormat string
+    location   *time.Location
+    delimiter  rune
+    decimal    string
+    wide       bool
+    bom        bool
+}
+
+// maxExportDatapoints and maxExportRange limit what a single export reads.
+const maxExportDatapoints = 20
+
+const maxExportRange = 366 * 24 * time.Hour
+
+// exportWindow is how much of a datapoint is read from Cassandra at a time, so that an export never holds more than
+// that in memory.
+const exportWindow = 24 * time.Hour
+
+// csvChunkSize is about how many bytes are sent in each part of the streamed response.
+const csvChunkSize = 64 * 1024
+
+// ExportTimeseries streams the raw samples of one or more datapoints as CSV. The values are as stored, without the
+// ScaleFunc of the datapoints, so that an export can be imported again. Query parameters;
+//   datapoint  {project}/{subsystem}/{datapoint}, repeated for each datapoint, at most maxExportDatapoints
+//   from, to   RFC3339 or epoch milliseconds, defaults to the last 24 hours, at most maxExportRange apart
+//   timeformat rfc3339 (default), iso8601, excel, epochMillis or epochSeconds
+//   tz         timezone of the timestamps, defaults to the Timezone of the project of the first datapoint
+//   delimiter  "," (default), ";" or "tab"
+//   decimal    "." (default) or ","
+//   layout     long (default), one row per sample, or wide, one column per datapoint
+//   bom        true to start with a UTF-8 byte order mark, which Excel needs to detect the encoding
+// The response is sent through Request.Stream, so the returned response is nil.
+//goland:noinspection GoUnusedParameter
+func ExportTimeseries(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("ExportTimeseries()")
+    sensors, err := parseSensorRefs(request.Query["datapoint"])
+    if err != nil {
+        return nil, err
+    }
+    if len(sensors) > maxExportDatapoints {
+        return nil, fmt.Errorf("%w: at most %d datapoints can be exported at once", model.ErrBadRequest, maxExportDatapoints)
+    }
+    to := time.Now()
+    from := to.Add(-24 * time.Hour)
+    if text := request.Query.Get("from"); text != "" {
+        if from, err = parseTime(text); err != nil {
+            return nil, fmt.Errorf("%w: from: %s", model.ErrBadRequest, err.Error())
+        }
+    }
+    if text := request.Query.Get("to"); text != "" {
+        if to, err = parseTime(text); err != nil {
+            return nil, fmt.Errorf("%w: to: %s", model.ErrBadRequest, err.Error())
+        }
+    }
+    if !from.Before(to) {
+        return nil, fmt.Errorf("%w: from must be before to", model.ErrBadRequest)
+    }
+    if to.Sub(from) > maxExportRange {
+        return nil, fmt.Errorf("%w: from and to can be at most %d days apart", model.ErrBadRequest, int(maxExportRange.Hours()/24))
+    }
+    options, err := parseCsvOptions(orgId, sensors[0].Project, request, clients)
+    if err != nil {
+        return nil, err
+    }
+    err = request.Stream.Send(&backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Headers: map[string][]string{
+            "Content-Type":        {"text/csv; charset=utf-8"},
+            "Content-Disposition": {"attachment; filename=\"timeseries.csv\""},
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+    chunks := &chunkSender{stream: request.Stream}
+    if options.bom {
+        chunks.buffer.WriteString("\uFEFF")
+    }
+    writer := csv.NewWriter(chunks)
+    writer.Comma = options.delimiter
+    read := func(sensor model.SensorRef, start time.Time, end time.Time) ([]model.TsPair, error) {
+        samples, err := clients.Cassandra.ReadTimeseries(orgId, sensor, start, end)
+        if err != nil {
+            // the status is sent already, so the error cuts the export short rather than leaving out a part of it
+            return nil, fmt.Errorf("%w: unable to read %s/%s/%s: %s", model.ErrServerError, sensor.Project, sensor.Subsystem, sensor.Datapoint, err.Error())
+        }
+        return samples, nil
+    }
+    if options.wide {
+        err = writeWideCsv(writer, sensors, from, to, read, options)
+    } else {
+        err = writeLongCsv(writer, sensors, from, to, read, options)
+    }
+    if err == nil {
+        err = chunks.flush()
+    }
+    return nil, err
+}
+
+// chunkSender sends what is written to it as parts of a streamed response, once about csvChunkSize bytes are collected.
+type chunkSender struct {
+    stream backend.CallResourceResponseSender
+    buffer bytes.Buffer
+}
+
+func (c *chunkSender) Write(data []byte) (int, error) {
+    c.buffer.Write(data)
+    if c.buffer.Len() >= csvChunkSize {
+        return len(data), c.flush()
+    }
+    return len(data), nil
+}
+
+func (c *chunkSender) flush() error {
+    if c.buffer.Len() == 0 {
+        return nil
+    }
+    body := make([]byte, c.buffer.Len())
+    copy(body, c.buffer.Bytes())
+    c.buffer.Reset()
+    return c.stream.Send(&backend.CallResourceResponse{Body: body})
+}
+
+// exportWindows splits from and to into exportWindow long ranges. Cassandra includes both ends of a range, so each range
+// ends a millisecond before the next one starts.
+func exportWindows(from time.Time, to time.Time) [][2]time.Time {
+    var result [][2]time.Time
+    for start := from; !start.After(to); start = start.Add(exportWindow) {
+        end := start.Add(exportWindow - time.Millisecond)
+        if end.After(to) {
+            end = to
+        }
+        result = append(result, [2]time.Time{start, end})
+    }
+    return result
+}
+
+func parseSensorRefs(paths []string) ([]model.SensorRef, error) {
+    if len(paths) == 0 {
+        return nil, fmt.Errorf("%w: at least one datapoint={project}/{subsystem}/{datapoint} is required", model.ErrBadRequest)
+    }
+    result := make([]model.SensorRef, 0, len(paths))
+    for _, path := range paths {
+        parts := strings.Split(path, "/")
+        if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+            return nil, fmt.Errorf("%w: datapoint \"%s\" is not {project}/{subsystem}/{datapoint}", model.ErrBadRequest, path)
+        }
+        result = append(result, model.SensorRef{Project: parts[0], Subsystem: parts[1], Datapoint: parts[2]})
+    }
+    return result, nil
+}
+
+func parseCsvOptions(orgId int64, projectName string, request *Request, clients *client.Clients) (csvOptions, error) {
+    options := csvOptions{
+        timeFormat: request.Query.Get("timeformat"),
+        delimiter:  ',',
+        decimal:    ".",
+        wide:       request.Query.Get("layout") == "wide",
+        bom:        request.Query.Get("bom") == "true",
+    }
+    switch options.timeFormat {
+    case "":
+        options.timeFormat = "rfc3339"
+    case "rfc3339", "iso8601", "excel", "epochMillis", "epochSeconds":
+    default:
+        return options, fmt.Errorf("%w: unknown timeformat \"%s\"", model.ErrBadRequest, options.timeFormat)
+    }
+    switch request.Query.Get("delimiter") {
+    case "", ",":
+    case ";":
+        options.delimiter = ';'
+    case "tab":
+        options.delimiter = '\t'
+    default:
+        return options, fmt.Errorf("%w: delimiter must be \",\", \";\" or \"tab\"", model.ErrBadRequest)
+    }
+    switch request.Query.Get("decimal") {
+    case "", ".":
+    case ",":
+        options.decimal = ","
+    default:
+        return options, fmt.Errorf("%w: decimal must be \".\" or \",\"", model.ErrBadRequest)
+    }
+    if layout := request.Query.Get("layout"); layout != "" && layout != "long" && layout != "wide" {
+        return options, fmt.Errorf("%w: layout must be long or wide", model.ErrBadRequest)
+    }
+    timezone := request.Query.Get("tz")
+    if timezone == "" {
+        project, err := clients.Cassandra.GetProject(orgId, projectName)
+        if err != nil {
+            return options, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        timezone = project.Timezone
+    }
+    if timezone == "" {
+        timezone = "UTC"
+    }
+    location, err := time.LoadLocation(timezone)
+    if err != nil {
+        return options, fmt.Errorf("%w: unknown timezone \"%s\"", model.ErrBadRequest, timezone)
+    }
+    options.location = location
+    return options, nil
+}
+
+func (o *csvOptions) formatTime(ts time.Time) string {
+    switch o.timeFormat {
+    case "epochMillis":
+        return strconv.FormatInt(ts.UnixMilli(), 10)
+    case "epochSeconds":
+        return strconv.FormatInt(ts.Unix(), 10)
+    }
+    return ts.In(o.location).Format(csvTimeFormats[o.timeFormat])
+}
+
+func (o *csvOptions) formatValue(value float64) string {
+    text := strconv.FormatFloat(value, 'f', -1, 64)
+    if o.decimal != "." {
+        text = strings.Replace(text, ".", o.decimal, 1)
+    }
+    return text
+}
+
+func writeLongCsv(writer *csv.Writer, sensors []model.SensorRef, from time.Time, to time.Time, read func(model.SensorRef, time.Time, time.Time) ([]model.TsPair, error), options csvOptions) error {
+    err := writer.Write([]string{"time", "project", "subsystem", "datapoint", "value"})
+    if err != nil {
+        return err
+    }
+    for _, sensor := range sensors {
+        for _, window := range exportWindows(from, to) {
+            samples, err := read(sensor, window[0], window[1])
+            if err != nil {
+                return err
+            }
+            for _, sample := range samples {
+                err = writer.Write([]string{options.formatTime(sample.TS), sensor.Project, sensor.Subsystem, sensor.Datapoint, options.formatValue(sample.Value)})
+                if err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    writer.Flush()
+    return writer.Error()
+}
+
+// writeWideCsv writes one row per distinct timestamp, leaving cells empty where a datapoint has no sample at that time.
+// The rows are merged one exportWindow at a time.
+func writeWideCsv(writer *csv.Writer, sensors []model.SensorRef, from time.Time, to time.Time, read func(model.SensorRef, time.Time, time.Time) ([]model.TsPair, error), options csvOptions) error {
+    header := []string{"time"}
+    for _, sensor := range sensors {
+        header = append(header, sensor.Project+"/"+sensor.Subsystem+"/"+sensor.Datapoint)
+    }
+    err := writer.Write(header)
+    if err != nil {
+        return err
+    }
+    for _, window := range exportWindows(from, to) {
+        rows := map[int64][]string{}
+        for i, sensor := range sensors {
+            samples, err := read(sensor, window[0], window[1])
+            if err != nil {
+                return err
+            }
+            for _, sample := range samples {
+                key := sample.TS.UnixNano()
+                row, found := rows[key]
+                if !found {
+                    row = make([]string, len(sensors)+1)
+                    row[0] = options.formatTime(sample.TS)
+                    rows[key] = row
+                }
+                row[i+1] = options.formatValue(sample.Value)
+            }
+        }
+        keys := make([]int64, 0, len(rows))
+        for key := range rows {
+            keys = append(keys, key)
+        }
+        sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+        for _, key := range keys {
+            err = writer.Write(rows[key])
+            if err != nil {
+                return err
+            }
+        }
+    }
+    writer.Flush()
+    return writer.Error()
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// failingTimeseries is a client.Cassandra that has a sample in every window it is asked for, until it has answered
+// reads times, after which it fails like a Cassandra timeout.
+type failingTimeseries struct {
+    client.Cassandra
+    reads int
+}
+
+func (f *failingTimeseries) ReadTimeseries(_ int64, _ model.SensorRef, from time.Time, _ time.Time) ([]model.TsPair, error) {
+    if f.reads == 0 {
+        return nil, errors.New("gocql: timeout")
+    }
+    f.reads--
+    return []model.TsPair{{TS: from, Value: 21.5}}, nil
+}
+
+type recordedStream struct {
+    responses []*backend.CallResourceResponse
+}
+
+func (r *recordedStream) Send(response *backend.CallResourceResponse) error {
+    r.responses = append(r.responses, response)
+    return nil
+}
+
+func TestExportTimeseriesFailsInsteadOfTruncating(t *testing.T) {
+    stream := &recordedStream{}
+    request := &Request{
+        Query: map[string][]string{
+            "datapoint": {"garden/shed/temperature"},
+            "from":      {"2022-01-01T00:00:00Z"},
+            "to":        {"2022-03-01T00:00:00Z"},
+            "tz":        {"UTC"},
+        },
+        Stream: stream,
+    }
+    _, err := ExportTimeseries(1, nil, nil, request, &client.Clients{Cassandra: &failingTimeseries{reads: 2}})
+    if !errors.Is(err, model.ErrServerError) || !strings.Contains(err.Error(), "garden/shed/temperature") {
+        t.Errorf("expected the failed read to be returned, got %v", err)
+    }
+    if len(stream.responses) == 0 || stream.responses[0].Status != http.StatusOK {
+        t.Fatalf("expected the export to have started")
+    }
+}
+
+func TestImportTimeseriesChecksTimestamps(t *testing.T) {
+    clients := &client.Clients{Cassandra: &savedDatapoints{datapoints: map[string]model.DatapointSettings{
+        "garden/shed/temperature": {Name: "temperature", TimeToLive: model.A},
+    }}}
+    now := time.Now().UTC()
+    body := "time,garden/shed/temperature\n" +
+        now.Add(-time.Hour).Format(time.RFC3339) + ",21.5\n" +
+        now.Add(time.Hour).Format(time.RFC3339) + ",22\n" +
+        now.AddDate(-1, 0, 0).Format(time.RFC3339) + ",19\n" +
+        now.Format(time.RFC3339) + ",NaN\n"
+    request := &Request{Query: map[string][]string{"dryrun": {"true"}, "tz": {"UTC"}, "timeformat": {"rfc3339"}}}
+    response, err := ImportTimeseries(1, nil, []byte(body), request, clients)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var summary model.TimeseriesImportSummary
+    if err := json.Unmarshal(response.Body, &summary); err != nil {
+        t.Fatal(err)
+    }
+    if summary.Samples != 1 || summary.Rejected != 3 || len(summary.Errors) != 3 {
+        t.Fatalf("unexpected summary %+v", summary)
+    }
+    for i, expected := range []string{"in the future", "time to live", "not a number"} {
+        if summary.Errors[i].Row != i+3 || !strings.Contains(summary.Errors[i].Message, expected) {
+            t.Errorf("expected row %d to be rejected as %s, got %+v", i+3, expected, summary.Errors[i])
+        }
+    }
+}
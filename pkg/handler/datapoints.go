@@ -4,7 +4,6 @@ import (
     "encoding/json"
     "fmt"
     "net/http"
-    "strconv"
 
     "github.com/Sensetif/sensetif-datasource/pkg/client"
     "github.com/Sensetif/sensetif-datasource/pkg/model"
@@ -13,54 +12,88 @@ import (
 )
 
 //goland:noinspection GoUnusedParameter
-func ListDatapoints(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func ListDatapoints(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     if len(params) < 3 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
     }
+    options, err := parseListOptions(request, "name", "sourcetype", "interval")
+    if err != nil {
+        return nil, err
+    }
+    if options.title != "" {
+        return nil, fmt.Errorf("%w: datapoints have no title", model.ErrBadRequest)
+    }
+    if options.cassandraPaging() {
+        page, err := clients.Cassandra.FindDatapointsPage(orgId, params[1], params[2], options.limit, options.pageState)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        return listResponse(&options, page.Items, page.Total, pageStateCursorOf(page.PageState))
+    }
     datapoints, err := clients.Cassandra.FindAllDatapoints(orgId, params[1], params[2])
     if err != nil {
         log.DefaultLogger.Error("Unable read datapoint.")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
-    rawJson, err := json.Marshal(datapoints)
-    if err != nil {
-        log.DefaultLogger.Error("Unable to marshal json")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+    result := make([]model.DatapointSettings, 0)
+    for _, datapoint := range datapoints {
+        if options.matches(datapoint.Name, "") && options.matchesSourceType(datapoint.SourceType) {
+            result = append(result, datapoint)
+        }
     }
-    return &backend.CallResourceResponse{
-        Status: http.StatusOK,
-        Body:   rawJson,
-    }, nil
+    options.sort(result, func(i int, field string) string {
+        switch field {
+        case "sourcetype":
+            return string(result[i].SourceType)
+        case "interval":
+            // zero padded, so that the durations sort as strings
+            return fmt.Sprintf("%020d", result[i].Interval.Duration())
+        }
+        return result[i].Name
+    })
+    start, end, next := options.window(len(result))
+    return listResponse(&options, result[start:end], len(result), next)
 }
 
-func GetDatapoint(orgId int64, params []string, _ []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func GetDatapoint(orgId int64, params []string, _ []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     if len(params) < 4 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
     }
     datapoint, err := clients.Cassandra.GetDatapoint(orgId, params[1], params[2], params[3])
     if err != nil {
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if datapoint.Name == "" {
+        return nil, fmt.Errorf("%w: datapoint %s/%s/%s", model.ErrNotFound, params[1], params[2], params[3])
     }
     bytes, err := json.Marshal(datapoint)
     if err != nil {
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
     return &backend.CallResourceResponse{
-        Status:  http.StatusOK,
-        Headers: make(map[string][]string),
-        Body:    bytes,
+        Status: http.StatusOK,
+        Headers: map[string][]string{
+            "ETag": {ETagOf(bytes)},
+        },
+        Body: bytes,
     }, nil
 }
 
-func UpdateDatapoint(orgId int64, _ []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":updateDatapoint"
-    clients.Pulsar.Send(model.ConfigurationTopic, key, body)
-    return &backend.CallResourceResponse{
-        Status: http.StatusAccepted,
-    }, nil
+func UpdateDatapoint(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    var datapoint model.DatapointSettings
+    err := decodeSettings(body, &datapoint)
+    err = checkPath(err, params, pathField{"project", datapoint.Project}, pathField{"subsystem", datapoint.Subsystem}, pathField{"name", datapoint.Name})
+    if err != nil {
+        return nil, err
+    }
+    err = enforcePlanLimits(orgId, datapoint, clients)
+    if err != nil {
+        return nil, err
+    }
+    return SubmitConfiguration(orgId, "updateDatapoint", body, request, clients)
 }
 
-func DeleteDatapoint(orgId int64, params []string, _ []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func DeleteDatapoint(orgId int64, params []string, _ []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     if len(params) < 4 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
     }
@@ -70,22 +103,10 @@ func DeleteDatapoint(orgId int64, params []string, _ []byte, clients *client.Cli
         Subsystem: params[2],
         Datapoint: params[3],
     }
-    bytes, err := json.Marshal(datapoint)
-    if err == nil {
-        key := "2:" + strconv.FormatInt(orgId, 10) + ":deleteDatapoint"
-        clients.Pulsar.Send(model.ConfigurationTopic, key, bytes)
-        return &backend.CallResourceResponse{
-            Status: http.StatusAccepted,
-        }, nil
-    }
-    return nil, err
+    return SubmitConfiguration(orgId, "deleteDatapoint", datapoint, request, clients)
 }
 
 //goland:noinspection GoUnusedParameter
-func RenameDatapoint(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":renameDatapoint"
-    clients.Pulsar.Send(model.ConfigurationTopic, key, body)
-    return &backend.CallResourceResponse{
-        Status: http.StatusAccepted,
-    }, nil
+func RenameDatapoint(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    return SubmitConfiguration(orgId, "renameDatapoint", body, request, clients)
 }
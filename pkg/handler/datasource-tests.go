@@ -0,0 +1,255 @@
+package handler
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// How much of a fetched document or MQTT payload is returned with the test result.
+const testDocumentPreview = 4096
+
+const (
+    defaultMqttTestSeconds  = 10
+    maxMqttTestSeconds      = 30
+    defaultMqttTestMessages = 10
+    maxMqttTestMessages     = 50
+)
+
+// TestWebDatasource fetches the document of the model.WebDatasource in the body and extracts the value and timestamp
+// from it, without saving anything. The outcome is in the model.WebTestResult, also when the test fails. Query
+// parameters;
+//   datapoint  {project}/{subsystem}/{datapoint} of a saved datapoint, whose credentials are used where the body
+//              has the redacted ones of an export
+//goland:noinspection GoUnusedParameter
+func TestWebDatasource(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("TestWebDatasource()")
+    var ds model.WebDatasource
+    err := json.Unmarshal(body, &ds)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    if saved := request.Query.Get("datapoint"); saved != "" {
+        merged, err := withSavedSecrets(orgId, saved, model.Web, ds, clients)
+        if err != nil {
+            return nil, err
+        }
+        ds = merged.(model.WebDatasource)
+    }
+    err = ds.Validate()
+    if err != nil {
+        return nil, err
+    }
+
+    result := model.WebTestResult{}
+    document, err := clients.Web.Fetch(request.Context(), ds)
+    result.Status = document.Status
+    result.ContentType = document.ContentType
+    result.Size = len(document.Body)
+    result.DurationMs = document.Duration.Milliseconds()
+    result.Document = previewOf(document.Body)
+    switch {
+    case err != nil:
+        result.Errors = []model.TestError{{Stage: model.StageFetch, Field: "url", Message: err.Error()}}
+    case document.Status < 200 || document.Status > 299:
+        result.Errors = []model.TestError{{Stage: model.StageFetch, Field: "url", Message: fmt.Sprintf("the server responded %d %s", document.Status, http.StatusText(document.Status))}}
+        if document.Status == http.StatusUnauthorized || document.Status == http.StatusForbidden {
+            result.Errors[0].Field = "auth"
+        }
+    case document.Truncated:
+        result.Errors = []model.TestError{{Stage: model.StageFetch, Field: "url", Message: fmt.Sprintf("the document is larger than %d bytes", len(document.Body))}}
+    default:
+        result.Extraction = extract(ds.Format, ds.ValueExpression, ds.TimestampType, ds.TimestampExpression, document.Body, document.Received)
+    }
+    result.Ok = len(result.Errors) == 0
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// TestExpressions tries the expressions of the model.ExpressionTest in the body on its sample document, the way
+// TestWebDatasource and TestMqttDatasource do on the documents they receive. Syntax errors are reported with their
+// position, and the paths to all values in the document are returned, to pick the expressions from.
+//goland:noinspection GoUnusedParameter
+func TestExpressions(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("TestExpressions()")
+    var test model.ExpressionTest
+    err := json.Unmarshal(body, &test)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    if test.TimestampType == "" {
+        test.TimestampType = model.PollTime
+    }
+    err = test.Validate()
+    if err != nil {
+        return nil, err
+    }
+
+    data := []byte(test.Document)
+    result := model.ExpressionTestResult{Candidates: make([]model.PathCandidate, 0)}
+    result.Extraction = extract(test.Format, test.ValueExpression, test.TimestampType, test.TimestampExpression, data, time.Now())
+    if document, err := test.Format.Parse(data); err == nil {
+        for _, candidate := range test.Format.Candidates(document) {
+            result.Candidates = append(result.Candidates, model.PathCandidate{Path: candidate.Path, Value: candidate.Value})
+        }
+    }
+    result.Ok = len(result.Errors) == 0
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// TestMqttDatasource subscribes to the topic of the model.MqttDatasource in the body for a while, and extracts the
+// value and timestamp from each message that arrives. The outcome is in the model.MqttTestResult, also when the test
+// fails. Query parameters;
+//   duration   seconds to wait for messages, default 10 and at most 30
+//   max        stop after this many messages, default 10 and at most 50
+//   datapoint  {project}/{subsystem}/{datapoint} of a saved datapoint, whose credentials are used where the body
+//              has the redacted ones of an export
+//goland:noinspection GoUnusedParameter
+func TestMqttDatasource(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("TestMqttDatasource()")
+    var ds model.MqttDatasource
+    err := json.Unmarshal(body, &ds)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    duration, err := boundedQueryInt(request, "duration", defaultMqttTestSeconds, maxMqttTestSeconds)
+    if err != nil {
+        return nil, err
+    }
+    max, err := boundedQueryInt(request, "max", defaultMqttTestMessages, maxMqttTestMessages)
+    if err != nil {
+        return nil, err
+    }
+    if saved := request.Query.Get("datapoint"); saved != "" {
+        merged, err := withSavedSecrets(orgId, saved, model.Mqtt, ds, clients)
+        if err != nil {
+            return nil, err
+        }
+        ds = merged.(model.MqttDatasource)
+    }
+    err = ds.Validate()
+    if err != nil {
+        return nil, err
+    }
+    if !client.CanListen(ds.Protocol) {
+        errs := &model.ValidationError{}
+        errs.Add("protocol", "the test can't connect with \"%s\"", ds.Protocol)
+        return nil, errs.Err()
+    }
+
+    start := time.Now()
+    session, err := clients.Mqtt.Listen(request.Context(), ds, time.Duration(duration)*time.Second, max)
+    result := model.MqttTestResult{
+        Connected:  session.Connected,
+        Subscribed: session.Subscribed,
+        DurationMs: time.Since(start).Milliseconds(),
+        Messages:   make([]model.MqttTestMessage, 0, len(session.Messages)),
+        Errors:     make([]model.TestError, 0),
+    }
+    if err != nil {
+        result.Errors = append(result.Errors, mqttTestErrorOf(session, err))
+    }
+    for _, message := range session.Messages {
+        result.Messages = append(result.Messages, model.MqttTestMessage{
+            Topic:      message.Topic,
+            Payload:    previewOf(message.Payload),
+            Size:       len(message.Payload),
+            Retained:   message.Retained,
+            Received:   message.Received,
+            Extraction: extract(ds.Format, ds.ValueExpression, ds.TimestampType, ds.TimestampExpression, message.Payload, message.Received),
+        })
+    }
+    if err == nil && len(session.Messages) == 0 {
+        result.Errors = append(result.Errors, model.TestError{Stage: model.StageReceive, Field: "topic", Message: fmt.Sprintf("no message was published on \"%s\" within %d seconds, and there is no retained message", ds.Topic, duration)})
+    }
+    result.Ok = len(result.Errors) == 0
+    for _, message := range result.Messages {
+        result.Ok = result.Ok && len(message.Errors) == 0
+    }
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// mqttTestErrorOf tells which stage of the session failed, and which setting is the likely cause.
+func mqttTestErrorOf(session client.MqttSession, err error) model.TestError {
+    var refused *client.MqttRefusedError
+    switch {
+    case !session.Connected && errors.As(err, &refused) && (refused.Code == 4 || refused.Code == 5):
+        return model.TestError{Stage: model.StageConnect, Field: "username", Message: err.Error()}
+    case !session.Connected:
+        return model.TestError{Stage: model.StageConnect, Field: "address", Message: err.Error()}
+    case !session.Subscribed:
+        return model.TestError{Stage: model.StageSubscribe, Field: "topic", Message: err.Error()}
+    default:
+        return model.TestError{Stage: model.StageReceive, Message: err.Error()}
+    }
+}
+
+func boundedQueryInt(request *Request, name string, defaultValue int, max int) (int, error) {
+    text := request.Query.Get(name)
+    if text == "" {
+        return defaultValue, nil
+    }
+    value, err := strconv.Atoi(text)
+    if err != nil || value < 1 || value > max {
+        return 0, fmt.Errorf("%w: %s must be between 1 and %d", model.ErrBadRequest, name, max)
+    }
+    return value, nil
+}
+
+// withSavedSecrets replaces the redacted credentials of the datasource with those of the saved datapoint.
+func withSavedSecrets(orgId int64, path string, sourceType model.SourceType, ds interface{}, clients *client.Clients) (interface{}, error) {
+    names := strings.Split(path, "/")
+    if len(names) != 3 {
+        return ds, fmt.Errorf("%w: datapoint must be {project}/{subsystem}/{datapoint}", model.ErrBadRequest)
+    }
+    saved, err := clients.Cassandra.GetDatapoint(orgId, names[0], names[1], names[2])
+    if err != nil {
+        return ds, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if saved.Name == "" {
+        return ds, fmt.Errorf("%w: datapoint %s", model.ErrNotFound, path)
+    }
+    return model.DatapointSettings{SourceType: sourceType, Datasource: ds}.WithSecretsFrom(saved).Datasource, nil
+}
+
+// previewOf returns the start of the document as text, cut at a whole character.
+func previewOf(document []byte) string {
+    if len(document) <= testDocumentPreview {
+        return string(document)
+    }
+    end := testDocumentPreview
+    for end > 0 && !utf8.RuneStart(document[end]) {
+        end--
+    }
+    return string(document[:end]) + "…"
+}
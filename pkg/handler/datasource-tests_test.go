@@ -0,0 +1,182 @@
+package handler
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "testing"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// savedDatapoints is a client.Cassandra that only knows GetDatapoint, the other methods panic.
+type savedDatapoints struct {
+    client.Cassandra
+    datapoints map[string]model.DatapointSettings
+}
+
+func (s *savedDatapoints) GetDatapoint(_ int64, projectName string, subsystemName string, datapointName string) (model.DatapointSettings, error) {
+    return s.datapoints[projectName+"/"+subsystemName+"/"+datapointName], nil
+}
+
+func testWebDatasource(t *testing.T, clients *client.Clients, ds model.WebDatasource, query url.Values) model.WebTestResult {
+    t.Helper()
+    body, _ := json.Marshal(ds)
+    response, err := TestWebDatasource(1, nil, body, &Request{Query: query}, clients)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if response.Status != http.StatusOK {
+        t.Fatalf("status %d", response.Status)
+    }
+    var result model.WebTestResult
+    if err := json.Unmarshal(response.Body, &result); err != nil {
+        t.Fatal(err)
+    }
+    return result
+}
+
+func TestTestWebDatasource(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/weather.json":
+            w.Header().Set("Content-Type", "application/json")
+            _, _ = w.Write([]byte(`{"temperature": 21.5, "time": "2022-03-01T10:15:30Z"}`))
+        case "/private.json":
+            if r.Header.Get("Authorization") != "Bearer saved-token" {
+                http.Error(w, "who are you", http.StatusUnauthorized)
+                return
+            }
+            _, _ = w.Write([]byte(`{"temperature": 19}`))
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+    clients := &client.Clients{
+        Web: &client.WebClient{Http: server.Client()},
+        Cassandra: &savedDatapoints{datapoints: map[string]model.DatapointSettings{
+            "garden/shed/temperature": {
+                Name:       "temperature",
+                SourceType: model.Web,
+                Datasource: model.WebDatasource{URL: server.URL + "/private.json", AuthenticationType: model.BearerToken, Auth: "saved-token"},
+            },
+        }},
+    }
+
+    t.Run("extracts the value and timestamp", func(t *testing.T) {
+        result := testWebDatasource(t, clients, model.WebDatasource{
+            URL:                 server.URL + "/weather.json",
+            Format:              model.JSON,
+            ValueExpression:     "$.temperature",
+            TimestampType:       model.ISO8601_offset,
+            TimestampExpression: "$.time",
+        }, nil)
+        if !result.Ok || result.Status != http.StatusOK || result.Value == nil || *result.Value != 21.5 || result.Timestamp == nil {
+            t.Errorf("unexpected result %+v", result)
+        }
+        if result.ContentType != "application/json" || result.Document == "" {
+            t.Errorf("the document is missing from %+v", result)
+        }
+    })
+
+    t.Run("reports error statuses on the url", func(t *testing.T) {
+        result := testWebDatasource(t, clients, model.WebDatasource{
+            URL: server.URL + "/missing.json", Format: model.JSON, ValueExpression: "$.temperature", TimestampType: model.PollTime,
+        }, nil)
+        if result.Ok || len(result.Errors) != 1 || result.Errors[0].Stage != model.StageFetch || result.Errors[0].Field != "url" {
+            t.Errorf("unexpected result %+v", result)
+        }
+    })
+
+    t.Run("reports 401 on the auth", func(t *testing.T) {
+        result := testWebDatasource(t, clients, model.WebDatasource{
+            URL: server.URL + "/private.json", Format: model.JSON, ValueExpression: "$.temperature", TimestampType: model.PollTime,
+        }, nil)
+        if result.Ok || result.Status != http.StatusUnauthorized || len(result.Errors) != 1 || result.Errors[0].Field != "auth" {
+            t.Errorf("unexpected result %+v", result)
+        }
+    })
+
+    t.Run("uses the saved credentials for redacted ones", func(t *testing.T) {
+        result := testWebDatasource(t, clients, model.WebDatasource{
+            URL: server.URL + "/private.json", AuthenticationType: model.BearerToken, Auth: model.RedactedSecret,
+            Format: model.JSON, ValueExpression: "$.temperature", TimestampType: model.PollTime,
+        }, url.Values{"datapoint": {"garden/shed/temperature"}})
+        if !result.Ok || result.Value == nil || *result.Value != 19 {
+            t.Errorf("unexpected result %+v", result)
+        }
+    })
+
+    t.Run("reports failed connections", func(t *testing.T) {
+        result := testWebDatasource(t, clients, model.WebDatasource{
+            URL: "http://127.0.0.1:1/weather.json", Format: model.JSON, ValueExpression: "$.temperature", TimestampType: model.PollTime,
+        }, nil)
+        if result.Ok || len(result.Errors) != 1 || result.Errors[0].Stage != model.StageFetch {
+            t.Errorf("unexpected result %+v", result)
+        }
+    })
+}
+
+func TestTestWebDatasourceValidates(t *testing.T) {
+    body, _ := json.Marshal(model.WebDatasource{URL: "ftp://example.com", Format: model.JSON, ValueExpression: "$.x", TimestampType: model.PollTime})
+    _, err := TestWebDatasource(1, nil, body, &Request{}, &client.Clients{})
+    if _, isValidation := err.(*model.ValidationError); !isValidation {
+        t.Errorf("expected a validation error, got %v", err)
+    }
+}
+
+func TestTestMqttDatasourceRefusesProtocolsItCantConnectWith(t *testing.T) {
+    body, _ := json.Marshal(model.MqttDatasource{Protocol: "alis", Address: "example.com", Port: 1883, Topic: "garden/#", Format: model.JSON, ValueExpression: "$.x", TimestampType: model.PollTime})
+    _, err := TestMqttDatasource(1, nil, body, &Request{}, &client.Clients{})
+    validation, isValidation := err.(*model.ValidationError)
+    if !isValidation || len(validation.Errors) != 1 || validation.Errors[0].Field != "protocol" {
+        t.Errorf("expected a validation error of the protocol, got %v", err)
+    }
+}
+
+func TestTestWebDatasourceUsesTheContextOfTheRequest(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        <-r.Context().Done()
+    }))
+    defer server.Close()
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    body, _ := json.Marshal(model.WebDatasource{URL: server.URL, Format: model.JSON, ValueExpression: "$.x", TimestampType: model.PollTime})
+    response, err := TestWebDatasource(1, nil, body, &Request{Ctx: ctx}, &client.Clients{Web: &client.WebClient{Http: server.Client()}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var result model.WebTestResult
+    _ = json.Unmarshal(response.Body, &result)
+    if result.Ok || len(result.Errors) != 1 || result.Errors[0].Stage != model.StageFetch {
+        t.Errorf("expected the fetch to be cancelled, got %+v", result)
+    }
+}
+
+func TestTestExpressionsWarnsAboutUnsupportedExpressions(t *testing.T) {
+    body, _ := json.Marshal(model.ExpressionTest{
+        Format:          model.JSON,
+        Document:        `{"readings": [{"id": 1, "value": 10}, {"id": 2, "value": 20}]}`,
+        ValueExpression: "$.readings[?(@.id == 2)].value",
+        TimestampType:   model.PollTime,
+    })
+    response, err := TestExpressions(1, nil, body, &Request{}, &client.Clients{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var result model.ExpressionTestResult
+    if err := json.Unmarshal(response.Body, &result); err != nil {
+        t.Fatal(err)
+    }
+    if !result.Ok || len(result.Warnings) != 1 || result.Warnings[0].Field != "valueExpression" || *result.Warnings[0].Position != 11 {
+        t.Errorf("expected a warning on the filter, got %+v", result)
+    }
+    ds := model.WebDatasource{URL: "https://example.com", Format: model.JSON, ValueExpression: "$.readings[?(@.id == 2)].value", TimestampType: model.PollTime}
+    if err := ds.Validate(); err != nil {
+        t.Errorf("the expression must be accepted when saved: %v", err)
+    }
+}
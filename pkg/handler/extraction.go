@@ -0,0 +1,110 @@
+package handler
+
+import (
+    "errors"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/docpath"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// maxSuggestions is the number of paths suggested when an expression doesn't select a value.
+const maxSuggestions = 5
+
+// extract picks the value and the timestamp out of a document received by a web or MQTT datasource, the way the
+// backend does when it polls or subscribes. Every problem is reported, so that the value expression can be fixed
+// even if the timestamp expression is broken too.
+func extract(format model.OriginDocumentFormat, valueExpr string, tsType model.TimestampType, tsExpr string, data []byte, received time.Time) model.Extraction {
+    result := model.Extraction{Errors: make([]model.TestError, 0)}
+    document, err := format.Parse(data)
+    if err != nil {
+        result.Errors = append(result.Errors, model.TestError{Stage: model.StageParse, Field: "format", Message: err.Error()})
+        return result
+    }
+    var candidates []docpath.Candidate
+
+    raw, err := selectSingle(format, valueExpr, document)
+    if isUnsupported(err) {
+        result.Warnings = append(result.Warnings, testErrorOf(model.StageValue, "valueExpression", err))
+    } else if err == nil {
+        result.RawValue = raw
+        var value float64
+        value, err = docpath.Number(raw)
+        if err == nil {
+            result.Value = &value
+        }
+    }
+    if err != nil && !isUnsupported(err) {
+        candidates = format.Candidates(document)
+        testError := testErrorOf(model.StageValue, "valueExpression", err)
+        testError.Suggestions = suggestionsOf(valueExpr, candidates)
+        result.Errors = append(result.Errors, testError)
+    }
+
+    text := ""
+    if tsType != model.PollTime {
+        raw, err = selectSingle(format, tsExpr, document)
+        if isUnsupported(err) {
+            result.Warnings = append(result.Warnings, testErrorOf(model.StageTimestamp, "timestampExpression", err))
+            return result
+        }
+        if err == nil {
+            text, err = docpath.Text(raw)
+        }
+        if err != nil {
+            if candidates == nil {
+                candidates = format.Candidates(document)
+            }
+            testError := testErrorOf(model.StageTimestamp, "timestampExpression", err)
+            testError.Suggestions = suggestionsOf(tsExpr, candidates)
+            result.Errors = append(result.Errors, testError)
+            return result
+        }
+        result.RawTimestamp = text
+    }
+    ts, err := tsType.Parse(text, received)
+    if err != nil {
+        result.Errors = append(result.Errors, model.TestError{Stage: model.StageTimestamp, Field: "timestampType", Message: err.Error()})
+    } else {
+        result.Timestamp = &ts
+    }
+    return result
+}
+
+func selectSingle(format model.OriginDocumentFormat, expression string, document interface{}) (interface{}, error) {
+    path, err := format.Compile(expression)
+    if err != nil {
+        return nil, err
+    }
+    return docpath.Single(path, document)
+}
+
+// suggestionsOf returns the paths in the document that are most like an expression that didn't select a value.
+func suggestionsOf(expression string, candidates []docpath.Candidate) []string {
+    suggestions := make([]string, 0, maxSuggestions)
+    for _, candidate := range docpath.Closest(expression, candidates, maxSuggestions) {
+        suggestions = append(suggestions, candidate.Path)
+    }
+    return suggestions
+}
+
+func isUnsupported(err error) bool {
+    var unsupported *docpath.UnsupportedError
+    return errors.As(err, &unsupported)
+}
+
+// testErrorOf keeps the position of syntax errors and unsupported parts, so that the UI can point at it.
+func testErrorOf(stage string, field string, err error) model.TestError {
+    testError := model.TestError{Stage: stage, Field: field, Message: err.Error()}
+    var syntaxErr *docpath.SyntaxError
+    var unsupported *docpath.UnsupportedError
+    switch {
+    case errors.As(err, &syntaxErr):
+        position := syntaxErr.Position
+        testError.Position = &position
+    case errors.As(err, &unsupported):
+        position := unsupported.Position
+        testError.Position = &position
+    }
+    return testError
+}
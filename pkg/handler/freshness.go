@@ -0,0 +1,111 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+//goland:noinspection GoUnusedParameter
+func FreshnessReport(orgId int64, params []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("FreshnessReport()")
+    project := ""
+    subsystem := ""
+    if len(params) > 1 {
+        project = params[1]
+    }
+    if len(params) > 2 {
+        subsystem = params[2]
+    }
+    now := time.Now()
+    datapoints, err := EvaluateFreshness(clients.Cassandra, orgId, project, subsystem, now)
+    if err != nil {
+        log.DefaultLogger.Error("Unable to evaluate freshness.")
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    rawJson, err := json.Marshal(model.NewFreshnessReport(now, datapoints))
+    if err != nil {
+        log.DefaultLogger.Error("Unable to marshal json")
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// EvaluateFreshness compares the most recent sample of each datapoint with its PollInterval. An empty project evaluates
+// all projects of the organization, and an empty subsystem evaluates all subsystems of the project. Samples older than
+// model.StaleFactor poll intervals are not searched for, and such datapoints are reported as stale without LastSample.
+// Only the time of the latest sample is used, so its value is not scaled.
+func EvaluateFreshness(cassandra client.Cassandra, orgId int64, project string, subsystem string, now time.Time) ([]model.DatapointFreshness, error) {
+    result := make([]model.DatapointFreshness, 0)
+    projects := []string{project}
+    if project == "" {
+        all, err := cassandra.FindAllProjects(orgId)
+        if err != nil {
+            return nil, err
+        }
+        projects = projects[:0]
+        for _, p := range all {
+            projects = append(projects, p.Name)
+        }
+    }
+    for _, projectName := range projects {
+        subsystems := []string{subsystem}
+        if subsystem == "" {
+            all, err := cassandra.FindAllSubsystems(orgId, projectName)
+            if err != nil {
+                return nil, err
+            }
+            subsystems = subsystems[:0]
+            for _, s := range all {
+                subsystems = append(subsystems, s.Name)
+            }
+        }
+        for _, subsystemName := range subsystems {
+            datapoints, err := cassandra.FindAllDatapoints(orgId, projectName, subsystemName)
+            if err != nil {
+                return nil, err
+            }
+            for _, dp := range datapoints {
+                freshness, err := evaluateDatapoint(cassandra, orgId, dp, now)
+                if err != nil {
+                    return nil, err
+                }
+                result = append(result, freshness)
+            }
+        }
+    }
+    return result, nil
+}
+
+func evaluateDatapoint(cassandra client.Cassandra, orgId int64, dp model.DatapointSettings, now time.Time) (model.DatapointFreshness, error) {
+    result := model.DatapointFreshness{
+        Project:   dp.Project,
+        Subsystem: dp.Subsystem,
+        Name:      dp.Name,
+        Interval:  dp.Interval,
+        Age:       -1,
+        Status:    model.Stale,
+    }
+    since := now.Add(-model.StaleThreshold(dp.Interval))
+    sensor := model.SensorRef{Project: dp.Project, Subsystem: dp.Subsystem, Datapoint: dp.Name}
+    latest, err := cassandra.QueryLatestValue(orgId, sensor, since)
+    if err != nil {
+        return result, err
+    }
+    if latest != nil {
+        age := now.Sub(latest.TS)
+        result.LastSample = &latest.TS
+        result.Age = int64(age.Seconds())
+        result.Status = model.FreshnessOf(dp.Interval, age)
+    }
+    return result, nil
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+//goland:noinspection GoUnusedParameter
+func CurrentUsage(orgId int64, params []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("CurrentUsage()")
+    limits, err := clients.Cassandra.GetCurrentLimits(orgId)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    datapoints, err := findAllDatapointsInOrg(clients.Cassandra, orgId)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    usage := model.PlanUsage{
+        Limits:     limits,
+        Datapoints: uint64(len(datapoints)),
+        Violations: make([]model.FieldError, 0),
+    }
+    if usage.Datapoints > limits.MaxDatapoints {
+        usage.Violations = append(usage.Violations, model.FieldError{
+            Field:   "datapoints",
+            Message: fmt.Sprintf("%d datapoints exceeds the maximum of %d", usage.Datapoints, limits.MaxDatapoints),
+        })
+    }
+    for _, dp := range datapoints {
+        if usage.FastestPollInterval == "" || dp.Interval.Duration() < usage.FastestPollInterval.Duration() {
+            usage.FastestPollInterval = dp.Interval
+        }
+        if usage.LongestTimeToLive == "" || dp.TimeToLive.Exceeds(usage.LongestTimeToLive) {
+            usage.LongestTimeToLive = dp.TimeToLive
+        }
+        errs := &model.ValidationError{}
+        checkDatapointLimits(errs, limits, dp)
+        for _, e := range errs.Errors {
+            usage.Violations = append(usage.Violations, model.FieldError{
+                Field:   dp.Project + "/" + dp.Subsystem + "/" + dp.Name + "." + e.Field,
+                Message: e.Message,
+            })
+        }
+    }
+    rawJson, err := json.Marshal(usage)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// enforcePlanLimits rejects a created or updated datapoint that goes beyond the plan of the organization.
+func enforcePlanLimits(orgId int64, datapoint model.DatapointSettings, clients *client.Clients) error {
+    limits, err := clients.Cassandra.GetCurrentLimits(orgId)
+    if err != nil {
+        return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    errs := &model.ValidationError{Cause: model.ErrPlanLimitExceeded}
+    checkDatapointLimits(errs, limits, datapoint)
+
+    existing, err := clients.Cassandra.GetDatapoint(orgId, datapoint.Project, datapoint.Subsystem, datapoint.Name)
+    if err != nil {
+        return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if existing.Name == "" {
+        count, err := countDatapointsInOrg(clients.Cassandra, orgId)
+        if err != nil {
+            return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        if count+1 > limits.MaxDatapoints {
+            errs.Add("name", "the plan allows at most %d datapoints", limits.MaxDatapoints)
+        }
+    }
+    return errs.Err()
+}
+
+// checkImportLimits rejects the imports for organizations that are already at the maximum number of datapoints. The
+// datapoints of an import are created by the backend from the payload, so they can't be checked one by one here.
+func checkImportLimits(orgId int64, clients *client.Clients) error {
+    limits, err := clients.Cassandra.GetCurrentLimits(orgId)
+    if err != nil {
+        return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    count, err := countDatapointsInOrg(clients.Cassandra, orgId)
+    if err != nil {
+        return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    errs := &model.ValidationError{Cause: model.ErrPlanLimitExceeded}
+    if count >= limits.MaxDatapoints {
+        errs.Add("datapoints", "the plan allows at most %d datapoints, which are all in use", limits.MaxDatapoints)
+    }
+    return errs.Err()
+}
+
+func checkDatapointLimits(errs *model.ValidationError, limits model.PlanLimits, datapoint model.DatapointSettings) {
+    minInterval := model.PollInterval(limits.MinPollInterval)
+    if minInterval.Duration() > 0 && datapoint.Interval.Duration() < minInterval.Duration() {
+        errs.Add("pollinterval", "%s is faster than the plan allows, which is %s", datapoint.Interval, minInterval)
+    }
+    maxStorage := model.TimeToLive(limits.MaxStorage)
+    if datapoint.TimeToLive.Exceeds(maxStorage) {
+        errs.Add("timeToLive", "%s is longer than the plan allows, which is %s", datapoint.TimeToLive, maxStorage)
+    }
+}
+
+// countDatapointsInOrg counts the datapoints of the organization with a single query, for the checks that don't need
+// their settings.
+func countDatapointsInOrg(cassandra client.Cassandra, orgId int64) (uint64, error) {
+    counts, err := cassandra.CountDatapointsPerProject(orgId)
+    if err != nil {
+        return 0, err
+    }
+    var total uint64
+    for _, count := range counts {
+        total += uint64(count)
+    }
+    return total, nil
+}
+
+// findAllDatapointsInOrg reads the settings of every datapoint of the organization, a query per subsystem, so it is
+// only for CurrentUsage, which checks the poll interval and time to live of each. Use countDatapointsInOrg to count.
+func findAllDatapointsInOrg(cassandra client.Cassandra, orgId int64) ([]model.DatapointSettings, error) {
+    result := make([]model.DatapointSettings, 0)
+    projects, err := cassandra.FindAllProjects(orgId)
+    if err != nil {
+        return nil, err
+    }
+    for _, project := range projects {
+        subsystems, err := cassandra.FindAllSubsystems(orgId, project.Name)
+        if err != nil {
+            return nil, err
+        }
+        for _, subsystem := range subsystems {
+            datapoints, err := cassandra.FindAllDatapoints(orgId, project.Name, subsystem.Name)
+            if err != nil {
+                return nil, err
+            }
+            result = append(result, datapoints...)
+        }
+    }
+    return result, nil
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const defaultListLimit = 100
+
+const maxListLimit = 1000
+
+// Cursors are opaque to the client, but are either the paging state of Cassandra, or an offset into the filtered and
+// sorted list.
+const (
+    pageStateCursor = 'p'
+    offsetCursor    = 'o'
+)
+
+const totalCountHeader = "X-Total-Count"
+
+// listOptions are the query parameters of the list endpoints;
+//   limit       page size, up to 1000. With limit or cursor the response is a model.Page instead of an array.
+//   cursor      the next cursor of the previous page
+//   name, title case-insensitive substring of the name or title
+//   sourcetype  datasource type of datapoints, repeated for more than one
+//   sort        field to sort on, prefixed with "-" for descending order
+// Without filters and sorting, pages are read straight from Cassandra. Otherwise the whole list is read, filtered and
+// sorted before the page is cut out of it.
+type listOptions struct {
+    paged       bool
+    limit       int
+    offset      int
+    pageState   []byte
+    name        string
+    title       string
+    sourceTypes map[model.SourceType]bool
+    sortField   string
+    descending  bool
+}
+
+func parseListOptions(request *Request, sortFields ...string) (listOptions, error) {
+    options := listOptions{
+        limit: defaultListLimit,
+        name:  strings.ToLower(request.Query.Get("name")),
+        title: strings.ToLower(request.Query.Get("title")),
+    }
+    var err error
+    if limit := request.Query.Get("limit"); limit != "" {
+        options.paged = true
+        options.limit, err = strconv.Atoi(limit)
+        if err != nil || options.limit < 1 || options.limit > maxListLimit {
+            return options, fmt.Errorf("%w: limit must be between 1 and %d", model.ErrBadRequest, maxListLimit)
+        }
+    }
+    if cursor := request.Query.Get("cursor"); cursor != "" {
+        options.paged = true
+        err = options.parseCursor(cursor)
+        if err != nil {
+            return options, err
+        }
+    }
+    for _, sourceType := range request.Query["sourcetype"] {
+        switch model.SourceType(sourceType) {
+        case model.Web, model.Ttnv3, model.Mqtt:
+        default:
+            return options, fmt.Errorf("%w: unknown sourcetype \"%s\"", model.ErrBadRequest, sourceType)
+        }
+        if options.sourceTypes == nil {
+            options.sourceTypes = map[model.SourceType]bool{}
+        }
+        options.sourceTypes[model.SourceType(sourceType)] = true
+    }
+    if field := request.Query.Get("sort"); field != "" {
+        options.descending = strings.HasPrefix(field, "-")
+        options.sortField = strings.TrimPrefix(field, "-")
+        if indexOf(sortFields, options.sortField) < 0 {
+            return options, fmt.Errorf("%w: sort must be one of %s, optionally prefixed with -", model.ErrBadRequest, strings.Join(sortFields, ", "))
+        }
+    }
+    if options.pageState != nil && options.filtered() {
+        return options, fmt.Errorf("%w: the cursor is from a list without filters or sorting", model.ErrBadRequest)
+    }
+    return options, nil
+}
+
+func (o *listOptions) parseCursor(cursor string) error {
+    data, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err == nil && len(data) > 1 {
+        switch data[0] {
+        case pageStateCursor:
+            o.pageState = data[1:]
+            return nil
+        case offsetCursor:
+            o.offset, err = strconv.Atoi(string(data[1:]))
+            if err == nil && o.offset >= 0 {
+                return nil
+            }
+        }
+    }
+    return fmt.Errorf("%w: invalid cursor \"%s\"", model.ErrBadRequest, cursor)
+}
+
+func (o *listOptions) filtered() bool {
+    return o.name != "" || o.title != "" || len(o.sourceTypes) > 0 || o.sortField != ""
+}
+
+// cassandraPaging tells whether the page can be read from Cassandra as is.
+func (o *listOptions) cassandraPaging() bool {
+    return o.paged && o.offset == 0 && !o.filtered()
+}
+
+func (o *listOptions) matches(name string, title string) bool {
+    return strings.Contains(strings.ToLower(name), o.name) && strings.Contains(strings.ToLower(title), o.title)
+}
+
+func (o *listOptions) matchesSourceType(sourceType model.SourceType) bool {
+    return len(o.sourceTypes) == 0 || o.sourceTypes[sourceType]
+}
+
+// sort orders the list by the sort field, with keyOf returning the value of a field of the i:th item.
+func (o *listOptions) sort(list interface{}, keyOf func(i int, field string) string) {
+    if o.sortField == "" {
+        return
+    }
+    sort.SliceStable(list, func(i, j int) bool {
+        if o.descending {
+            return keyOf(j, o.sortField) < keyOf(i, o.sortField)
+        }
+        return keyOf(i, o.sortField) < keyOf(j, o.sortField)
+    })
+}
+
+// window returns the bounds of the page within a list of the given size, and the cursor of the next page.
+func (o *listOptions) window(size int) (int, int, string) {
+    if !o.paged {
+        return 0, size, ""
+    }
+    start := o.offset
+    if start > size {
+        start = size
+    }
+    end := start + o.limit
+    if end >= size {
+        return start, size, ""
+    }
+    return start, end, base64.RawURLEncoding.EncodeToString([]byte(string(offsetCursor) + strconv.Itoa(end)))
+}
+
+func pageStateCursorOf(pageState []byte) string {
+    if len(pageState) == 0 {
+        return ""
+    }
+    return base64.RawURLEncoding.EncodeToString(append([]byte{pageStateCursor}, pageState...))
+}
+
+// listResponse returns a model.Page if a page was asked for, and otherwise the items as an array, like before paging
+// existed. The total count is in the X-Total-Count header in both cases.
+func listResponse(options *listOptions, items interface{}, total int, next string) (*backend.CallResourceResponse, error) {
+    var body interface{} = items
+    if options.paged {
+        body = model.Page{Items: items, Total: total, Next: next}
+    }
+    rawJson, err := json.Marshal(body)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Headers: map[string][]string{
+            totalCountHeader: {strconv.Itoa(total)},
+        },
+        Body: rawJson,
+    }, nil
+}
@@ -12,17 +12,17 @@ import (
 )
 
 //goland:noinspection GoUnusedParameter
-func GetOrganization(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func GetOrganization(orgId int64, params []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("GetOrganization")
     organization, err := clients.Cassandra.GetOrganization(orgId)
     if err != nil {
         log.DefaultLogger.Error("Unable to read organization")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
     rawJson, err := json.Marshal(organization)
     if err != nil {
         log.DefaultLogger.Error("Unable to marshal json")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
     return &backend.CallResourceResponse{
         Status:  http.StatusOK,
@@ -34,7 +34,7 @@ type SessionProxy struct {
 }
 
 //goland:noinspection GoUnusedParameter
-func CurrentLimits(orgId int64, parameters []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func CurrentLimits(orgId int64, parameters []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     limits, _ := clients.Cassandra.GetCurrentLimits(orgId)
     limitsInJson, err := json.Marshal(limits)
     if err != nil {
@@ -52,7 +52,7 @@ func CurrentLimits(orgId int64, parameters []string, body []byte, clients *clien
 }
 
 //goland:noinspection GoUnusedParameter
-func ListPlans(orgId int64, parameters []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func ListPlans(orgId int64, parameters []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("ListPlans()")
 
     productPrices := map[string][]stripe.Price{}
@@ -66,7 +66,7 @@ func ListPlans(orgId int64, parameters []string, body []byte, clients *client.Cl
     organization, err := clients.Cassandra.GetOrganization(orgId)
     if err != nil {
         log.DefaultLogger.Error("Unable to read organization.")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
     var result []*model.PlanSettings
     for _, prod := range clients.Stripe.Products {
@@ -90,7 +90,7 @@ func ListPlans(orgId int64, parameters []string, body []byte, clients *client.Cl
     }, nil
 }
 
-func CheckOut(orgId int64, parameters []string, body []byte, _ *client.Clients) (*backend.CallResourceResponse, error) {
+func CheckOut(orgId int64, parameters []string, body []byte, _ *Request, _ *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("CheckOut(" + strconv.FormatInt(orgId, 10) + ")")
     log.DefaultLogger.Info(fmt.Sprintf("Parameters: %+v", parameters))
     log.DefaultLogger.Info(fmt.Sprintf("Body: %s", string(body)))
@@ -98,7 +98,7 @@ func CheckOut(orgId int64, parameters []string, body []byte, _ *client.Clients)
     var pricing PlanPricing
     err := json.Unmarshal(body, &pricing)
     if err != nil {
-        return nil, err
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
     }
     stripe.Key = GetStripeKey()
     successUrl := "https://sensetif.net/a/sensetif-app?tab=succeeded&session_id={CHECKOUT_SESSION_ID}"
@@ -153,12 +153,12 @@ func CheckOut(orgId int64, parameters []string, body []byte, _ *client.Clients)
     }
 }
 
-func CheckOutSuccess(orgId int64 /*parameters*/, _ []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func CheckOutSuccess(orgId int64 /*parameters*/, _ []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
 
     var sessionProxy SessionProxy
     err := json.Unmarshal(body, &sessionProxy)
     if err != nil {
-        return nil, err
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
     }
     params := &stripe.CheckoutSessionParams{}
     stripeSession, err := session.Get(sessionProxy.Id, params)
@@ -200,12 +200,12 @@ func CheckOutSuccess(orgId int64 /*parameters*/, _ []string, body []byte, client
     }, nil
 }
 
-func CheckOutCancelled(orgId int64 /* parameters */, _ []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func CheckOutCancelled(orgId int64 /* parameters */, _ []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("CheckOutCancelled(" + strconv.FormatInt(orgId, 10) + ")")
     var sessionProxy SessionProxy
     err := json.Unmarshal(body, &sessionProxy)
     if err != nil {
-        return nil, err
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
     }
     params := &stripe.CheckoutSessionParams{}
     stripeSession, err := session.Get(sessionProxy.Id, params)
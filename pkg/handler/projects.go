@@ -4,7 +4,6 @@ import (
     "encoding/json"
     "fmt"
     "net/http"
-    "strconv"
 
     "github.com/Sensetif/sensetif-datasource/pkg/client"
     "github.com/Sensetif/sensetif-datasource/pkg/model"
@@ -13,79 +12,93 @@ import (
 )
 
 //goland:noinspection GoUnusedParameter
-func ListProjects(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func ListProjects(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("ListProjects()")
+    options, err := parseListOptions(request, "name", "title", "city", "country")
+    if err != nil {
+        return nil, err
+    }
+    if options.cassandraPaging() {
+        page, err := clients.Cassandra.FindProjectsPage(orgId, options.limit, options.pageState)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        return listResponse(&options, page.Items, page.Total, pageStateCursorOf(page.PageState))
+    }
     projects, err := clients.Cassandra.FindAllProjects(orgId)
     if err != nil {
         log.DefaultLogger.Error("Unable to read project.")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
-    rawJson, err := json.Marshal(projects)
-    if err != nil {
-        log.DefaultLogger.Error("Unable to marshal json")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+    result := make([]model.ProjectSettings, 0)
+    for _, project := range projects {
+        if options.matches(project.Name, project.Title) {
+            result = append(result, project)
+        }
     }
-    return &backend.CallResourceResponse{
-        Status: http.StatusOK,
-        Body:   rawJson,
-    }, nil
+    options.sort(result, func(i int, field string) string {
+        switch field {
+        case "title":
+            return result[i].Title
+        case "city":
+            return result[i].City
+        case "country":
+            return result[i].Country
+        }
+        return result[i].Name
+    })
+    start, end, next := options.window(len(result))
+    return listResponse(&options, result[start:end], len(result), next)
 }
 
 //goland:noinspection GoUnusedParameter
-func GetProject(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func GetProject(orgId int64, params []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("GetProject()")
     project, err := clients.Cassandra.GetProject(orgId, params[1])
     if err != nil {
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if project.Name == "" {
+        return nil, fmt.Errorf("%w: project %s", model.ErrNotFound, params[1])
     }
     bytes, err := json.Marshal(project)
     if err != nil {
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
     return &backend.CallResourceResponse{
         Status: http.StatusOK,
-        Body:   bytes,
+        Headers: map[string][]string{
+            "ETag": {ETagOf(bytes)},
+        },
+        Body: bytes,
     }, nil
 }
 
 //goland:noinspection GoUnusedParameter
-func UpdateProject(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func UpdateProject(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("UpdateProject()")
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":updateProject"
-    log.DefaultLogger.Info(fmt.Sprintf("%+v", *clients.Pulsar))
-    clients.Pulsar.Send(model.ConfigurationTopic, key, body)
-    return &backend.CallResourceResponse{
-        Status: http.StatusAccepted,
-    }, nil
+    var project model.ProjectSettings
+    err := decodeSettings(body, &project)
+    err = checkPath(err, params, pathField{"name", project.Name})
+    if err != nil {
+        return nil, err
+    }
+    return SubmitConfiguration(orgId, "updateProject", body, request, clients)
 }
 
 //goland:noinspection GoUnusedParameter
-func DeleteProject(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func DeleteProject(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("DeleteProject()")
     if len(params) < 2 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
     }
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":deleteProject"
-    data, err := json.Marshal(map[string]string{
+    return SubmitConfiguration(orgId, "deleteProject", map[string]string{
         "project": params[1],
-    })
-    if err == nil {
-        clients.Pulsar.Send(model.ConfigurationTopic, key, data)
-        return &backend.CallResourceResponse{
-            Status: http.StatusAccepted,
-        }, nil
-    }
-    return &backend.CallResourceResponse{
-        Status: http.StatusBadRequest,
-    }, nil
+    }, request, clients)
 }
 
 //goland:noinspection GoUnusedParameter
-func RenameProject(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func RenameProject(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     log.DefaultLogger.Info("RenameProject()")
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":renameProject"
-    clients.Pulsar.Send(model.ConfigurationTopic, key, body)
-    return &backend.CallResourceResponse{
-        Status: http.StatusAccepted,
-    }, nil
+    return SubmitConfiguration(orgId, "renameProject", body, request, clients)
 }
@@ -0,0 +1,118 @@
+package handler
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/Sensetif/sensetif-datasource/pkg/scalefunc"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// How many of the latest values of a saved datapoint a scaling function is tried on, when no inputs are given.
+const (
+    scaleFuncTestValues = 20
+    scaleFuncTestPeriod = 24 * time.Hour
+)
+
+// TestScaleFunc compiles the custom scaling function of the model.ScaleFuncTest in the body, and evaluates it for each
+// of the inputs. The outcome is in the model.ScaleFuncTestResult, also when the function is invalid. Query parameters;
+//   datapoint  {project}/{subsystem}/{datapoint} of a saved datapoint, whose scalefunc is used if the body has none,
+//              and whose latest stored values are used if the body has no inputs
+//goland:noinspection GoUnusedParameter
+func TestScaleFunc(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("TestScaleFunc()")
+    var test model.ScaleFuncTest
+    err := json.Unmarshal(body, &test)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    if saved := request.Query.Get("datapoint"); saved != "" {
+        err = withSavedScaleFunc(orgId, saved, &test, clients)
+        if err != nil {
+            return nil, err
+        }
+    }
+    err = test.Validate()
+    if err != nil {
+        return nil, err
+    }
+
+    result := model.ScaleFuncTestResult{Samples: make([]model.ScaleFuncSample, 0, len(test.Inputs)), Errors: make([]model.TestError, 0)}
+    fn, err := scalefunc.Compile(test.ScaleFunc)
+    if err != nil {
+        result.Errors = append(result.Errors, scaleErrorOf(err))
+    } else {
+        for _, input := range test.Inputs {
+            sample := model.ScaleFuncSample{Input: input}
+            output, err := fn.Eval(input)
+            if err != nil {
+                testError := scaleErrorOf(err)
+                sample.Error = &testError
+            } else {
+                sample.Output = &output
+            }
+            result.Samples = append(result.Samples, sample)
+        }
+    }
+    result.Ok = len(result.Errors) == 0
+    for _, sample := range result.Samples {
+        result.Ok = result.Ok && sample.Error == nil
+    }
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// withSavedScaleFunc fills in what the test lacks from a saved datapoint.
+func withSavedScaleFunc(orgId int64, path string, test *model.ScaleFuncTest, clients *client.Clients) error {
+    names := strings.Split(path, "/")
+    if len(names) != 3 {
+        return fmt.Errorf("%w: datapoint must be {project}/{subsystem}/{datapoint}", model.ErrBadRequest)
+    }
+    saved, err := clients.Cassandra.GetDatapoint(orgId, names[0], names[1], names[2])
+    if err != nil {
+        return fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if saved.Name == "" {
+        return fmt.Errorf("%w: datapoint %s", model.ErrNotFound, path)
+    }
+    if strings.TrimSpace(test.ScaleFunc) == "" {
+        test.ScaleFunc = saved.Proc.ScaleFunc
+    }
+    if len(test.Inputs) == 0 {
+        now := time.Now()
+        ref := model.SensorRef{Project: names[0], Subsystem: names[1], Datapoint: names[2]}
+        for _, t := range clients.Cassandra.QueryTimeseries(orgId, ref, now.Add(-scaleFuncTestPeriod), now, scaleFuncTestValues) {
+            test.Inputs = append(test.Inputs, t.Value)
+        }
+    }
+    return nil
+}
+
+// scaleErrorOf keeps the position of the problem, so that the UI can point at it.
+func scaleErrorOf(err error) model.TestError {
+    testError := model.TestError{Stage: model.StageScale, Field: "scalefunc", Message: err.Error()}
+    var syntaxErr *scalefunc.SyntaxError
+    var evalErr *scalefunc.EvalError
+    switch {
+    case errors.As(err, &syntaxErr):
+        position := syntaxErr.Position
+        testError.Position = &position
+    case errors.As(err, &evalErr):
+        position := evalErr.Position
+        testError.Position = &position
+    }
+    return testError
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const defaultSearchLimit = 20
+
+const maxSearchLimit = 100
+
+// Search finds projects, subsystems and datapoints of the organization by their names, titles, cities, locations,
+// units and source addresses. Query parameters;
+//   q      the words to look for, in any order. Small typos are tolerated.
+//   kind   project, subsystem or datapoint, to only find those
+//   limit  the number of hits, default 20 and at most 100
+// Hits are ranked by score, and their path is {project}, {project}/{subsystem} or {project}/{subsystem}/{datapoint},
+// which is the path of the projects.get, subsystems.get or datapoints.get route of what was found.
+//goland:noinspection GoUnusedParameter
+func Search(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("Search()")
+    query := request.Query.Get("q")
+    terms := model.SearchTerms(query)
+    if len(terms) == 0 {
+        return nil, fmt.Errorf("%w: q must contain at least one word to search for", model.ErrBadRequest)
+    }
+    kind := request.Query.Get("kind")
+    if kind != "" && kind != "project" && kind != "subsystem" && kind != "datapoint" {
+        return nil, fmt.Errorf("%w: kind must be project, subsystem or datapoint", model.ErrBadRequest)
+    }
+    limit := defaultSearchLimit
+    if text := request.Query.Get("limit"); text != "" {
+        var err error
+        limit, err = strconv.Atoi(text)
+        if err != nil || limit < 1 || limit > maxSearchLimit {
+            return nil, fmt.Errorf("%w: limit must be between 1 and %d", model.ErrBadRequest, maxSearchLimit)
+        }
+    }
+    entries, indexed, err := clients.Search.Entries(orgId)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    hits := make([]model.SearchHit, 0)
+    for i := range entries {
+        entry := &entries[i]
+        if kind != "" && entry.Kind != kind {
+            continue
+        }
+        score, matches := entry.Score(terms)
+        if score > 0 {
+            hits = append(hits, model.SearchHit{Kind: entry.Kind, Path: entry.Path, Title: entry.Title, Score: score, Matches: matches})
+        }
+    }
+    sort.SliceStable(hits, func(i, j int) bool {
+        if hits[i].Score != hits[j].Score {
+            return hits[i].Score > hits[j].Score
+        }
+        return hits[i].Path < hits[j].Path
+    })
+    result := model.SearchResult{Query: query, Total: len(hits), Hits: hits, Indexed: indexed}
+    if len(result.Hits) > limit {
+        result.Hits = result.Hits[:limit]
+    }
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
@@ -4,7 +4,6 @@ import (
     "encoding/json"
     "fmt"
     "net/http"
-    "strconv"
 
     "github.com/Sensetif/sensetif-datasource/pkg/client"
     "github.com/Sensetif/sensetif-datasource/pkg/model"
@@ -13,30 +12,47 @@ import (
 )
 
 //goland:noinspection GoUnusedParameter
-func ListSubsystems(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func ListSubsystems(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     if len(params) < 2 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
     }
-
+    options, err := parseListOptions(request, "name", "title", "location")
+    if err != nil {
+        return nil, err
+    }
+    if options.cassandraPaging() {
+        page, err := clients.Cassandra.FindSubsystemsPage(orgId, params[1], options.limit, options.pageState)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        return listResponse(&options, page.Items, page.Total, pageStateCursorOf(page.PageState))
+    }
     subsystems, err := clients.Cassandra.FindAllSubsystems(orgId, params[1])
     if err != nil {
         log.DefaultLogger.Error("Unable to read subsystems")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
-    rawJson, err := json.Marshal(subsystems)
-    if err != nil {
-        log.DefaultLogger.Error("Unable to marshal json")
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+    result := make([]model.SubsystemSettings, 0)
+    for _, subsystem := range subsystems {
+        if options.matches(subsystem.Name, subsystem.Title) {
+            result = append(result, subsystem)
+        }
     }
-
-    return &backend.CallResourceResponse{
-        Status: http.StatusOK,
-        Body:   rawJson,
-    }, nil
+    options.sort(result, func(i int, field string) string {
+        switch field {
+        case "title":
+            return result[i].Title
+        case "location":
+            return result[i].Locallocation
+        }
+        return result[i].Name
+    })
+    start, end, next := options.window(len(result))
+    return listResponse(&options, result[start:end], len(result), next)
 }
 
 //goland:noinspection GoUnusedParameter
-func GetSubsystem(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func GetSubsystem(orgId int64, params []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
 
     if len(params) < 3 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
@@ -44,57 +60,50 @@ func GetSubsystem(orgId int64, params []string, body []byte, clients *client.Cli
 
     subsystem, err := clients.Cassandra.GetSubsystem(orgId, params[1], params[2])
     if err != nil {
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if subsystem.Name == "" {
+        return nil, fmt.Errorf("%w: subsystem %s/%s", model.ErrNotFound, params[1], params[2])
     }
     bytes, err := json.Marshal(subsystem)
     if err != nil {
-        return nil, fmt.Errorf("%w: %s", model.ErrUnprocessableEntity, err.Error())
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
     return &backend.CallResourceResponse{
-        Status:  http.StatusOK,
-        Headers: make(map[string][]string),
-        Body:    bytes,
+        Status: http.StatusOK,
+        Headers: map[string][]string{
+            "ETag": {ETagOf(bytes)},
+        },
+        Body: bytes,
     }, nil
 }
 
 //goland:noinspection GoUnusedParameter
-func UpdateSubsystem(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":updateSubsystem"
-    clients.Pulsar.Send(model.ConfigurationTopic, key, body)
-    return &backend.CallResourceResponse{
-        Status: http.StatusAccepted,
-    }, nil
+func UpdateSubsystem(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    var subsystem model.SubsystemSettings
+    err := decodeSettings(body, &subsystem)
+    err = checkPath(err, params, pathField{"project", subsystem.Project}, pathField{"name", subsystem.Name})
+    if err != nil {
+        return nil, err
+    }
+    return SubmitConfiguration(orgId, "updateSubsystem", body, request, clients)
 }
 
 //goland:noinspection GoUnusedParameter
-func DeleteSubsystem(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func DeleteSubsystem(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     if len(params) < 3 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
     }
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":deleteSubsystem"
-    data, err := json.Marshal(map[string]string{
+    return SubmitConfiguration(orgId, "deleteSubsystem", map[string]string{
         "project":   params[1],
         "subsystem": params[2],
-    })
-    if err == nil {
-        clients.Pulsar.Send(model.ConfigurationTopic, key, data)
-        return &backend.CallResourceResponse{
-            Status: http.StatusAccepted,
-        }, nil
-    }
-    return &backend.CallResourceResponse{
-        Status: http.StatusBadRequest,
-    }, nil
+    }, request, clients)
 }
 
 //goland:noinspection GoUnusedParameter
-func RenameSubsystem(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+func RenameSubsystem(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
     if len(params) < 3 {
         return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
     }
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":renameSubsystem"
-    clients.Pulsar.Send(model.ConfigurationTopic, key, body)
-    return &backend.CallResourceResponse{
-        Status: http.StatusAccepted,
-    }, nil
+    return SubmitConfiguration(orgId, "renameSubsystem", body, request, clients)
 }
@@ -0,0 +1,136 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+//goland:noinspection GoUnusedParameter
+func ListTemplates(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("ListTemplates()")
+    options, err := parseListOptions(request, "name", "title")
+    if err != nil {
+        return nil, err
+    }
+    templates, err := clients.Cassandra.FindAllTemplates(orgId)
+    if err != nil {
+        log.DefaultLogger.Error("Unable to read templates")
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    result := make([]model.SubsystemTemplate, 0)
+    for _, template := range templates {
+        if options.matches(template.Name, template.Title) {
+            result = append(result, template)
+        }
+    }
+    options.sort(result, func(i int, field string) string {
+        if field == "title" {
+            return result[i].Title
+        }
+        return result[i].Name
+    })
+    start, end, next := options.window(len(result))
+    return listResponse(&options, result[start:end], len(result), next)
+}
+
+//goland:noinspection GoUnusedParameter
+func GetTemplate(orgId int64, params []string, body []byte, _ *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    if len(params) < 2 {
+        return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+    }
+    template, err := findTemplate(orgId, params[1], clients)
+    if err != nil {
+        return nil, err
+    }
+    bytes, err := json.Marshal(template)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Headers: map[string][]string{
+            "ETag": {ETagOf(bytes)},
+        },
+        Body: bytes,
+    }, nil
+}
+
+//goland:noinspection GoUnusedParameter
+func UpdateTemplate(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    var template model.SubsystemTemplate
+    err := decodeSettings(body, &template)
+    err = checkPath(err, params, pathField{"name", template.Name})
+    if err != nil {
+        return nil, err
+    }
+    return SubmitConfiguration(orgId, "updateTemplate", body, request, clients)
+}
+
+//goland:noinspection GoUnusedParameter
+func DeleteTemplate(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    if len(params) < 2 {
+        return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+    }
+    return SubmitConfiguration(orgId, "deleteTemplate", map[string]string{
+        "name": params[1],
+    }, request, clients)
+}
+
+// InstantiateTemplate creates a subsystem with all datapoints from the template in the path, with the placeholders
+// replaced by the values of the model.TemplateInstance in the body. Like ImportBundle, it returns the
+// model.BundlePlan, and the subsystem is only published to the configuration topic if the query parameter apply=true
+// is given.
+//goland:noinspection GoUnusedParameter
+func InstantiateTemplate(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("InstantiateTemplate()")
+    if len(params) < 2 {
+        return nil, fmt.Errorf("%w: missing params: \"%v\"", model.ErrBadRequest, params)
+    }
+    var instance model.TemplateInstance
+    err := json.Unmarshal(body, &instance)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    template, err := findTemplate(orgId, params[1], clients)
+    if err != nil {
+        return nil, err
+    }
+    sb, err := template.Instantiate(instance)
+    if err != nil {
+        return nil, err
+    }
+    project, err := clients.Cassandra.GetProject(orgId, instance.Project)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if project.Name == "" {
+        return nil, fmt.Errorf("%w: project %s", model.ErrNotFound, instance.Project)
+    }
+    existing, err := clients.Cassandra.GetSubsystem(orgId, instance.Project, sb.Subsystem.Name)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if existing.Name != "" {
+        return nil, fmt.Errorf("%w: subsystem %s/%s already exists", model.ErrConflict, instance.Project, sb.Subsystem.Name)
+    }
+    bundle := model.ProjectBundle{Version: model.BundleVersion, Project: project, Subsystems: []model.SubsystemBundle{sb}}
+    // the rest of the project is left out of the current configuration, so that the plan only creates the subsystem
+    return planAndApply(orgId, &bundle, &model.ProjectBundle{Project: project}, request, clients)
+}
+
+func findTemplate(orgId int64, name string, clients *client.Clients) (model.SubsystemTemplate, error) {
+    template, err := clients.Cassandra.GetTemplate(orgId, name)
+    if err != nil {
+        return template, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if template.Name == "" {
+        return template, fmt.Errorf("%w: template %s", model.ErrNotFound, name)
+    }
+    return template, nil
+}
@@ -1,12 +1,15 @@
 package handler
 
 import (
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/json"
     "fmt"
     "github.com/Sensetif/sensetif-datasource/pkg/client"
     "github.com/Sensetif/sensetif-datasource/pkg/model"
     "github.com/grafana/grafana-plugin-sdk-go/backend"
     "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+    "math"
     "net/http"
     "strconv"
     "time"
@@ -21,34 +24,176 @@ type TsDatapoint struct {
     Value        float64   `json:"value"`
 }
 
-func UpdateTimeseries(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
-    key := "2:" + strconv.FormatInt(orgId, 10) + ":" + params[1] + "/" + params[2] + "/" + params[3]
-    log.DefaultLogger.Info("Timeseries update of: " + key)
-    tspairs := []model.TsPair{}
-    err := json.Unmarshal(body, &tspairs)
-    log.DefaultLogger.Info("Timeseries: " + strconv.FormatInt(int64(len(tspairs)), 10))
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// UpdateTimeseries adds samples, given as an array of model.TsPair, to an existing datapoint. Samples that are ahead of
+// the clock by more than model.MaxFutureSkew, older than the TimeToLive of the datapoint or not a number are rejected,
+// and the others are published in batches. The response has the outcome of each sample. When publishing fails, the
+// status is 503 and the samples that were not published have an error; the batches after the failing one are not sent.
+// With an Idempotency-Key header, retries of the request within 24 hours get the original response, marked with the
+// Idempotent-Replayed header, instead of publishing the samples again. That includes the 503 responses, so the samples
+// that were not published are to be resent with another key.
+func UpdateTimeseries(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    path := params[1] + "/" + params[2] + "/" + params[3]
+    log.DefaultLogger.Info("Timeseries update of: " + strconv.FormatInt(orgId, 10) + ":" + path)
+    idempotencyKey := request.Header(idempotencyKeyHeader)
+    if idempotencyKey == "" {
+        return updateTimeseries(orgId, params, body, clients)
+    }
+    fingerprint := sha256.Sum256(append([]byte(path+"\n"), body...))
+    stored, err := clients.Idempotency.Begin(orgId, idempotencyKey, hex.EncodeToString(fingerprint[:]))
     if err != nil {
-        log.DefaultLogger.Error("Invalid format: " + err.Error())
+        return nil, err
+    }
+    if stored != nil {
+        log.DefaultLogger.Info("Replaying timeseries update with " + idempotencyKeyHeader + " " + idempotencyKey)
         return &backend.CallResourceResponse{
-            Status: http.StatusBadRequest,
+            Status: stored.Status,
+            Headers: map[string][]string{
+                "Idempotent-Replayed": {"true"},
+            },
+            Body: stored.Body,
         }, nil
     }
-    for _, tspair := range tspairs {
-        message := TsDatapoint{
-            Organization: orgId,
-            Project:      params[1],
-            Subsystem:    params[2],
-            Name:         params[3],
-            Timestamp:    tspair.TS,
-            Value:        tspair.Value,
+    result, err := updateTimeseries(orgId, params, body, clients)
+    if err != nil {
+        clients.Idempotency.Abandon(orgId, idempotencyKey)
+        return nil, err
+    }
+    clients.Idempotency.Complete(orgId, idempotencyKey, result.Status, result.Body)
+    return result, nil
+}
+
+func updateTimeseries(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    samples := []json.RawMessage{}
+    err := json.Unmarshal(body, &samples)
+    if err != nil {
+        return nil, fmt.Errorf("%w: an array of samples is required: %s", model.ErrBadRequest, err.Error())
+    }
+    datapoint, err := clients.Cassandra.GetDatapoint(orgId, params[1], params[2], params[3])
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    if datapoint.Name == "" {
+        return nil, fmt.Errorf("%w: datapoint %s/%s/%s", model.ErrNotFound, params[1], params[2], params[3])
+    }
+    check := newSampleCheck(datapoint, time.Now())
+
+    result := model.TimeseriesUpdateResult{
+        Datapoint: params[1] + "/" + params[2] + "/" + params[3],
+        Results:   make([]model.TimeseriesPointResult, 0, len(samples)),
+    }
+    values := make([][]byte, 0, len(samples))
+    for i, sample := range samples {
+        var tspair model.TsPair
+        point := model.TimeseriesPointResult{Index: i}
+        err = json.Unmarshal(sample, &tspair)
+        point.TS = tspair.TS
+        problem := check.problem(tspair)
+        switch {
+        case err != nil:
+            point.Error = "not a sample: " + err.Error()
+        case tspair.TS.IsZero():
+            point.Error = "ts is missing"
+        case problem != "":
+            point.Error = problem
+        default:
+            message, _ := json.Marshal(TsDatapoint{
+                Organization: orgId,
+                Project:      params[1],
+                Subsystem:    params[2],
+                Name:         params[3],
+                Timestamp:    tspair.TS,
+                Value:        tspair.Value,
+            })
+            values = append(values, message)
+            point.Accepted = true
+        }
+        if point.Accepted {
+            result.Accepted++
+        } else {
+            result.Rejected++
+        }
+        result.Results = append(result.Results, point)
+    }
+
+    key := "2:" + strconv.FormatInt(orgId, 10) + ":" + result.Datapoint
+    accepted := make([]int, 0, len(values))
+    for i, point := range result.Results {
+        if point.Accepted {
+            accepted = append(accepted, i)
+        }
+    }
+    var sendErr error
+    for start := 0; start < len(values); start += importBatchSize {
+        end := start + importBatchSize
+        if end > len(values) {
+            end = len(values)
         }
-        msgjson, err2 := json.Marshal(message)
-        if err2 == nil {
-            clients.Pulsar.Send(model.TimeseriesTopic, key, msgjson)
-            log.DefaultLogger.Info(fmt.Sprintf("Update sent for: %d:%s/%s/%s = %f", orgId, params[1], params[2], params[3], tspair.Value))
+        if sendErr != nil {
+            for _, i := range accepted[start:end] {
+                result.Results[i].Error = "not published, an earlier batch failed"
+            }
+            continue
         }
+        for n, err := range clients.Pulsar.SendEach(model.TimeseriesTopic, key, values[start:end]) {
+            point := &result.Results[accepted[start+n]]
+            if err != nil {
+                point.Error = "not published: " + err.Error()
+                sendErr = err
+            } else {
+                point.Published = true
+                result.Published++
+            }
+        }
+    }
+    status := http.StatusAccepted
+    if sendErr != nil {
+        // The outcome of each sample is returned, and kept for the Idempotency-Key, so that a retry does not publish
+        // the samples again. The client resends the samples that were not published.
+        log.DefaultLogger.Error(fmt.Sprintf("Timeseries update of %d:%s; published %d of %d samples: %s", orgId, result.Datapoint, result.Published, result.Accepted, sendErr.Error()))
+        status = http.StatusServiceUnavailable
+    }
+    log.DefaultLogger.Info(fmt.Sprintf("Timeseries update of %d:%s; %d published, %d rejected", orgId, result.Datapoint, result.Published, result.Rejected))
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
     }
     return &backend.CallResourceResponse{
-        Status: http.StatusAccepted,
+        Status: status,
+        Body:   rawJson,
     }, nil
 }
+
+// sampleCheck rejects the samples that the backend would not keep for the datapoint; values that are not numbers,
+// timestamps more than model.MaxFutureSkew ahead, and those that are already past the time to live. Both
+// UpdateTimeseries and ImportTimeseries use it, as they publish on the same topic.
+type sampleCheck struct {
+    latest    time.Time
+    oldest    time.Time
+    retention time.Duration
+    expires   bool
+}
+
+func newSampleCheck(datapoint model.DatapointSettings, now time.Time) sampleCheck {
+    retention, expires := datapoint.TimeToLive.Duration()
+    return sampleCheck{
+        latest:    now.Add(model.MaxFutureSkew),
+        oldest:    now.Add(-retention),
+        retention: retention,
+        expires:   expires,
+    }
+}
+
+// problem returns why the sample is rejected, or "" if it is not.
+func (c sampleCheck) problem(sample model.TsPair) string {
+    switch {
+    case math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0):
+        return "value is not a number"
+    case sample.TS.After(c.latest):
+        return fmt.Sprintf("ts is more than %s in the future", model.MaxFutureSkew)
+    case c.expires && sample.TS.Before(c.oldest):
+        return fmt.Sprintf("ts is older than the time to live of the datapoint, %d days", int(c.retention.Hours()/24))
+    }
+    return ""
+}
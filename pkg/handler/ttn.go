@@ -0,0 +1,274 @@
+package handler
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const ttnTimeout = 15 * time.Second
+
+const (
+    defaultTtnUplinks = 20
+    maxTtnUplinks     = 100
+)
+
+// The interval of a previewed datapoint whose device has sent too few uplinks to tell.
+const defaultTtnInterval = model.Fifteen_minutes
+
+var invalidDatapointChars = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+
+// TtnDevices lists the end devices of the TTN v3 application given by the zone, application and authorizationkey of
+// the model.Ttnv3Datasource in the body. Query parameters;
+//   datapoint  {project}/{subsystem}/{datapoint} of a saved datapoint, whose key is used if the body has the
+//              redacted one of an export or a preview
+//goland:noinspection GoUnusedParameter
+func TtnDevices(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("TtnDevices()")
+    ds, err := ttnDatasourceOf(orgId, body, request, clients)
+    if err != nil {
+        return nil, err
+    }
+    ctx, cancel := context.WithTimeout(request.Context(), ttnTimeout)
+    defer cancel()
+    devices, err := clients.Ttn.ListDevices(ctx, ds)
+    if err != nil {
+        return nil, ttnErrorOf(err, ds)
+    }
+    return ttnResponse(devices)
+}
+
+// TtnPoints lists the fields of the decoded payloads in the recent uplinks of the application, or of one device if
+// the body has a device. Each field can be the Point of a datapoint. Query parameters;
+//   limit      number of uplinks to look at, default 20 and at most 100
+//   project    with subsystem, each point has a preview of the datapoint that would be created in that subsystem
+//   subsystem
+//   datapoint  {project}/{subsystem}/{datapoint} of a saved datapoint, whose key is used if the body has the
+//              redacted one of an export or a preview
+//goland:noinspection GoUnusedParameter
+func TtnPoints(orgId int64, params []string, body []byte, request *Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    log.DefaultLogger.Info("TtnPoints()")
+    ds, err := ttnDatasourceOf(orgId, body, request, clients)
+    if err != nil {
+        return nil, err
+    }
+    limit, err := boundedQueryInt(request, "limit", defaultTtnUplinks, maxTtnUplinks)
+    if err != nil {
+        return nil, err
+    }
+    project := request.Query.Get("project")
+    subsystem := request.Query.Get("subsystem")
+    if (project == "") != (subsystem == "") {
+        return nil, fmt.Errorf("%w: project and subsystem must be given together", model.ErrBadRequest)
+    }
+    ctx, cancel := context.WithTimeout(request.Context(), ttnTimeout)
+    defer cancel()
+    uplinks, err := clients.Ttn.RecentUplinks(ctx, ds, limit)
+    if err != nil {
+        return nil, ttnErrorOf(err, ds)
+    }
+    discovery := model.TtnDiscovery{
+        Application: ds.Application,
+        Uplinks:     len(uplinks),
+        Points:      ttnPointsOf(uplinks),
+    }
+    if project != "" {
+        limits, err := clients.Cassandra.GetCurrentLimits(orgId)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+        }
+        intervals := ttnIntervalsOf(uplinks, model.PollInterval(limits.MinPollInterval))
+        for i := range discovery.Points {
+            point := &discovery.Points[i]
+            name := point.Point
+            if ds.Device == "" {
+                name = point.Device + "_" + point.Point
+            }
+            source := ds
+            source.Device = point.Device
+            source.Point = point.Point
+            source.Port = point.Port
+            preview := model.DatapointSettings{
+                Project:    project,
+                Subsystem:  subsystem,
+                Name:       datapointNameOf(name),
+                Interval:   intervals[point.Device],
+                Proc:       model.Processing{Scaling: model.Lin, K: 1},
+                TimeToLive: model.TimeToLive(limits.MaxStorage),
+                SourceType: model.Ttnv3,
+                Datasource: source,
+            }.Redacted()
+            point.Preview = &preview
+        }
+    }
+    return ttnResponse(discovery)
+}
+
+func ttnDatasourceOf(orgId int64, body []byte, request *Request, clients *client.Clients) (model.Ttnv3Datasource, error) {
+    var ds model.Ttnv3Datasource
+    err := json.Unmarshal(body, &ds)
+    if err != nil {
+        return ds, fmt.Errorf("%w: %s", model.ErrBadRequest, err.Error())
+    }
+    if saved := request.Query.Get("datapoint"); saved != "" {
+        merged, err := withSavedSecrets(orgId, saved, model.Ttnv3, ds, clients)
+        if err != nil {
+            return ds, err
+        }
+        ds = merged.(model.Ttnv3Datasource)
+    }
+    errs := &model.ValidationError{}
+    if ds.Zone == "" {
+        errs.Add("datasource.zone", "must not be empty")
+    }
+    if ds.Application == "" {
+        errs.Add("datasource.application", "must not be empty")
+    }
+    if ds.AuthorizationKey == "" || ds.AuthorizationKey == model.RedactedSecret {
+        errs.Add("datasource.authorizationkey", "an API key of the application is required")
+    }
+    return ds, errs.Err()
+}
+
+// ttnErrorOf points at the setting that is the likely cause of an error response from TTN.
+func ttnErrorOf(err error, ds model.Ttnv3Datasource) error {
+    var ttnErr *client.TtnError
+    if !errors.As(err, &ttnErr) {
+        return fmt.Errorf("%w: unable to reach TTN zone %s: %s", model.ErrBadGateway, ds.Zone, err.Error())
+    }
+    errs := &model.ValidationError{}
+    switch ttnErr.Status {
+    case http.StatusUnauthorized, http.StatusForbidden:
+        errs.Add("datasource.authorizationkey", "TTN does not accept the key for application %s: %s", ds.Application, ttnErr.Message)
+    case http.StatusNotFound:
+        if ds.Device != "" {
+            errs.Add("datasource.device", "TTN has no device %s in application %s, or the Storage Integration is not enabled: %s", ds.Device, ds.Application, ttnErr.Message)
+        } else {
+            errs.Add("datasource.application", "TTN has no application %s, or its Storage Integration is not enabled: %s", ds.Application, ttnErr.Message)
+        }
+    default:
+        return fmt.Errorf("%w: %s", model.ErrBadGateway, ttnErr.Error())
+    }
+    return errs
+}
+
+// ttnPointsOf collects the fields of the decoded payloads, per device. Uplinks are newest first, so the first value
+// seen of a field is the latest.
+func ttnPointsOf(uplinks []model.TtnUplink) []model.TtnPoint {
+    type pointKey struct {
+        device string
+        point  string
+    }
+    points := map[pointKey]*model.TtnPoint{}
+    for _, uplink := range uplinks {
+        flattenFields("", uplink.Fields, func(name string, value interface{}) {
+            key := pointKey{uplink.Device, name}
+            point, found := points[key]
+            if !found {
+                point = &model.TtnPoint{Device: uplink.Device, Point: name, Port: uplink.Port, Numeric: true, Latest: value, LastSeen: uplink.Received}
+                points[key] = point
+            }
+            point.Samples++
+            if _, isNumber := value.(float64); !isNumber {
+                point.Numeric = false
+            }
+        })
+    }
+    result := make([]model.TtnPoint, 0, len(points))
+    for _, point := range points {
+        result = append(result, *point)
+    }
+    sort.Slice(result, func(i, j int) bool {
+        if result[i].Device != result[j].Device {
+            return result[i].Device < result[j].Device
+        }
+        return result[i].Point < result[j].Point
+    })
+    return result
+}
+
+// flattenFields calls fn with each value of the decoded payload that is not an object or array, named by its path.
+func flattenFields(prefix string, value interface{}, fn func(name string, value interface{})) {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for key, child := range v {
+            name := key
+            if prefix != "" {
+                name = prefix + "." + key
+            }
+            flattenFields(name, child, fn)
+        }
+    case []interface{}:
+        for i, child := range v {
+            flattenFields(prefix+"["+strconv.Itoa(i)+"]", child, fn)
+        }
+    default:
+        if prefix != "" {
+            fn(prefix, v)
+        }
+    }
+}
+
+// ttnIntervalsOf picks the poll interval of each device from the median time between its uplinks, not faster than
+// the plan allows.
+func ttnIntervalsOf(uplinks []model.TtnUplink, minInterval model.PollInterval) map[string]model.PollInterval {
+    received := map[string][]time.Time{}
+    for _, uplink := range uplinks {
+        received[uplink.Device] = append(received[uplink.Device], uplink.Received)
+    }
+    intervals := map[string]model.PollInterval{}
+    for device, times := range received {
+        interval := defaultTtnInterval
+        if len(times) > 1 {
+            gaps := make([]time.Duration, 0, len(times)-1)
+            for i := 1; i < len(times); i++ {
+                gaps = append(gaps, times[i-1].Sub(times[i]))
+            }
+            sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+            median := gaps[len(gaps)/2]
+            interval = model.Monthly
+            for _, candidate := range model.PollIntervals {
+                if candidate.Duration() >= median {
+                    interval = candidate
+                    break
+                }
+            }
+        }
+        if interval.Duration() < minInterval.Duration() {
+            interval = minInterval
+        }
+        intervals[device] = interval
+    }
+    return intervals
+}
+
+// datapointNameOf makes a valid datapoint name of a field name.
+func datapointNameOf(name string) string {
+    name = invalidDatapointChars.ReplaceAllString(name, "_")
+    if name == "" || !(name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z') {
+        name = "p_" + name
+    }
+    return name
+}
+
+func ttnResponse(result interface{}) (*backend.CallResourceResponse, error) {
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+func TestTtnErrorOf(t *testing.T) {
+    tests := []struct {
+        name   string
+        err    error
+        device string
+        field  string
+        cause  error
+    }{
+        {"401 is the key", &client.TtnError{Status: http.StatusUnauthorized, Message: "token not found"}, "", "datasource.authorizationkey", model.ErrUnprocessableEntity},
+        {"403 is the key", &client.TtnError{Status: http.StatusForbidden, Message: "no rights"}, "", "datasource.authorizationkey", model.ErrUnprocessableEntity},
+        {"404 is the application", &client.TtnError{Status: http.StatusNotFound, Message: "not found"}, "", "datasource.application", model.ErrUnprocessableEntity},
+        {"404 is the device, if given", &client.TtnError{Status: http.StatusNotFound, Message: "not found"}, "shed-sensor", "datasource.device", model.ErrUnprocessableEntity},
+        {"500 is TTN", &client.TtnError{Status: http.StatusInternalServerError, Message: "oops"}, "", "", model.ErrBadGateway},
+        {"network errors are TTN", errors.New("connection refused"), "", "", model.ErrBadGateway},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            ds := model.Ttnv3Datasource{Zone: "eu1", Application: "garden", Device: test.device}
+            err := ttnErrorOf(test.err, ds)
+            var validation *model.ValidationError
+            if test.field == "" {
+                if !errors.Is(err, test.cause) || errors.As(err, &validation) {
+                    t.Errorf("expected %v, got %v", test.cause, err)
+                }
+                return
+            }
+            if !errors.As(err, &validation) || !errors.Is(err, test.cause) || len(validation.Errors) != 1 || validation.Errors[0].Field != test.field {
+                t.Errorf("expected a validation error on %s, got %v", test.field, err)
+            }
+        })
+    }
+}
+
+func TestTtnDevices(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Authorization") != "Bearer NNSXS.SAVED" {
+            w.WriteHeader(http.StatusUnauthorized)
+            _, _ = w.Write([]byte(`{"message": "token not found"}`))
+            return
+        }
+        _, _ = w.Write([]byte(`{"end_devices": [{"ids": {"device_id": "shed-sensor"}, "name": "Shed"}]}`))
+    }))
+    defer server.Close()
+    clients := &client.Clients{
+        Ttn: &client.TtnClient{Http: server.Client(), BaseURL: server.URL},
+        Cassandra: &savedDatapoints{datapoints: map[string]model.DatapointSettings{
+            "garden/shed/humidity": {
+                Name:       "humidity",
+                SourceType: model.Ttnv3,
+                Datasource: model.Ttnv3Datasource{Zone: "eu1", Application: "garden", AuthorizationKey: "NNSXS.SAVED"},
+            },
+        }},
+    }
+    body := []byte(`{"zone": "eu1", "application": "garden", "authorizationkey": "<redacted>"}`)
+    request := &Request{Query: map[string][]string{"datapoint": {"garden/shed/humidity"}}}
+    response, err := TtnDevices(1, nil, body, request, clients)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var devices []model.TtnDevice
+    if err := json.Unmarshal(response.Body, &devices); err != nil {
+        t.Fatal(err)
+    }
+    if len(devices) != 1 || devices[0].Id != "shed-sensor" || devices[0].Name != "Shed" {
+        t.Errorf("unexpected devices %+v", devices)
+    }
+
+    body = []byte(`{"zone": "eu1", "application": "garden", "authorizationkey": "NNSXS.WRONG"}`)
+    _, err = TtnDevices(1, nil, body, &Request{}, clients)
+    var validation *model.ValidationError
+    if !errors.As(err, &validation) || validation.Errors[0].Field != "datasource.authorizationkey" {
+        t.Errorf("expected the key to be pointed out, got %v", err)
+    }
+
+    body = []byte(`{"zone": "eu1", "application": "garden", "authorizationkey": "<redacted>"}`)
+    _, err = TtnDevices(1, nil, body, &Request{}, clients)
+    if !errors.As(err, &validation) || validation.Errors[0].Field != "datasource.authorizationkey" {
+        t.Errorf("expected a redacted key without a saved datapoint to be refused, got %v", err)
+    }
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// ETagOf returns the version of the settings as an entity tag. There is no version stored with the configuration, so
+// the tag is derived from the settings themselves, and changes whenever any of them does.
+func ETagOf(settings json.RawMessage) string {
+    sum := sha256.Sum256(settings)
+    return "\"" + hex.EncodeToString(sum[:8]) + "\""
+}
+
+// CheckVersion compares the If-Match header with the version of the current settings, which is nil if there are none.
+// Changing existing settings requires If-Match, either with the ETag from the latest GET or "*". Settings that don't
+// exist yet can be created without it, or with "If-None-Match: *" to make sure nobody else created them first.
+func CheckVersion(current json.RawMessage, request *Request) error {
+    ifMatch := request.Header("If-Match")
+    ifNoneMatch := request.Header("If-None-Match")
+    if current == nil {
+        if ifMatch != "" {
+            return fmt.Errorf("%w: it does not exist anymore", model.ErrPreconditionFailed)
+        }
+        return nil
+    }
+    if ifNoneMatch == "*" {
+        return fmt.Errorf("%w: it already exists", model.ErrPreconditionFailed)
+    }
+    if ifMatch == "" {
+        return fmt.Errorf("%w: If-Match with the ETag of the latest version is required", model.ErrPreconditionNeeded)
+    }
+    etag := ETagOf(current)
+    for _, candidate := range strings.Split(ifMatch, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: it has been changed since %s, the current version is %s", model.ErrPreconditionFailed, ifMatch, etag)
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "testing"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+// unreadableProjects is a client.Cassandra whose GetProject fails, like when Cassandra times out.
+type unreadableProjects struct {
+    client.Cassandra
+}
+
+func (u *unreadableProjects) GetProject(_ int64, _ string) (model.ProjectSettings, error) {
+    return model.ProjectSettings{}, errors.New("gocql: no hosts available in the pool")
+}
+
+func TestSnapshotReportsUnreadableSettings(t *testing.T) {
+    clients := &client.Clients{Cassandra: &unreadableProjects{}}
+    current, err := Snapshot(1, "projects.update", []string{"garden", "garden"}, clients)
+    if !errors.Is(err, model.ErrServerError) || current != nil {
+        t.Errorf("expected a server error, got %s, %v", current, err)
+    }
+    current, err = Snapshot(1, "projects.clone", []string{"_clone/garden", "garden"}, clients)
+    if err != nil || current != nil {
+        t.Errorf("a clone doesn't change the original, got %s, %v", current, err)
+    }
+}
+
+func TestCheckVersion(t *testing.T) {
+    current := []byte(`{"name": "garden"}`)
+    etag := ETagOf(current)
+    tests := []struct {
+        name    string
+        current []byte
+        headers map[string]string
+        err     error
+    }{
+        {"creating needs no If-Match", nil, nil, nil},
+        {"creating with If-None-Match", nil, map[string]string{"If-None-Match": "*"}, nil},
+        {"a deleted version", nil, map[string]string{"If-Match": etag}, model.ErrPreconditionFailed},
+        {"changing needs If-Match", current, nil, model.ErrPreconditionNeeded},
+        {"already created", current, map[string]string{"If-None-Match": "*"}, model.ErrPreconditionFailed},
+        {"the current version", current, map[string]string{"If-Match": "W/" + etag}, nil},
+        {"any version", current, map[string]string{"If-Match": "*"}, nil},
+        {"an old version", current, map[string]string{"If-Match": `"0123456789abcdef"`}, model.ErrPreconditionFailed},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            headers := http.Header{}
+            for key, value := range test.headers {
+                headers.Set(key, value)
+            }
+            err := CheckVersion(test.current, &Request{Headers: headers})
+            if !errors.Is(err, test.err) {
+                t.Errorf("expected %v, got %v", test.err, err)
+            }
+        })
+    }
+}
@@ -6,6 +6,7 @@ import (
     "os"
     "strconv"
     "strings"
+    _ "time/tzdata" // project timezones are validated, also where the host lacks a zoneinfo database
 
     "github.com/Sensetif/sensetif-datasource/pkg/client"
     "github.com/Sensetif/sensetif-datasource/pkg/streaming"
@@ -19,16 +20,31 @@ func main() {
     pulsarClient := createPulsarClient()
     stripeClient := createStripeClient()
     clients := client.Clients{
-        Cassandra: &cassandraClient,
-        Pulsar:    &pulsarClient,
-        Stripe:    &stripeClient,
+        Cassandra:   &cassandraClient,
+        Pulsar:      &pulsarClient,
+        Stripe:      &stripeClient,
+        Commands:    client.CreateCommandTracker(),
+        Idempotency: client.CreateIdempotencyCache(),
+        Web:         client.CreateWebClient(),
+        Mqtt:        client.CreateMqttClient(),
+        Ttn:         client.CreateTtnClient(),
     }
+    clients.Search = client.CreateSearchIndexes(&cassandraClient, clients.Commands)
+    go clients.Commands.Listen(&pulsarClient)
+    authorizations := CreateAuthorizations(&cassandraClient)
+    rateLimiter := CreateRateLimiter(cassandraClient.GetCurrentLimits)
+    links = append(links, authorizations.Links()...)
+    links = append(links, rateLimiter.Links()...)
+    links = append(links, openApiLinks()...)
+    checkApiDocs(links)
     resourceHandler := ResourceHandler{
-        Clients: &clients,
+        Clients:        &clients,
+        Authorizations: authorizations,
+        RateLimiter:    rateLimiter,
     }
 
     ds := createDatasource(&cassandraClient, cassandraHosts)
-    sh := streaming.CreateStreamHandler(&pulsarClient)
+    sh := streaming.CreateStreamHandler(&pulsarClient, clients.Commands, rateLimiter)
     startServing(ds, &resourceHandler, &sh)
 }
 
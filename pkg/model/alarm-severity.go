@@ -0,0 +1,48 @@
+package model
+
+type AlarmSeverity string
+
+// AlarmSeverity values, in increasing order of severity.
+const (
+	NoAlarm  AlarmSeverity = "none"
+	Info     AlarmSeverity = "info"
+	Warning  AlarmSeverity = "warning"
+	Minor    AlarmSeverity = "minor"
+	Major    AlarmSeverity = "major"
+	Critical AlarmSeverity = "critical"
+)
+
+var (
+	AlarmSeverities = []AlarmSeverity{
+		NoAlarm,
+		Info,
+		Warning,
+		Minor,
+		Major,
+		Critical,
+	}
+)
+
+// AlarmSeverityOf maps the value of an alarm state, which is the index in AlarmSeverities, to the AlarmSeverity.
+// Unknown levels are clamped, so that a state that is newer than this code still counts as an alarm.
+func AlarmSeverityOf(level float64) AlarmSeverity {
+	index := int(level)
+	if index <= 0 {
+		return NoAlarm
+	}
+	if index >= len(AlarmSeverities) {
+		return Critical
+	}
+	return AlarmSeverities[index]
+}
+
+// WorstAlarmSeverity returns the most severe of the alarm states, NoAlarm if there are none.
+func WorstAlarmSeverity(states []TsPair) AlarmSeverity {
+	worst := 0.0
+	for _, state := range states {
+		if state.Value > worst {
+			worst = state.Value
+		}
+	}
+	return AlarmSeverityOf(worst)
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"errors"
+	"net/http"
 )
 
 var (
@@ -9,4 +10,47 @@ var (
 	ErrUnprocessableEntity = errors.New("wrong payload format")
 	ErrBadRequest          = errors.New("bad request")
 	ErrNotFound            = errors.New("not found")
+	ErrForbidden           = errors.New("forbidden")
+	ErrPlanLimitExceeded   = errors.New("plan limit exceeded")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrPreconditionNeeded  = errors.New("precondition required")
+	ErrTooManyRequests     = errors.New("too many requests")
+	ErrConflict            = errors.New("conflict")
+	ErrBadGateway          = errors.New("bad gateway")
 )
+
+// Problem is the JSON body of all error responses from the resource API.
+type Problem struct {
+	Status    int          `json:"status"`
+	Error     string       `json:"error"`
+	Message   string       `json:"message"`
+	RequestId string       `json:"requestId"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// HttpStatusOf maps the sentinel errors above to the HTTP status code. Errors that don't wrap any of them are
+// considered internal errors.
+func HttpStatusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnprocessableEntity):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrForbidden), errors.Is(err, ErrPlanLimitExceeded):
+		return http.StatusForbidden
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrPreconditionNeeded):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrBadGateway):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrTooManyRequests):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
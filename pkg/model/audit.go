@@ -0,0 +1,44 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry records one configuration change, with the state before and after the change. Before is null when
+// something is created or imported, and After is null when something is deleted.
+type AuditEntry struct {
+	Time      time.Time       `json:"time"`
+	RequestId string          `json:"requestId"`
+	Login     string          `json:"login"`
+	Email     string          `json:"email"`
+	Role      Role            `json:"role"`
+	Action    string          `json:"action"` // the route class, e.g. "datapoints.update"
+	Project   string          `json:"project,omitempty"`
+	Subsystem string          `json:"subsystem,omitempty"`
+	Datapoint string          `json:"datapoint,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+// AuditFilter selects entries in the audit trail. Empty fields match everything.
+type AuditFilter struct {
+	From      time.Time
+	To        time.Time
+	Login     string
+	Action    string
+	Project   string
+	Subsystem string
+	Datapoint string
+	Limit     int
+}
+
+func (f *AuditFilter) Matches(entry *AuditEntry) bool {
+	return !entry.Time.Before(f.From) &&
+		(f.To.IsZero() || !entry.Time.After(f.To)) &&
+		(f.Login == "" || f.Login == entry.Login) &&
+		(f.Action == "" || f.Action == entry.Action) &&
+		(f.Project == "" || f.Project == entry.Project) &&
+		(f.Subsystem == "" || f.Subsystem == entry.Subsystem) &&
+		(f.Datapoint == "" || f.Datapoint == entry.Datapoint)
+}
@@ -0,0 +1,114 @@
+package model
+
+import "time"
+
+// BundleVersion is incremented when the layout of ProjectBundle changes incompatibly.
+const BundleVersion = 1
+
+// RedactedSecret replaces credentials in exported bundles.
+const RedactedSecret = "<redacted>"
+
+// ProjectBundle is a complete project, with all its subsystems and datapoints, as a single document.
+type ProjectBundle struct {
+	Version    int               `json:"version"`
+	Exported   time.Time         `json:"exported"`
+	Project    ProjectSettings   `json:"project"`
+	Subsystems []SubsystemBundle `json:"subsystems"`
+}
+
+type SubsystemBundle struct {
+	Subsystem  SubsystemSettings   `json:"subsystem"`
+	Datapoints []DatapointSettings `json:"datapoints"`
+}
+
+// Redacted returns a copy of the datapoint where passwords, keys and tokens are replaced by RedactedSecret.
+func (dp DatapointSettings) Redacted() DatapointSettings {
+	switch ds := dp.Datasource.(type) {
+	case Ttnv3Datasource:
+		ds.AuthorizationKey = redact(ds.AuthorizationKey)
+		dp.Datasource = ds
+	case WebDatasource:
+		ds.Auth = redact(ds.Auth)
+		dp.Datasource = ds
+	case MqttDatasource:
+		ds.Password = redact(ds.Password)
+		dp.Datasource = ds
+	}
+	return dp
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedSecret
+}
+
+// WithSecretsFrom returns a copy of the datapoint where each RedactedSecret is replaced by the secret of current, so
+// that a redacted export can be imported again without losing credentials.
+func (dp DatapointSettings) WithSecretsFrom(current DatapointSettings) DatapointSettings {
+	switch ds := dp.Datasource.(type) {
+	case Ttnv3Datasource:
+		if old, ok := current.Datasource.(Ttnv3Datasource); ok && ds.AuthorizationKey == RedactedSecret {
+			ds.AuthorizationKey = old.AuthorizationKey
+		}
+		dp.Datasource = ds
+	case WebDatasource:
+		if old, ok := current.Datasource.(WebDatasource); ok && ds.Auth == RedactedSecret {
+			ds.Auth = old.Auth
+		}
+		dp.Datasource = ds
+	case MqttDatasource:
+		if old, ok := current.Datasource.(MqttDatasource); ok && ds.Password == RedactedSecret {
+			ds.Password = old.Password
+		}
+		dp.Datasource = ds
+	}
+	return dp
+}
+
+// RedactedField returns the name of the credential that is still RedactedSecret, or an empty string if there is none.
+// Such a datapoint has no saved secret to take the place of the placeholder.
+func (dp DatapointSettings) RedactedField() string {
+	switch ds := dp.Datasource.(type) {
+	case Ttnv3Datasource:
+		if ds.AuthorizationKey == RedactedSecret {
+			return "datasource.authorizationkey"
+		}
+	case WebDatasource:
+		if ds.Auth == RedactedSecret {
+			return "datasource.auth"
+		}
+	case MqttDatasource:
+		if ds.Password == RedactedSecret {
+			return "datasource.password"
+		}
+	}
+	return ""
+}
+
+type ChangeAction string
+
+// ChangeAction values
+const (
+	Create ChangeAction = "create"
+	Update ChangeAction = "update"
+	Delete ChangeAction = "delete"
+)
+
+// BundleChange is one step in a BundlePlan. Fields lists the changed settings of an update.
+type BundleChange struct {
+	Action ChangeAction `json:"action"`
+	Kind   string       `json:"kind"` // project, subsystem or datapoint
+	Path   string       `json:"path"`
+	Fields []string     `json:"fields,omitempty"`
+}
+
+// BundlePlan is the difference between an imported ProjectBundle and the current configuration.
+type BundlePlan struct {
+	Project   string         `json:"project"`
+	Applied   bool           `json:"applied"`
+	Changes   []BundleChange `json:"changes"`
+	Unchanged int            `json:"unchanged"`
+	Command   *CommandStatus `json:"command,omitempty"` // set when the plan is applied
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"sort"
+	"strings"
+)
+
+// CloneOptions tells how the clone endpoints copy a project or a subsystem with all its datapoints.
+type CloneOptions struct {
+	Name    string `json:"name"`              // of the copy
+	Title   string `json:"title,omitempty"`   // of the copy, by default the title of the original
+	Project string `json:"project,omitempty"` // of a subsystem copy, by default the project of the original
+
+	// NamePrefix rewrites the names of the subsystems and datapoints in the copy.
+	NamePrefix PrefixRewrite `json:"namePrefix"`
+
+	// ClearCredentials leaves out the passwords, keys and tokens of the datasources, which are otherwise copied.
+	ClearCredentials bool `json:"clearCredentials"`
+
+	// Substitutions replace text in the device of TTN datasources, the topic of MQTT datasources and the URL of web
+	// datasources, e.g. {"pump-49": "pump-50"}. Longer texts are replaced first.
+	Substitutions map[string]string `json:"substitutions,omitempty"`
+}
+
+// PrefixRewrite replaces From at the start of a name by To. Names that don't start with From are kept. An empty From
+// matches all names, so that To is added to them.
+type PrefixRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Apply returns the name with the prefix rewritten.
+func (r PrefixRewrite) Apply(name string) string {
+	if !strings.HasPrefix(name, r.From) {
+		return name
+	}
+	return r.To + name[len(r.From):]
+}
+
+// Substituter returns the function that applies the Substitutions, and reports which of them have been used.
+func (o *CloneOptions) Substituter() (substitute func(string) string, used map[string]bool) {
+	used = map[string]bool{}
+	keys := make([]string, 0, len(o.Substitutions))
+	for key := range o.Substitutions {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	substitute = func(text string) string {
+		var result strings.Builder
+		for i := 0; i < len(text); {
+			matched := false
+			for _, key := range keys {
+				if strings.HasPrefix(text[i:], key) {
+					result.WriteString(o.Substitutions[key])
+					used[key] = true
+					i += len(key)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				result.WriteByte(text[i])
+				i++
+			}
+		}
+		return result.String()
+	}
+	return substitute, used
+}
+
+// Cloned returns a copy of the datapoint in another subsystem, with the options applied to its name and datasource.
+func (dp DatapointSettings) Cloned(project string, subsystem string, options *CloneOptions, substitute func(string) string) DatapointSettings {
+	dp.Project = project
+	dp.Subsystem = subsystem
+	dp.Name = options.NamePrefix.Apply(dp.Name)
+	switch ds := dp.Datasource.(type) {
+	case Ttnv3Datasource:
+		ds.Device = substitute(ds.Device)
+		if options.ClearCredentials {
+			ds.AuthorizationKey = ""
+		}
+		dp.Datasource = ds
+	case WebDatasource:
+		ds.URL = substitute(ds.URL)
+		if options.ClearCredentials {
+			ds.Auth = ""
+		}
+		dp.Datasource = ds
+	case MqttDatasource:
+		ds.Topic = substitute(ds.Topic)
+		if options.ClearCredentials {
+			ds.Password = ""
+		}
+		dp.Datasource = ds
+	}
+	return dp
+}
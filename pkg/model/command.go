@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// CommandState is how far the backend has come with a configuration command.
+type CommandState string
+
+const (
+	CommandPending CommandState = "pending"
+	CommandApplied CommandState = "applied"
+	CommandFailed  CommandState = "failed"
+)
+
+// CommandStatus follows a configuration change from the resource call until the backend has applied it. A command
+// consists of one or more messages on the ConfigurationTopic, e.g. one per change of an imported bundle, and is
+// applied when all of them are.
+type CommandStatus struct {
+	Id        string       `json:"id"`
+	RequestId string       `json:"requestId,omitempty"`
+	Operation string       `json:"operation"`
+	State     CommandState `json:"state"`
+	Messages  int          `json:"messages"`
+	Applied   int          `json:"applied"`
+	Failed    int          `json:"failed"`
+	Errors    []string     `json:"errors,omitempty"`
+	Submitted time.Time    `json:"submitted"`
+	Updated   time.Time    `json:"updated"`
+}
+
+// Update sets the State from the number of applied and failed messages.
+func (s *CommandStatus) Update() {
+	switch {
+	case s.Failed > 0:
+		s.State = CommandFailed
+	case s.Applied >= s.Messages:
+		s.State = CommandApplied
+	default:
+		s.State = CommandPending
+	}
+}
+
+// CommandResult is published by the backend on the CommandResultTopic, once for each configuration message that had
+// a CommandIdProperty.
+type CommandResult struct {
+	OrgId     int64  `json:"orgId"`
+	CommandId string `json:"commandId"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
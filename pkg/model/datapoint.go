@@ -2,9 +2,12 @@ package model
 
 import (
     "encoding/binary"
+    "encoding/json"
+    "github.com/Sensetif/sensetif-datasource/pkg/scalefunc"
     "github.com/gocql/gocql"
     "math"
     "strconv"
+    "strings"
     "time"
 )
 
@@ -35,6 +38,38 @@ type DatapointSettings struct {
     Datasource interface{}  `json:"datasource"` // either a Ttnv3Datasource or a WebDatasource or a MqttDatasource depending on SourceType
 }
 
+// UnmarshalJSON decodes the Datasource into the struct that matches SourceType, instead of a generic map.
+func (dp *DatapointSettings) UnmarshalJSON(data []byte) error {
+    type settings DatapointSettings
+    aux := struct {
+        *settings
+        Datasource json.RawMessage `json:"datasource"`
+    }{settings: (*settings)(dp)}
+    err := json.Unmarshal(data, &aux)
+    if err != nil {
+        return err
+    }
+    dp.Datasource = nil
+    if len(aux.Datasource) == 0 || string(aux.Datasource) == "null" {
+        return nil
+    }
+    switch dp.SourceType {
+    case Web:
+        var ds WebDatasource
+        err = json.Unmarshal(aux.Datasource, &ds)
+        dp.Datasource = ds
+    case Ttnv3:
+        var ds Ttnv3Datasource
+        err = json.Unmarshal(aux.Datasource, &ds)
+        dp.Datasource = ds
+    case Mqtt:
+        var ds MqttDatasource
+        err = json.Unmarshal(aux.Datasource, &ds)
+        dp.Datasource = ds
+    }
+    return err
+}
+
 type JournalEntry struct {
     Added time.Time `json:"added"`
     Value string    `json:"value"`
@@ -57,6 +92,15 @@ type Processing struct {
     ScaleFunc string  `json:"scalefunc"` // Allow all characters
 }
 
+// ScaleFunction compiles the ScaleFunc, which is applied when the timeseries is queried. It returns nil if there is
+// none.
+func (p *Processing) ScaleFunction() (*scalefunc.Func, error) {
+    if strings.TrimSpace(p.ScaleFunc) == "" {
+        return nil, nil
+    }
+    return scalefunc.Compile(p.ScaleFunc)
+}
+
 func (p *Processing) UnmarshalUDT(name string, info gocql.TypeInfo, data []byte) error {
     switch name {
     case "unit":
@@ -151,7 +195,7 @@ type WebDatasource struct {
     Auth string `json:"auth"`
 
     Format              OriginDocumentFormat `json:"format"`
-    ValueExpression     string               `json:"valueExpression"` // if format==xml, then xpath. if format==json, then jsonpath. If there is library available for validation, do that. If not, put in a function and we figure that out later.
+    ValueExpression     string               `json:"valueExpression"` // if format==xml, then xpath. if format==json, then jsonpath. Validated with the docpath package.
     TimestampType       TimestampType        `json:"timestampType"`
     TimestampExpression string               `json:"timestampExpression"` // if format==xml, then xpath. if format==json, then jsonpath.
 }
@@ -215,6 +259,19 @@ const (
     alis  MqttProtocol = "alis"
 )
 
+var (
+    MqttProtocols = []MqttProtocol{
+        mqtt,
+        mqtts,
+        tcp,
+        tls,
+        ws,
+        wss,
+        wxs,
+        alis,
+    }
+)
+
 type MqttDatasource struct {
     Protocol            MqttProtocol         `json:"protocol"`
     Address             string               `json:"address"`
@@ -0,0 +1,123 @@
+package model
+
+import "time"
+
+// Stages of a datasource test, telling where a TestError occurred.
+const (
+	StageFetch     = "fetch"
+	StageConnect   = "connect"
+	StageSubscribe = "subscribe"
+	StageReceive   = "receive"
+	StageParse     = "parse"
+	StageValue     = "value"
+	StageTimestamp = "timestamp"
+	StageScale     = "scale"
+)
+
+// TestError is a problem found when testing a datasource. Field is the setting that caused it, if any, and Position is
+// the offset into that setting's expression of a syntax error. Suggestions are paths in the document that are like an
+// expression that didn't select a value.
+type TestError struct {
+	Stage       string   `json:"stage"`
+	Field       string   `json:"field,omitempty"`
+	Position    *int     `json:"position,omitempty"`
+	Message     string   `json:"message"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Extraction is the value and timestamp picked out of a document with the ValueExpression and TimestampExpression
+// of a datasource. Raw values are as found in the document, before they are parsed. Warnings are about expressions
+// that are valid but can only be tried by the backend, which don't make the test fail.
+type Extraction struct {
+	Value        *float64    `json:"value,omitempty"`
+	RawValue     interface{} `json:"rawValue,omitempty"`
+	Timestamp    *time.Time  `json:"timestamp,omitempty"`
+	RawTimestamp string      `json:"rawTimestamp,omitempty"`
+	Errors       []TestError `json:"errors"`
+	Warnings     []TestError `json:"warnings,omitempty"`
+}
+
+// WebTestResult is the outcome of fetching the document of a WebDatasource and extracting the value from it. Document
+// holds the start of the document, to show what the expressions are evaluated against.
+type WebTestResult struct {
+	Ok          bool   `json:"ok"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int    `json:"size"`
+	DurationMs  int64  `json:"durationMs"`
+	Document    string `json:"document,omitempty"`
+	Extraction
+}
+
+// MqttTestMessage is a message received when testing an MqttDatasource, with the value and timestamp extracted from it.
+// Payload holds the start of the payload.
+type MqttTestMessage struct {
+	Topic    string    `json:"topic"`
+	Payload  string    `json:"payload"`
+	Size     int       `json:"size"`
+	Retained bool      `json:"retained"`
+	Received time.Time `json:"received"`
+	Extraction
+}
+
+// MqttTestResult is the outcome of subscribing to the topic of an MqttDatasource for a while. Errors are the problems
+// of connecting and subscribing; those of the messages are in each message.
+type MqttTestResult struct {
+	Ok         bool              `json:"ok"`
+	Connected  bool              `json:"connected"`
+	Subscribed bool              `json:"subscribed"`
+	DurationMs int64             `json:"durationMs"`
+	Messages   []MqttTestMessage `json:"messages"`
+	Errors     []TestError       `json:"errors"`
+}
+
+// MaxSampleDocumentSize is the largest document that expressions can be tried on, the same as web datasources fetch.
+const MaxSampleDocumentSize = 1 << 20
+
+// ExpressionTest is a sample document with the expressions of a web or MQTT datasource to try on it. TimestampType
+// defaults to PollTime.
+type ExpressionTest struct {
+	Format              OriginDocumentFormat `json:"format"`
+	ValueExpression     string               `json:"valueExpression"`
+	TimestampType       TimestampType        `json:"timestampType"`
+	TimestampExpression string               `json:"timestampExpression"`
+	Document            string               `json:"document"`
+}
+
+// PathCandidate is the path to a value in a sample document, which an expression could select.
+type PathCandidate struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// ExpressionTestResult is the outcome of trying the expressions of an ExpressionTest. Candidates are the paths to the
+// values in the document, to pick expressions from.
+type ExpressionTestResult struct {
+	Ok bool `json:"ok"`
+	Extraction
+	Candidates []PathCandidate `json:"candidates"`
+}
+
+// MaxScaleFuncTestInputs is the most inputs a ScaleFuncTest may have.
+const MaxScaleFuncTestInputs = 1000
+
+// ScaleFuncTest is a custom scaling function, Processing.ScaleFunc, with the inputs to try it on.
+type ScaleFuncTest struct {
+	ScaleFunc string    `json:"scalefunc"`
+	Inputs    []float64 `json:"inputs"`
+}
+
+// ScaleFuncSample is the output of a scaling function for one input, or the error if it isn't defined for it.
+type ScaleFuncSample struct {
+	Input  float64    `json:"input"`
+	Output *float64   `json:"output,omitempty"`
+	Error  *TestError `json:"error,omitempty"`
+}
+
+// ScaleFuncTestResult is the outcome of a ScaleFuncTest. Errors are the problems of compiling the function; those of
+// evaluating it are in each sample.
+type ScaleFuncTestResult struct {
+	Ok      bool              `json:"ok"`
+	Samples []ScaleFuncSample `json:"samples"`
+	Errors  []TestError       `json:"errors"`
+}
@@ -1,8 +1,48 @@
 package model
 
+import (
+	"fmt"
+
+	"github.com/Sensetif/sensetif-datasource/pkg/docpath"
+)
+
 type OriginDocumentFormat string
 
 const (
 	JSON OriginDocumentFormat = "jsondoc"
 	XML  OriginDocumentFormat = "xmldoc"
 )
+
+// Parse reads a document of the format, for the paths returned by Compile to select from.
+func (f OriginDocumentFormat) Parse(data []byte) (interface{}, error) {
+	switch f {
+	case JSON:
+		return docpath.ParseJson(data)
+	case XML:
+		return docpath.ParseXml(data)
+	}
+	return nil, fmt.Errorf("unknown document format \"%s\"", f)
+}
+
+// Compile compiles a JSONPath or an XPath, depending on the format. Syntax errors are *docpath.SyntaxError, and
+// valid expressions that can't be evaluated here *docpath.UnsupportedError.
+func (f OriginDocumentFormat) Compile(expression string) (docpath.Path, error) {
+	switch f {
+	case JSON:
+		return docpath.CompileJsonPath(expression)
+	case XML:
+		return docpath.CompileXPath(expression)
+	}
+	return nil, fmt.Errorf("unknown document format \"%s\"", f)
+}
+
+// Candidates returns the paths to the values of a document returned by Parse.
+func (f OriginDocumentFormat) Candidates(document interface{}) []docpath.Candidate {
+	switch f {
+	case JSON:
+		return docpath.JsonCandidates(document)
+	case XML:
+		return docpath.XmlCandidates(document)
+	}
+	return []docpath.Candidate{}
+}
@@ -0,0 +1,117 @@
+package model
+
+import "time"
+
+type Freshness string
+
+// Freshness values
+const (
+	// Healthy The latest sample is younger than LateFactor poll intervals.
+	Healthy Freshness = "healthy"
+
+	// Late The latest sample is older than LateFactor, but younger than StaleFactor poll intervals.
+	Late Freshness = "late"
+
+	// Stale The latest sample is older than StaleFactor poll intervals, or there are no samples at all.
+	Stale Freshness = "stale"
+)
+
+const LateFactor = 1.5
+
+const StaleFactor = 3.0
+
+type DatapointFreshness struct {
+	Project    string       `json:"project"`
+	Subsystem  string       `json:"subsystem"`
+	Name       string       `json:"name"`
+	Interval   PollInterval `json:"pollinterval"`
+	LastSample *time.Time   `json:"lastSample"` // nil if no sample was found
+	Age        int64        `json:"age"`        // seconds since LastSample, -1 if no sample was found
+	Status     Freshness    `json:"status"`
+}
+
+type FreshnessCounts struct {
+	Healthy int `json:"healthy"`
+	Late    int `json:"late"`
+	Stale   int `json:"stale"`
+}
+
+type SubsystemFreshness struct {
+	Project   string          `json:"project"`
+	Subsystem string          `json:"subsystem"`
+	Counts    FreshnessCounts `json:"counts"`
+}
+
+type FreshnessReport struct {
+	Generated  time.Time            `json:"generated"`
+	Counts     FreshnessCounts      `json:"counts"`
+	Subsystems []SubsystemFreshness `json:"subsystems"`
+	Stale      []DatapointFreshness `json:"stale"`
+	Late       []DatapointFreshness `json:"late"`
+}
+
+// Add counts the given status.
+func (c *FreshnessCounts) Add(status Freshness) {
+	switch status {
+	case Healthy:
+		c.Healthy++
+	case Late:
+		c.Late++
+	case Stale:
+		c.Stale++
+	}
+}
+
+// FreshnessOf classifies the age of the latest sample against the poll interval of the datapoint.
+func FreshnessOf(interval PollInterval, age time.Duration) Freshness {
+	switch {
+	case age <= scaled(interval, LateFactor):
+		return Healthy
+	case age <= StaleThreshold(interval):
+		return Late
+	default:
+		return Stale
+	}
+}
+
+// StaleThreshold is the age after which the latest sample of a datapoint is considered stale.
+func StaleThreshold(interval PollInterval) time.Duration {
+	return scaled(interval, StaleFactor)
+}
+
+func scaled(interval PollInterval, factor float64) time.Duration {
+	nominal := interval.Duration()
+	if nominal == 0 {
+		nominal = One_hour.Duration()
+	}
+	return time.Duration(float64(nominal) * factor)
+}
+
+// NewFreshnessReport summarizes the evaluated datapoints per subsystem, and lists the stale and late ones.
+func NewFreshnessReport(generated time.Time, datapoints []DatapointFreshness) FreshnessReport {
+	report := FreshnessReport{
+		Generated:  generated,
+		Subsystems: make([]SubsystemFreshness, 0),
+		Stale:      make([]DatapointFreshness, 0),
+		Late:       make([]DatapointFreshness, 0),
+	}
+	index := map[string]int{}
+	for _, dp := range datapoints {
+		report.Counts.Add(dp.Status)
+		key := dp.Project + "/" + dp.Subsystem
+		i, found := index[key]
+		if !found {
+			i = len(report.Subsystems)
+			index[key] = i
+			report.Subsystems = append(report.Subsystems, SubsystemFreshness{Project: dp.Project, Subsystem: dp.Subsystem})
+		}
+		report.Subsystems[i].Counts.Add(dp.Status)
+		switch dp.Status {
+		case Stale:
+			report.Stale = append(report.Stale, dp)
+		case Late:
+			report.Late = append(report.Late, dp)
+		}
+	}
+	return report
+}
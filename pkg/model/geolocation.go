@@ -0,0 +1,65 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidGeolocation = errors.New("invalid geolocation")
+
+// Geolocation is the typed form of ProjectSettings.Geolocation, which is stored as "{latitude},{longitude}" or
+// "{latitude},{longitude},{altitude}" in decimal degrees and meters.
+type Geolocation struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+// ParseGeolocation parses and validates the textual geolocation. A blank text is reported as ErrInvalidGeolocation
+// like any other malformed value, so callers that allow blank must check for that first.
+func ParseGeolocation(text string) (Geolocation, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Geolocation{}, fmt.Errorf("%w: expected \"latitude,longitude[,altitude]\" but got \"%s\"", ErrInvalidGeolocation, text)
+	}
+	lat, err := parseCoordinate("latitude", parts[0], 90)
+	if err != nil {
+		return Geolocation{}, err
+	}
+	lng, err := parseCoordinate("longitude", parts[1], 180)
+	if err != nil {
+		return Geolocation{}, err
+	}
+	result := Geolocation{Latitude: lat, Longitude: lng}
+	if len(parts) == 3 {
+		alt, err := strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+		if err != nil || math.IsNaN(alt) || math.IsInf(alt, 0) {
+			return Geolocation{}, fmt.Errorf("%w: altitude \"%s\" is not a number", ErrInvalidGeolocation, parts[2])
+		}
+		result.Altitude = &alt
+	}
+	return result, nil
+}
+
+func parseCoordinate(name string, text string, limit float64) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	// ParseFloat accepts "NaN" and "Inf", and NaN would pass the range check below
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%w: %s \"%s\" is not a number", ErrInvalidGeolocation, name, text)
+	}
+	if value < -limit || value > limit {
+		return 0, fmt.Errorf("%w: %s %g is outside [-%g,%g]", ErrInvalidGeolocation, name, value, limit, limit)
+	}
+	return value, nil
+}
+
+func (g Geolocation) String() string {
+	result := strconv.FormatFloat(g.Latitude, 'f', -1, 64) + "," + strconv.FormatFloat(g.Longitude, 'f', -1, 64)
+	if g.Altitude != nil {
+		result = result + "," + strconv.FormatFloat(*g.Altitude, 'f', -1, 64)
+	}
+	return result
+}
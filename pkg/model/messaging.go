@@ -8,6 +8,12 @@ const NotificationNamespace = Tenant + "/notifications"
 
 const ConfigurationTopic = "configurations"
 
+// CommandResultTopic is where the backend reports the outcome of each configuration message that carries a
+// CommandIdProperty.
+const CommandResultTopic = "configuration_results"
+
+const CommandIdProperty = "commandId"
+
 const PaymentsTopic = "payments"
 
 const PaymentErrorTopic = "payment_errors"
@@ -15,3 +21,6 @@ const PaymentErrorTopic = "payment_errors"
 const TimeseriesTopic = "timeseries"
 
 const NotificationTopics = NotificationNamespace + "/notifications-"
+
+// AuditTopics is followed by the organization id, so that each organization has its own audit trail.
+const AuditTopics = "audit-"
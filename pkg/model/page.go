@@ -0,0 +1,17 @@
+package model
+
+// ResultPage is one page of a list as read from Cassandra. Total is the size of the whole list, and PageState is
+// empty after the last page.
+type ResultPage struct {
+	Items     interface{}
+	Total     int
+	PageState []byte
+}
+
+// Page is the body of a list response when a limit or cursor is given. Next is the cursor of the following page, and
+// is left out on the last page.
+type Page struct {
+	Items interface{} `json:"items"`
+	Total int         `json:"total"`
+	Next  string      `json:"next,omitempty"`
+}
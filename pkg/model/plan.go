@@ -16,3 +16,12 @@ type PlanLimits struct {
 	MinPollInterval string   `json:"minPollInterval"`
 	Permissions     []string `json:"permissions"`
 }
+
+// PlanUsage is the current usage of an organization, compared to its PlanLimits.
+type PlanUsage struct {
+	Limits              PlanLimits   `json:"limits"`
+	Datapoints          uint64       `json:"datapoints"`
+	FastestPollInterval PollInterval `json:"fastestPollInterval"`
+	LongestTimeToLive   TimeToLive   `json:"longestTimeToLive"`
+	Violations          []FieldError `json:"violations"` // datapoints configured beyond the limits, e.g. after a downgrade
+}
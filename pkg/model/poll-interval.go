@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type PollInterval string
 
 // PollInterval values
@@ -37,3 +39,26 @@ var (
 		Monthly,
 	}
 )
+
+var pollDurations = map[PollInterval]time.Duration{
+	One_minute:      time.Minute,
+	Five_minutes:    5 * time.Minute,
+	Ten_minutes:     10 * time.Minute,
+	Fifteen_minutes: 15 * time.Minute,
+	Twenty_minutes:  20 * time.Minute,
+	Thirty_minutes:  30 * time.Minute,
+	One_hour:        time.Hour,
+	Two_hours:       2 * time.Hour,
+	Three_hours:     3 * time.Hour,
+	Six_hours:       6 * time.Hour,
+	Twelve_hours:    12 * time.Hour,
+	One_day:         24 * time.Hour,
+	Weekly:          7 * 24 * time.Hour,
+	Monthly:         31 * 24 * time.Hour,
+}
+
+// Duration returns the nominal time between two polls, or zero if the PollInterval is unknown.
+// Monthly is counted as 31 days, so that no month is considered late.
+func (p PollInterval) Duration() time.Duration {
+	return pollDurations[p]
+}
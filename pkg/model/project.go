@@ -6,5 +6,10 @@ type ProjectSettings struct {
 	City        string `json:"city"`        // allow all characters
 	Country     string `json:"country"`     // country list?
 	Timezone    string `json:"timezone"`    // "UTC" or {continent}/{city}, ex Europe/Stockholm
-	Geolocation string `json:"geolocation"` // geo coordinates
+	Geolocation string `json:"geolocation"` // geo coordinates, see Geolocation
+}
+
+// Location parses the Geolocation of the project.
+func (p *ProjectSettings) Location() (Geolocation, error) {
+	return ParseGeolocation(p.Geolocation)
 }
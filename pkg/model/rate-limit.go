@@ -0,0 +1,78 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Route classes that have no RateLimit of their own use DefaultWriteRateLimit if they change something, and otherwise
+// DefaultRateLimit. Streams are limited per path, with the class "stream:{path}".
+const (
+	DefaultRateLimit      = "default"
+	DefaultWriteRateLimit = "default.write"
+	StreamRateLimitPrefix = "stream:"
+)
+
+// RateLimit is a token bucket, which is refilled with Rate tokens per second up to Burst. Each call takes one token.
+// With PerDatapoint, the Rate is multiplied by the MaxDatapoints of the plan of the organization.
+type RateLimit struct {
+	Rate         float64 `json:"rate"`
+	Burst        float64 `json:"burst"`
+	PerDatapoint bool    `json:"perDatapoint,omitempty"`
+}
+
+// DefaultRateLimits can be changed with SENSETIF_RATE_LIMITS, which is a JSON object from route class to RateLimit.
+var DefaultRateLimits = map[string]RateLimit{
+	DefaultRateLimit:      {Rate: 20, Burst: 100},
+	DefaultWriteRateLimit: {Rate: 2, Burst: 20},
+	// about one update per datapoint and minute, which is the fastest poll interval of most plans
+	"timeseries.update":  {Rate: 1.0 / 60, Burst: 60, PerDatapoint: true},
+	"imports.timeseries": {Rate: 0.1, Burst: 3},
+	// tests connect to servers of the users, and an MQTT test holds its connection for up to 30 seconds
+	"tests.web":                              {Rate: 0.5, Burst: 10},
+	"tests.mqtt":                             {Rate: 0.2, Burst: 5},
+	StreamRateLimitPrefix + "_alarms/status": {Rate: 1, Burst: 10},
+	// expressions are tried as they are typed, without leaving the server
+	"tests.expressions": {Rate: 10, Burst: 50},
+	"tests.scalefunc":   {Rate: 10, Burst: 50},
+}
+
+// ForPlan returns the limit with the Rate scaled to the plan.
+func (l RateLimit) ForPlan(limits PlanLimits) RateLimit {
+	if l.PerDatapoint {
+		l.Rate = l.Rate * math.Max(1, float64(limits.MaxDatapoints))
+		l.PerDatapoint = false
+	}
+	return l
+}
+
+// RateLimitStatus shows the state of the bucket of a route class of an organization, and how many calls it has let
+// through and rejected since the plugin started.
+type RateLimitStatus struct {
+	Route    string  `json:"route"`
+	Rate     float64 `json:"rate"`
+	Burst    float64 `json:"burst"`
+	Tokens   float64 `json:"tokens"`
+	Allowed  uint64  `json:"allowed"`
+	Rejected uint64  `json:"rejected"`
+}
+
+// RateLimitError tells how long to wait before the route can be called again.
+type RateLimitError struct {
+	Route      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %d seconds", ErrTooManyRequests.Error(), e.Route, e.RetryAfterSeconds())
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+// RetryAfterSeconds is the value of the Retry-After header, rounded up to whole seconds.
+func (e *RateLimitError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
@@ -0,0 +1,43 @@
+package model
+
+// Role is the Grafana organization role of a user.
+type Role string
+
+// Role values, in increasing order of privileges.
+const (
+	NoRole Role = "None"
+	Viewer Role = "Viewer"
+	Editor Role = "Editor"
+	Admin  Role = "Admin"
+)
+
+var (
+	Roles = []Role{
+		NoRole,
+		Viewer,
+		Editor,
+		Admin,
+	}
+)
+
+// Includes tells whether a user with this Role has the privileges of the required Role.
+func (r Role) Includes(required Role) bool {
+	return indexOfRole(r) >= indexOfRole(required) && indexOfRole(required) >= 0
+}
+
+func indexOfRole(r Role) int {
+	for i, v := range Roles {
+		if v == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// RouteAuthorization is the effective Role required for a class of resource routes in an organization.
+type RouteAuthorization struct {
+	Route      string `json:"route"`
+	Role       Role   `json:"role"`
+	Default    Role   `json:"default"`
+	Overridden bool   `json:"overridden"`
+}
@@ -38,3 +38,19 @@ const (
 	// KtoF Input Kelvin, output Fahrenheit
 	KtoF Scaling = "kToF"
 )
+
+var (
+	Scalings = []Scaling{
+		Lin,
+		Ln,
+		Exp,
+		Rad,
+		Deg,
+		FtoC,
+		CtoF,
+		KtoC,
+		CtoK,
+		FtoK,
+		KtoF,
+	}
+)
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Weights of where a search term is found. Settings of the parents count less than the entry's own, but make a query
+// like "co2 building 4" find the co2 datapoint in the subsystem titled "Building 4".
+const (
+	NameWeight     = 1.0
+	TitleWeight    = 1.0
+	LocationWeight = 0.7
+	AddressWeight  = 0.6
+	UnitWeight     = 0.5
+	ParentWeight   = 0.4
+)
+
+// Words that are common in questions, but say nothing about what is looked for.
+var searchStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "at": true, "for": true, "in": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "with": true,
+}
+
+// SearchField is one searchable text of a SearchEntry.
+type SearchField struct {
+	Name   string
+	Text   string
+	Weight float64
+}
+
+// SearchEntry is a project, subsystem or datapoint in the search index. Path is the same as in the resource API, e.g.
+// {project}/{subsystem}/{datapoint}.
+type SearchEntry struct {
+	Kind   string
+	Path   string
+	Title  string
+	Fields []SearchField
+}
+
+type SearchHit struct {
+	Kind    string   `json:"kind"` // project, subsystem or datapoint
+	Path    string   `json:"path"` // {project}, {project}/{subsystem} or {project}/{subsystem}/{datapoint}
+	Title   string   `json:"title"`
+	Score   float64  `json:"score"`
+	Matches []string `json:"matches"` // the fields that matched
+}
+
+type SearchResult struct {
+	Query   string      `json:"query"`
+	Total   int         `json:"total"`
+	Hits    []SearchHit `json:"hits"`
+	Indexed time.Time   `json:"indexed"`
+}
+
+// SearchTerms splits the query into lower case words, leaving out stop words.
+func SearchTerms(query string) []string {
+	terms := make([]string, 0)
+	for _, word := range searchWords(query) {
+		if !searchStopWords[word] {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Score returns how well the entry matches the terms, and which fields matched. Each term counts with its best match,
+// and the sum is scaled by the share of terms that matched at all, so that entries matching more of the query rank
+// higher. It is zero if no term matches.
+func (e *SearchEntry) Score(terms []string) (float64, []string) {
+	if len(terms) == 0 {
+		return 0, nil
+	}
+	total := 0.0
+	matchedTerms := 0
+	matchedFields := map[string]bool{}
+	for _, term := range terms {
+		best := 0.0
+		bestField := ""
+		for _, field := range e.Fields {
+			score := termScore(term, field.Text) * field.Weight
+			if score > best {
+				best = score
+				bestField = field.Name
+			}
+		}
+		if best > 0 {
+			total += best
+			matchedTerms++
+			matchedFields[bestField] = true
+		}
+	}
+	matches := make([]string, 0, len(matchedFields))
+	for field := range matchedFields {
+		matches = append(matches, field)
+	}
+	sort.Strings(matches)
+	return total * float64(matchedTerms) / float64(len(terms)), matches
+}
+
+// termScore is 1 for a whole word, and less for a word prefix, a substring or a word with a typo.
+func termScore(term string, text string) float64 {
+	if text == "" {
+		return 0
+	}
+	best := 0.0
+	for _, word := range searchWords(text) {
+		switch {
+		case word == term:
+			return 1.0
+		case strings.HasPrefix(word, term):
+			best = maxScore(best, 0.8)
+		case len(term) >= 4 && editDistance(term, word) <= len(term)/4:
+			best = maxScore(best, 0.4)
+		}
+	}
+	if best < 0.6 && strings.Contains(strings.ToLower(text), term) {
+		best = 0.6
+	}
+	return best
+}
+
+func maxScore(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minOf(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minOf(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+)
+
+// TemplateProjectParameter is the placeholder that is always given, with the name of the project that the template is
+// instantiated in.
+const TemplateProjectParameter = "project"
+
+var placeholderRegexp = regexp.MustCompile(`\{\{\s*([a-zA-Z][a-zA-Z0-9_]*)\s*\}\}`)
+
+// SubsystemTemplate describes a subsystem with its datapoints, like a kit of sensors that is installed over and over.
+// All texts of the Subsystem and the Datapoints may contain placeholders like {{device}}, which are replaced by the
+// values given when the template is instantiated. The project of the subsystem and datapoints is set then too.
+type SubsystemTemplate struct {
+	Name        string              `json:"name"`
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	Parameters  []TemplateParameter `json:"parameters"`
+	Subsystem   SubsystemSettings   `json:"subsystem"`
+	Datapoints  []DatapointSettings `json:"datapoints"`
+}
+
+// TemplateParameter is a placeholder of a SubsystemTemplate. Parameters without a Default must be given a value.
+type TemplateParameter struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Default     *string `json:"default,omitempty"`
+}
+
+// TemplateInstance is what a SubsystemTemplate is instantiated with.
+type TemplateInstance struct {
+	Project string            `json:"project"`
+	Values  map[string]string `json:"values"`
+}
+
+// Placeholders returns the names of the placeholders used in the template, sorted.
+func (t *SubsystemTemplate) Placeholders() []string {
+	found := map[string]bool{}
+	_, _ = t.expand(func(text string) string {
+		for _, match := range placeholderRegexp.FindAllStringSubmatch(text, -1) {
+			found[match[1]] = true
+		}
+		return text
+	})
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Instantiate returns the subsystem and datapoints of the template in the project, with the placeholders replaced by
+// the values of the instance, or the defaults of the parameters. The result is validated.
+func (t *SubsystemTemplate) Instantiate(instance TemplateInstance) (SubsystemBundle, error) {
+	errs := &ValidationError{}
+	validateName(errs, "project", instance.Project, projectNameRegexp)
+	values := map[string]string{TemplateProjectParameter: instance.Project}
+	declared := map[string]bool{}
+	for _, parameter := range t.Parameters {
+		declared[parameter.Name] = true
+		value, given := instance.Values[parameter.Name]
+		switch {
+		case given:
+			values[parameter.Name] = value
+		case parameter.Default != nil:
+			values[parameter.Name] = *parameter.Default
+		default:
+			errs.Add("values."+parameter.Name, "must be given")
+		}
+	}
+	names := make([]string, 0, len(instance.Values))
+	for name := range instance.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !declared[name] {
+			errs.Add("values."+name, "is not a parameter of template %s", t.Name)
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return SubsystemBundle{}, err
+	}
+	sb, err := t.instantiate(instance.Project, values)
+	if err != nil {
+		return SubsystemBundle{}, err
+	}
+	return sb, validateInstance(&sb)
+}
+
+// instantiate replaces the placeholders without any checks.
+func (t *SubsystemTemplate) instantiate(project string, values map[string]string) (SubsystemBundle, error) {
+	sb, err := t.expand(func(text string) string {
+		return placeholderRegexp.ReplaceAllStringFunc(text, func(placeholder string) string {
+			name := placeholderRegexp.FindStringSubmatch(placeholder)[1]
+			if value, found := values[name]; found {
+				return value
+			}
+			return placeholder
+		})
+	})
+	if err != nil {
+		return SubsystemBundle{}, err
+	}
+	sb.Subsystem.Project = project
+	for i := range sb.Datapoints {
+		sb.Datapoints[i].Project = project
+		sb.Datapoints[i].Subsystem = sb.Subsystem.Name
+	}
+	return sb, nil
+}
+
+// expand applies replace to every text of the subsystem and datapoints. It works on the JSON form, so that all texts
+// of all kinds of datasources are covered.
+func (t *SubsystemTemplate) expand(replace func(string) string) (SubsystemBundle, error) {
+	var sb SubsystemBundle
+	document, err := json.Marshal(SubsystemBundle{Subsystem: t.Subsystem, Datapoints: t.Datapoints})
+	if err != nil {
+		return sb, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(document, &generic)
+	if err != nil {
+		return sb, err
+	}
+	document, err = json.Marshal(replaceTexts(generic, replace))
+	if err != nil {
+		return sb, err
+	}
+	err = json.Unmarshal(document, &sb)
+	if sb.Datapoints == nil {
+		sb.Datapoints = make([]DatapointSettings, 0)
+	}
+	return sb, err
+}
+
+func replaceTexts(value interface{}, replace func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return replace(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = replaceTexts(item, replace)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = replaceTexts(item, replace)
+		}
+	}
+	return value
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type TimeToLive string
 
 // TimeToLive values
@@ -32,3 +34,27 @@ var (
 		K,
 	}
 )
+
+// Exceeds tells whether the TimeToLive is longer than the given one. Unknown values never exceed.
+func (t TimeToLive) Exceeds(other TimeToLive) bool {
+	return indexOfTimeToLive(t) > indexOfTimeToLive(other) && indexOfTimeToLive(other) >= 0
+}
+
+func indexOfTimeToLive(t TimeToLive) int {
+	for i, v := range TimeToLives {
+		if v == t {
+			return i
+		}
+	}
+	return -1
+}
+
+var timeToLiveDays = map[TimeToLive]int{
+	A: 10, B: 40, C: 100, D: 200, E: 400, F: 750, G: 1200, H: 1500, I: 1900, J: 3700,
+}
+
+// Duration returns how long samples are kept, and false if they are kept forever or the value is unknown.
+func (t TimeToLive) Duration() (time.Duration, bool) {
+	days, found := timeToLiveDays[t]
+	return time.Duration(days) * 24 * time.Hour, found
+}
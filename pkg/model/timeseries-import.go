@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// RowError is a problem with one cell of an uploaded CSV file. Rows are numbered from 1, including the header.
+type RowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column"`
+	Message string `json:"message"`
+}
+
+type ImportedSeries struct {
+	Datapoint string    `json:"datapoint"` // {project}/{subsystem}/{datapoint}
+	Column    string    `json:"column"`
+	Samples   int       `json:"samples"`
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+}
+
+// TimeseriesImportSummary is the outcome of a CSV upload. In a dry run nothing is Published.
+type TimeseriesImportSummary struct {
+	DryRun     bool             `json:"dryRun"`
+	TimeFormat string           `json:"timeFormat"`
+	Timezone   string           `json:"timezone"`
+	Delimiter  string           `json:"delimiter"`
+	Rows       int              `json:"rows"`
+	Samples    int              `json:"samples"`
+	Rejected   int              `json:"rejected"`
+	Published  int              `json:"published"`
+	Series     []ImportedSeries `json:"series"`
+	Errors     []RowError       `json:"errors"` // the first MaxRowErrors problems
+}
+
+const MaxRowErrors = 100
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Samples may be slightly ahead of the clock of the plugin, as the clocks of devices and gateways drift.
+const MaxFutureSkew = 5 * time.Minute
+
+// TimeseriesPointResult tells whether a sample of a timeseries update was accepted and published. Index is the
+// position of the sample in the request.
+type TimeseriesPointResult struct {
+	Index     int       `json:"index"`
+	TS        time.Time `json:"ts"`
+	Accepted  bool      `json:"accepted"`
+	Published bool      `json:"published"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// TimeseriesUpdateResult is the outcome of a timeseries update.
+type TimeseriesUpdateResult struct {
+	Datapoint string                  `json:"datapoint"` // {project}/{subsystem}/{datapoint}
+	Accepted  int                     `json:"accepted"`
+	Rejected  int                     `json:"rejected"`
+	Published int                     `json:"published"`
+	Results   []TimeseriesPointResult `json:"results"`
+}
@@ -1,5 +1,13 @@
 package model
 
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type TimestampType string
 
 // TimestampType values
@@ -10,3 +18,58 @@ const (
 	ISO8601_offset TimestampType = "iso8601_offset"
 	PollTime       TimestampType = "polltime"
 )
+
+var (
+	TimestampTypes = []TimestampType{
+		EpochMillis,
+		EpochSeconds,
+		ISO8601_zoned,
+		ISO8601_offset,
+		PollTime,
+	}
+)
+
+// Parse reads a timestamp picked out of a document by the TimestampExpression. Zoned timestamps are written like
+// Java's ZonedDateTime, e.g. 2022-03-01T10:15:30+01:00[Europe/Stockholm], where the offset may be left out. PollTime
+// has no timestamp in the document, and returns the given time.
+func (t TimestampType) Parse(text string, pollTime time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	switch t {
+	case EpochMillis, EpochSeconds:
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return time.Time{}, fmt.Errorf("\"%s\" is not a number of %s", text, t)
+		}
+		if t == EpochSeconds {
+			number = number * 1000
+		}
+		return time.UnixMilli(int64(math.Round(number))), nil
+	case ISO8601_offset:
+		ts, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("\"%s\" is not an ISO 8601 timestamp with offset, like 2022-03-01T10:15:30+01:00", text)
+		}
+		return ts, nil
+	case ISO8601_zoned:
+		local, zone, found := strings.Cut(strings.TrimSuffix(text, "]"), "[")
+		if !found || !strings.HasSuffix(text, "]") {
+			return time.Time{}, fmt.Errorf("\"%s\" has no zone, like 2022-03-01T10:15:30+01:00[Europe/Stockholm]", text)
+		}
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("\"%s\" is not a known zone", zone)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, local)
+		if err != nil {
+			ts, err = time.ParseInLocation("2006-01-02T15:04:05.999999999", local, location)
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("\"%s\" is not an ISO 8601 timestamp with zone, like 2022-03-01T10:15:30+01:00[Europe/Stockholm]", text)
+		}
+		return ts.In(location), nil
+	case PollTime:
+		return pollTime, nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown timestamp type \"%s\"", t)
+	}
+}
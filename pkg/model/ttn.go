@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// TtnDevice is an end device registered in a TTN v3 application.
+type TtnDevice struct {
+	Id          string     `json:"id"`
+	DevEui      string     `json:"devEui,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	Description string     `json:"description,omitempty"`
+	LastSeen    *time.Time `json:"lastSeen,omitempty"`
+}
+
+// TtnUplink is an uplink stored by the Storage Integration of a TTN v3 application. Fields is the payload as decoded
+// by the payload formatter of the application or device.
+type TtnUplink struct {
+	Device   string                 `json:"device"`
+	Port     int32                  `json:"fport"`
+	Received time.Time              `json:"received"`
+	Fields   map[string]interface{} `json:"fields"`
+}
+
+// TtnPoint is a field of the decoded payloads of a device, which can be the Point of a Ttnv3Datasource. Nested fields
+// are joined with ".". Preview is the datapoint that would be created for it.
+type TtnPoint struct {
+	Device   string             `json:"device"`
+	Point    string             `json:"point"`
+	Port     int32              `json:"fport"`
+	Samples  int                `json:"samples"`
+	Numeric  bool               `json:"numeric"`
+	Latest   interface{}        `json:"latest"`
+	LastSeen time.Time          `json:"lastSeen"`
+	Preview  *DatapointSettings `json:"preview,omitempty"`
+}
+
+// TtnDiscovery is what the recent uplinks of a TTN v3 application tell about its devices.
+type TtnDiscovery struct {
+	Application string     `json:"application"`
+	Uplinks     int        `json:"uplinks"`
+	Points      []TtnPoint `json:"points"`
+}
@@ -0,0 +1,418 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sensetif/sensetif-datasource/pkg/docpath"
+	"github.com/Sensetif/sensetif-datasource/pkg/scalefunc"
+)
+
+// Name patterns for projects, subsystems and datapoints. The same patterns are used for the resource paths.
+const (
+	ProjectNamePattern   = `[a-zA-Z][a-zA-Z0-9_.\-]*`
+	SubsystemNamePattern = `[a-zA-Z][a-zA-Z0-9_.\-]*`
+	DatapointNamePattern = `[a-zA-Z][a-zA-Z0-9_.\-$\[\]]*`
+	TemplateNamePattern  = `[a-zA-Z][a-zA-Z0-9_.\-]*`
+)
+
+var (
+	projectNameRegexp   = regexp.MustCompile(`^` + ProjectNamePattern + `$`)
+	subsystemNameRegexp = regexp.MustCompile(`^` + SubsystemNamePattern + `$`)
+	datapointNameRegexp = regexp.MustCompile(`^` + DatapointNamePattern + `$`)
+	templateNameRegexp  = regexp.MustCompile(`^` + TemplateNamePattern + `$`)
+	parameterNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+)
+
+type FieldError struct {
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+	Position *int   `json:"position,omitempty"` // offset of a syntax error in an expression
+}
+
+// ValidationError collects all problems found in a payload. It wraps Cause, which defaults to ErrUnprocessableEntity.
+type ValidationError struct {
+	Cause  error
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		messages = append(messages, fe.Field+": "+fe.Message)
+	}
+	return e.Unwrap().Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	if e.Cause == nil {
+		return ErrUnprocessableEntity
+	}
+	return e.Cause
+}
+
+func (e *ValidationError) Add(field string, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// addExpressionError keeps the position of syntax errors, so that the UI can point at it.
+func (e *ValidationError) addExpressionError(field string, err error) {
+	fieldError := FieldError{Field: field, Message: err.Error()}
+	var syntaxErr *docpath.SyntaxError
+	var scaleErr *scalefunc.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		position := syntaxErr.Position
+		fieldError.Position = &position
+	case errors.As(err, &scaleErr):
+		position := scaleErr.Position
+		fieldError.Position = &position
+	}
+	e.Errors = append(e.Errors, fieldError)
+}
+
+// addNested adds the field errors of a nested ValidationError, with the fields prefixed.
+func (e *ValidationError) addNested(prefix string, err error) {
+	var nested *ValidationError
+	if errors.As(err, &nested) {
+		for _, fe := range nested.Errors {
+			fe.Field = prefix + fe.Field
+			e.Errors = append(e.Errors, fe)
+		}
+	} else if err != nil {
+		e.Add(prefix, "%s", err.Error())
+	}
+}
+
+// Err returns nil if no errors have been added, so that the result can be returned as an error.
+func (e *ValidationError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (p *ProjectSettings) Validate() error {
+	errs := &ValidationError{}
+	validateName(errs, "name", p.Name, projectNameRegexp)
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			errs.Add("timezone", "unknown timezone \"%s\"", p.Timezone)
+		}
+	}
+	if strings.TrimSpace(p.Geolocation) != "" {
+		if _, err := p.Location(); err != nil {
+			errs.Add("geolocation", "%s", err.Error())
+		}
+	}
+	return errs.Err()
+}
+
+func (s *SubsystemSettings) Validate() error {
+	errs := &ValidationError{}
+	validateName(errs, "project", s.Project, projectNameRegexp)
+	validateName(errs, "name", s.Name, subsystemNameRegexp)
+	return errs.Err()
+}
+
+func (dp *DatapointSettings) Validate() error {
+	errs := &ValidationError{}
+	validateName(errs, "project", dp.Project, projectNameRegexp)
+	validateName(errs, "subsystem", dp.Subsystem, subsystemNameRegexp)
+	validateName(errs, "name", dp.Name, datapointNameRegexp)
+	if !containsPollInterval(dp.Interval) {
+		errs.Add("pollinterval", "unknown poll interval \"%s\"", dp.Interval)
+	}
+	if !containsTimeToLive(dp.TimeToLive) {
+		errs.Add("timeToLive", "unknown time to live \"%s\"", dp.TimeToLive)
+	}
+	validateProcessing(errs, "proc", &dp.Proc)
+	switch ds := dp.Datasource.(type) {
+	case Ttnv3Datasource:
+		validateTtnv3(errs, "datasource", &ds)
+	case WebDatasource:
+		validateWeb(errs, "datasource", &ds)
+	case MqttDatasource:
+		validateMqtt(errs, "datasource", &ds)
+	default:
+		if dp.SourceType == Web || dp.SourceType == Ttnv3 || dp.SourceType == Mqtt {
+			errs.Add("datasource", "must be given for datasource type %s", dp.SourceType)
+		} else {
+			errs.Add("datasourcetype", "unknown datasource type \"%s\"", dp.SourceType)
+		}
+	}
+	return errs.Err()
+}
+
+// Validate checks the settings of a web datasource on its own, e.g. before it is tested.
+func (ds *WebDatasource) Validate() error {
+	errs := &ValidationError{}
+	validateWeb(errs, "datasource", ds)
+	return errs.Err()
+}
+
+// Validate checks the settings of an MQTT datasource on its own, e.g. before it is tested.
+func (ds *MqttDatasource) Validate() error {
+	errs := &ValidationError{}
+	validateMqtt(errs, "datasource", ds)
+	return errs.Err()
+}
+
+// Validate checks what is needed to try the expressions; the expressions themselves are checked by trying them, so
+// that the problems are reported in the ExpressionTestResult.
+func (t *ExpressionTest) Validate() error {
+	errs := &ValidationError{}
+	if t.Format != JSON && t.Format != XML {
+		errs.Add("format", "unknown document format \"%s\"", t.Format)
+	}
+	if !containsTimestampType(t.TimestampType) {
+		errs.Add("timestampType", "unknown timestamp type \"%s\"", t.TimestampType)
+	}
+	if strings.TrimSpace(t.Document) == "" {
+		errs.Add("document", "must not be empty")
+	} else if len(t.Document) > MaxSampleDocumentSize {
+		errs.Add("document", "must be at most %d bytes", MaxSampleDocumentSize)
+	}
+	return errs.Err()
+}
+
+// Validate checks the inputs; the function itself is checked by compiling it, so that the problems are reported in
+// the ScaleFuncTestResult.
+func (t *ScaleFuncTest) Validate() error {
+	errs := &ValidationError{}
+	if len(t.Inputs) > MaxScaleFuncTestInputs {
+		errs.Add("inputs", "must have at most %d values", MaxScaleFuncTestInputs)
+	}
+	return errs.Err()
+}
+
+// Validate checks the template with each placeholder replaced by the default of its parameter, or else its name, which
+// fits in names, URLs and expressions alike.
+func (t *SubsystemTemplate) Validate() error {
+	errs := &ValidationError{}
+	validateName(errs, "name", t.Name, templateNameRegexp)
+	samples := map[string]string{TemplateProjectParameter: TemplateProjectParameter}
+	for i, parameter := range t.Parameters {
+		field := "parameters[" + strconv.Itoa(i) + "].name"
+		validateName(errs, field, parameter.Name, parameterNameRegexp)
+		if parameter.Name == TemplateProjectParameter {
+			errs.Add(field, "{{%s}} is given when the template is instantiated", TemplateProjectParameter)
+		} else if _, found := samples[parameter.Name]; found {
+			errs.Add(field, "%s is given more than once", parameter.Name)
+		}
+		samples[parameter.Name] = parameter.Name
+		if parameter.Default != nil {
+			samples[parameter.Name] = *parameter.Default
+		}
+	}
+	for _, name := range t.Placeholders() {
+		if _, found := samples[name]; !found {
+			errs.Add("parameters", "{{%s}} is used but not declared", name)
+		}
+	}
+	if len(t.Datapoints) == 0 {
+		errs.Add("datapoints", "must have at least one datapoint")
+	}
+	sb, err := t.instantiate(TemplateProjectParameter, samples)
+	if err != nil {
+		errs.Add("", "%s", err.Error())
+	} else {
+		errs.addNested("", validateInstance(&sb))
+	}
+	return errs.Err()
+}
+
+// validateInstance checks the subsystem and datapoints of an instantiated template.
+func validateInstance(sb *SubsystemBundle) error {
+	errs := &ValidationError{}
+	errs.addNested("subsystem.", sb.Subsystem.Validate())
+	names := map[string]bool{}
+	for i, dp := range sb.Datapoints {
+		prefix := "datapoints[" + strconv.Itoa(i) + "]."
+		errs.addNested(prefix, dp.Validate())
+		if names[dp.Name] {
+			errs.Add(prefix+"name", "%s is given more than once", dp.Name)
+		}
+		names[dp.Name] = true
+	}
+	return errs.Err()
+}
+
+func validateName(errs *ValidationError, field string, name string, pattern *regexp.Regexp) {
+	if !pattern.MatchString(name) {
+		errs.Add(field, "\"%s\" must match %s", name, pattern.String())
+	}
+}
+
+func validateProcessing(errs *ValidationError, field string, proc *Processing) {
+	if proc.Scaling != "" && !containsScaling(proc.Scaling) {
+		errs.Add(field+".scaling", "unknown scaling \"%s\"", proc.Scaling)
+	}
+	if proc.Min > proc.Max {
+		errs.Add(field+".min", "min %g is larger than max %g", proc.Min, proc.Max)
+	}
+	if strings.TrimSpace(proc.ScaleFunc) != "" {
+		if _, err := scalefunc.Compile(proc.ScaleFunc); err != nil {
+			errs.addExpressionError(field+".scalefunc", err)
+		}
+	}
+}
+
+func validateTtnv3(errs *ValidationError, field string, ds *Ttnv3Datasource) {
+	if ds.Zone == "" {
+		errs.Add(field+".zone", "must not be empty")
+	}
+	if ds.Application == "" {
+		errs.Add(field+".application", "must not be empty")
+	}
+	if ds.Device == "" {
+		errs.Add(field+".device", "must not be empty")
+	}
+	if ds.Point == "" {
+		errs.Add(field+".point", "must not be empty")
+	}
+	if ds.Port < 0 || ds.Port > 255 {
+		errs.Add(field+".fport", "%d is not a LoRaWAN port", ds.Port)
+	}
+}
+
+func validateWeb(errs *ValidationError, field string, ds *WebDatasource) {
+	u, err := url.Parse(ds.URL)
+	if err != nil {
+		errs.Add(field+".url", "%s", err.Error())
+	} else {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			errs.Add(field+".url", "scheme must be http or https")
+		}
+		if u.Host == "" {
+			errs.Add(field+".url", "host is missing")
+		}
+		if u.User != nil {
+			errs.Add(field+".url", "credentials are not allowed in the URL, use auth instead")
+		}
+	}
+	switch ds.AuthenticationType {
+	case None, "":
+	case Basic:
+		if !strings.Contains(ds.Auth, "=") {
+			errs.Add(field+".auth", "basic authentication must be given as user=password")
+		}
+	case BearerToken:
+		if ds.Auth == "" {
+			errs.Add(field+".auth", "bearer token must not be empty")
+		}
+	default:
+		errs.Add(field+".authenticationType", "unknown authentication type \"%s\"", ds.AuthenticationType)
+	}
+	validateExtraction(errs, field, ds.Format, ds.ValueExpression, ds.TimestampType, ds.TimestampExpression)
+}
+
+func validateMqtt(errs *ValidationError, field string, ds *MqttDatasource) {
+	if !containsMqttProtocol(ds.Protocol) {
+		errs.Add(field+".protocol", "unknown protocol \"%s\"", ds.Protocol)
+	}
+	if ds.Address == "" {
+		errs.Add(field+".address", "must not be empty")
+	}
+	if ds.Port == 0 {
+		errs.Add(field+".port", "must be between 1 and 65535")
+	}
+	if ds.Topic == "" {
+		errs.Add(field+".topic", "must not be empty")
+	} else if err := validateTopicFilter(ds.Topic); err != nil {
+		errs.Add(field+".topic", "%s", err.Error())
+	}
+	validateExtraction(errs, field, ds.Format, ds.ValueExpression, ds.TimestampType, ds.TimestampExpression)
+}
+
+// validateTopicFilter checks the wildcards of an MQTT topic filter; + must be a whole level, and # the whole last one.
+func validateTopicFilter(topic string) error {
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		switch {
+		case strings.Contains(level, "#") && (level != "#" || i != len(levels)-1):
+			return fmt.Errorf("# must be the whole last level of the topic, like sensors/#")
+		case strings.Contains(level, "+") && level != "+":
+			return fmt.Errorf("+ must be a whole level of the topic, like sensors/+/temperature")
+		}
+	}
+	if strings.ContainsRune(topic, 0) {
+		return fmt.Errorf("the topic must not contain null characters")
+	}
+	return nil
+}
+
+func validateExtraction(errs *ValidationError, field string, format OriginDocumentFormat, valueExpr string, tsType TimestampType, tsExpr string) {
+	knownFormat := format == JSON || format == XML
+	if !knownFormat {
+		errs.Add(field+".format", "unknown document format \"%s\"", format)
+	}
+	if strings.TrimSpace(valueExpr) == "" {
+		errs.Add(field+".valueExpression", "must not be empty")
+	} else if _, err := format.Compile(valueExpr); knownFormat && isExpressionError(err) {
+		errs.addExpressionError(field+".valueExpression", err)
+	}
+	if !containsTimestampType(tsType) {
+		errs.Add(field+".timestampType", "unknown timestamp type \"%s\"", tsType)
+	} else if tsType != PollTime && strings.TrimSpace(tsExpr) == "" {
+		errs.Add(field+".timestampExpression", "must not be empty unless timestampType is %s", PollTime)
+	} else if _, err := format.Compile(tsExpr); tsType != PollTime && knownFormat && isExpressionError(err) {
+		errs.addExpressionError(field+".timestampExpression", err)
+	}
+}
+
+// isExpressionError tells whether an expression is refused. Those that only the backend can evaluate are accepted.
+func isExpressionError(err error) bool {
+	var unsupported *docpath.UnsupportedError
+	return err != nil && !errors.As(err, &unsupported)
+}
+
+func containsPollInterval(value PollInterval) bool {
+	for _, v := range PollIntervals {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTimeToLive(value TimeToLive) bool {
+	for _, v := range TimeToLives {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsScaling(value Scaling) bool {
+	for _, v := range Scalings {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTimestampType(value TimestampType) bool {
+	for _, v := range TimestampTypes {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMqttProtocol(value MqttProtocol) bool {
+	for _, v := range MqttProtocols {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "reflect"
+    . "regexp"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/handler"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const modulePath = "github.com/Sensetif/sensetif-datasource/"
+
+type apiParam struct {
+    Name        string
+    Description string
+}
+
+// apiDoc describes a route class of the links for the OpenAPI document. Request and Response are example values of
+// the bodies, whose types the schemas are derived from. Statuses are the status codes with the Response body, 200 if none.
+type apiDoc struct {
+    Summary  string
+    Params   []string // names of the path parameters, defaults to project, subsystem and datapoint
+    Query    []apiParam
+    Headers  []apiParam
+    Request  interface{}
+    Response interface{}
+    Paged    bool   // the response is a model.Page of the Response items, when limit or cursor is given
+    Content  string // media type of the response, defaults to application/json
+    Statuses []int
+}
+
+var listQuery = []apiParam{
+    {"limit", "Page size, up to 1000. With limit or cursor, the response is a page object with the total count."},
+    {"cursor", "The next cursor of the previous page."},
+    {"name", "Case-insensitive substring of the name."},
+    {"title", "Case-insensitive substring of the title."},
+    {"sort", "Field to sort on, prefixed with - for descending order."},
+}
+
+var accepted = []int{http.StatusAccepted}
+
+// Keyed by Link.Name. checkApiDocs reports links that are missing here.
+var apiDocs = map[string]apiDoc{
+    "health":  {Summary: "Health check", Response: map[string]interface{}{}},
+    "openapi": {Summary: "This OpenAPI document", Response: map[string]interface{}{}},
+
+    "projects.list":   {Summary: "List projects", Query: listQuery, Response: []model.ProjectSettings{}, Paged: true},
+    "projects.get":    {Summary: "Get a project", Response: model.ProjectSettings{}},
+    "projects.update": {Summary: "Create or update a project", Request: model.ProjectSettings{}, Response: model.CommandStatus{}, Statuses: accepted},
+    "projects.delete": {Summary: "Delete a project", Response: model.CommandStatus{}, Statuses: accepted},
+    "projects.rename": {Summary: "Rename a project", Request: map[string]interface{}{}, Response: model.CommandStatus{}, Statuses: accepted},
+
+    "subsystems.list":   {Summary: "List the subsystems of a project", Query: listQuery, Response: []model.SubsystemSettings{}, Paged: true},
+    "subsystems.get":    {Summary: "Get a subsystem", Response: model.SubsystemSettings{}},
+    "subsystems.update": {Summary: "Create or update a subsystem", Request: model.SubsystemSettings{}, Response: model.CommandStatus{}, Statuses: accepted},
+    "subsystems.delete": {Summary: "Delete a subsystem", Response: model.CommandStatus{}, Statuses: accepted},
+    "subsystems.rename": {Summary: "Rename a subsystem", Request: map[string]interface{}{}, Response: model.CommandStatus{}, Statuses: accepted},
+
+    "datapoints.list": {Summary: "List the datapoints of a subsystem", Query: append([]apiParam{
+        {"sourcetype", "Datasource type, web, ttnv3 or mqtt. Repeat for more than one."},
+    }, listQuery...), Response: []model.DatapointSettings{}, Paged: true},
+    "datapoints.get":    {Summary: "Get a datapoint", Response: model.DatapointSettings{}},
+    "datapoints.update": {Summary: "Create or update a datapoint", Request: model.DatapointSettings{}, Response: model.CommandStatus{}, Statuses: accepted},
+    "datapoints.delete": {Summary: "Delete a datapoint", Response: model.CommandStatus{}, Statuses: accepted},
+    "datapoints.rename": {Summary: "Rename a datapoint", Request: map[string]interface{}{}, Response: model.CommandStatus{}, Statuses: accepted},
+
+    "imports.fvc1":  {Summary: "Import a Link2Web FVC1 configuration", Request: map[string]interface{}{}, Response: model.CommandStatus{}, Statuses: accepted},
+    "imports.ttnv3": {Summary: "Import the devices of a TTN v3 application", Request: map[string]interface{}{}, Response: model.CommandStatus{}, Statuses: accepted},
+    "imports.timeseries": {Summary: "Import historical samples from CSV", Query: []apiParam{
+        {"map", "{column}={project}/{subsystem}/{datapoint}, repeated for each column."},
+        {"time", "Name of the time column, defaults to the first column."},
+        {"timeformat", "rfc3339, iso8601, excel, epochMillis, epochSeconds or a Go layout. Detected if not given."},
+        {"tz", "Timezone of timestamps without offset."},
+        {"delimiter", "\",\", \";\" or \"tab\". Detected if not given."},
+        {"decimal", "\".\" or \",\""},
+        {"dryrun", "true to only validate the file."},
+    }, Request: "", Response: model.TimeseriesImportSummary{}, Statuses: []int{http.StatusOK, http.StatusAccepted}},
+    "imports.bundle": {Summary: "Import a project bundle", Query: []apiParam{
+        {"apply", "true to apply the changes, otherwise they are only planned. The bundle replaces the project without If-Match, so plan first."},
+    }, Request: model.ProjectBundle{}, Response: model.BundlePlan{}, Statuses: []int{http.StatusOK, http.StatusAccepted}},
+
+    "projects.clone": {Summary: "Copy a project with all subsystems and datapoints to a new project", Query: []apiParam{
+        {"apply", "true to create the copy, otherwise it is only planned."},
+    }, Request: model.CloneOptions{}, Response: model.BundlePlan{}, Statuses: []int{http.StatusOK, http.StatusAccepted}},
+    "subsystems.clone": {Summary: "Copy a subsystem with all datapoints to a new subsystem", Query: []apiParam{
+        {"apply", "true to create the copy, otherwise it is only planned."},
+    }, Request: model.CloneOptions{}, Response: model.BundlePlan{}, Statuses: []int{http.StatusOK, http.StatusAccepted}},
+
+    "templates.list":   {Summary: "List subsystem templates", Query: listQuery, Response: []model.SubsystemTemplate{}, Paged: true},
+    "templates.get":    {Summary: "Get a subsystem template", Params: []string{"template"}, Response: model.SubsystemTemplate{}},
+    "templates.update": {Summary: "Create or update a subsystem template", Params: []string{"template"}, Request: model.SubsystemTemplate{}, Response: model.CommandStatus{}, Statuses: accepted},
+    "templates.delete": {Summary: "Delete a subsystem template", Params: []string{"template"}, Response: model.CommandStatus{}, Statuses: accepted},
+    "templates.instantiate": {Summary: "Create a subsystem with all datapoints from a template", Params: []string{"template"}, Query: []apiParam{
+        {"apply", "true to create the subsystem, otherwise it is only planned."},
+    }, Request: model.TemplateInstance{}, Response: model.BundlePlan{}, Statuses: []int{http.StatusOK, http.StatusAccepted}},
+
+    "tests.web": {Summary: "Fetch the document of a web datasource and extract the value and timestamp", Query: []apiParam{
+        {"datapoint", "{project}/{subsystem}/{datapoint} of a saved datapoint, whose credentials replace redacted ones"},
+    }, Request: model.WebDatasource{}, Response: model.WebTestResult{}},
+    "tests.mqtt": {Summary: "Subscribe to the topic of an MQTT datasource for a while and extract the value and timestamp of each message", Query: []apiParam{
+        {"duration", "Seconds to wait for messages, at most 30."},
+        {"max", "Stop after this many messages, at most 50."},
+        {"datapoint", "{project}/{subsystem}/{datapoint} of a saved datapoint, whose credentials replace redacted ones"},
+    }, Request: model.MqttDatasource{}, Response: model.MqttTestResult{}},
+    "tests.expressions": {Summary: "Try the value and timestamp expressions of a datasource on a sample document", Request: model.ExpressionTest{}, Response: model.ExpressionTestResult{}},
+    "tests.scalefunc": {Summary: "Compile a custom scaling function and evaluate it for some inputs", Query: []apiParam{
+        {"datapoint", "{project}/{subsystem}/{datapoint} of a saved datapoint, whose scalefunc and latest values are used where the body has none"},
+    }, Request: model.ScaleFuncTest{}, Response: model.ScaleFuncTestResult{}},
+    "ttn.devices": {Summary: "List the devices of a TTN v3 application", Query: []apiParam{
+        {"datapoint", "{project}/{subsystem}/{datapoint} of a saved datapoint, whose key replaces a redacted one"},
+    }, Request: model.Ttnv3Datasource{}, Response: []model.TtnDevice{}},
+    "ttn.points": {Summary: "List the decoded payload fields in the recent uplinks of a TTN v3 application or device", Query: []apiParam{
+        {"limit", "Number of uplinks to look at, at most 100."},
+        {"project", "With subsystem, each point has a preview of the datapoint it would be."},
+        {"subsystem", ""},
+        {"datapoint", "{project}/{subsystem}/{datapoint} of a saved datapoint, whose key replaces a redacted one"},
+    }, Request: model.Ttnv3Datasource{}, Response: model.TtnDiscovery{}},
+
+    "projects.export": {Summary: "Export a project bundle", Query: []apiParam{
+        {"format", "json (default) or yaml"},
+        {"redact", "false to include secrets"},
+    }, Response: model.ProjectBundle{}},
+    "timeseries.export": {Summary: "Export raw samples, without the scalefunc, as CSV", Query: []apiParam{
+        {"datapoint", "{project}/{subsystem}/{datapoint}, repeated for each datapoint, up to 20."},
+        {"from", "RFC3339 or epoch milliseconds"},
+        {"to", "RFC3339 or epoch milliseconds, up to 366 days after from"},
+        {"timeformat", "rfc3339, iso8601, excel, epochMillis or epochSeconds"},
+        {"tz", "Timezone of the timestamps."},
+        {"delimiter", "\",\", \";\" or \"tab\""},
+        {"decimal", "\".\" or \",\""},
+        {"layout", "long or wide"},
+        {"bom", "true to start with a UTF-8 byte order mark"},
+    }, Response: "", Content: "text/csv"},
+
+    "freshness.report": {Summary: "Report how up to date the datapoints are", Response: model.FreshnessReport{}},
+    "search": {Summary: "Search projects, subsystems and datapoints", Query: []apiParam{
+        {"q", "The words to look for."},
+        {"kind", "project, subsystem or datapoint"},
+        {"limit", "Number of hits, up to 100."},
+    }, Response: model.SearchResult{}},
+    "commands.get": {Summary: "Get the status of a configuration command", Params: []string{"id"}, Response: model.CommandStatus{}},
+    "audit.list": {Summary: "List the audit trail, newest first", Query: []apiParam{
+        {"from", "RFC3339 or epoch milliseconds"},
+        {"to", "RFC3339 or epoch milliseconds"},
+        {"login", ""}, {"action", ""}, {"project", ""}, {"subsystem", ""}, {"datapoint", ""},
+        {"limit", "Number of entries, up to 1000."},
+    }, Response: []model.AuditEntry{}},
+
+    "limits.current":     {Summary: "Get the limits of the current plan", Response: model.PlanLimits{}},
+    "limits.usage":       {Summary: "Get the usage of the current plan", Response: model.PlanUsage{}},
+    "plans.list":         {Summary: "List the plans", Response: []model.PlanSettings{}},
+    "plans.checkout":     {Summary: "Start a checkout of a plan", Request: handler.PlanPricing{}, Response: handler.SessionProxy{}},
+    "checkout.success":   {Summary: "Complete a checkout", Request: handler.SessionProxy{}, Response: handler.SubscriptionInfo{}},
+    "checkout.cancelled": {Summary: "Cancel a checkout", Request: handler.SessionProxy{}, Response: handler.SubscriptionInfo{}},
+    "organization.get":   {Summary: "Get the organization", Response: model.OrganizationSettings{}},
+    "timeseries.update": {Summary: "Add samples to a datapoint", Headers: []apiParam{
+        {"Idempotency-Key", "Retries with the same key within 24 hours get the original response"},
+    }, Request: []model.TsPair{}, Response: model.TimeseriesUpdateResult{}, Statuses: []int{http.StatusAccepted, http.StatusServiceUnavailable}},
+
+    "authorizations.list":   {Summary: "List the required role of each route class", Response: []model.RouteAuthorization{}},
+    "authorizations.update": {Summary: "Override the required roles", Request: map[string]model.Role{}, Response: model.CommandStatus{}, Statuses: accepted},
+
+    "ratelimits.list": {Summary: "List the rate limits of the organization, and how many calls they have allowed and rejected", Response: []model.RateLimitStatus{}},
+}
+
+// Fields that hold one of several types.
+var fieldAlternatives = map[string][]interface{}{
+    "model.DatapointSettings.Datasource": {model.WebDatasource{}, model.Ttnv3Datasource{}, model.MqttDatasource{}},
+}
+
+var openApiDocument struct {
+    once sync.Once
+    body []byte
+    err  error
+}
+
+// openApiLinks is appended to the links in main, as the document is generated from the links.
+func openApiLinks() []Link {
+    return []Link{
+        {Name: "openapi", Role: model.Viewer, Method: "GET", Fn: OpenApi, Pattern: MustCompile(`^_openapi$`)},
+    }
+}
+
+//goland:noinspection GoUnusedParameter
+func OpenApi(_ int64, _ []string, _ []byte, _ *handler.Request, _ *client.Clients) (*backend.CallResourceResponse, error) {
+    openApiDocument.once.Do(func() {
+        openApiDocument.body, openApiDocument.err = json.Marshal(createOpenApi(links))
+    })
+    if openApiDocument.err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, openApiDocument.err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   openApiDocument.body,
+    }, nil
+}
+
+type schemaGenerator struct {
+    components map[string]interface{}
+}
+
+func createOpenApi(links []Link) map[string]interface{} {
+    generator := &schemaGenerator{components: map[string]interface{}{}}
+    generator.components["Problem"] = generator.structSchema(reflect.TypeOf(model.Problem{}))
+    paths := map[string]map[string]interface{}{}
+    for _, link := range links {
+        doc := apiDocs[link.Name]
+        path, names, err := pathOf(link.Pattern.String(), doc.Params)
+        if err != nil {
+            log.DefaultLogger.Error(fmt.Sprintf("%s is left out of the OpenAPI document: %s", link.Name, err.Error()))
+            continue
+        }
+        if paths[path] == nil {
+            paths[path] = map[string]interface{}{}
+        }
+        paths[path][strings.ToLower(link.Method)] = generator.operation(link, doc, names)
+    }
+    return map[string]interface{}{
+        "openapi": "3.0.3",
+        "info": map[string]interface{}{
+            "title":       "Sensetif resource API",
+            "version":     "1",
+            "description": "Configuration of projects, subsystems and datapoints, and access to their timeseries. The roles are the defaults, which an organization can override per route class.",
+        },
+        "servers": []interface{}{
+            map[string]interface{}{"url": "/api/datasources/uid/{uid}/resources", "variables": map[string]interface{}{
+                "uid": map[string]interface{}{"default": "sensetif", "description": "uid of the Sensetif datasource"},
+            }},
+        },
+        "paths":      paths,
+        "components": map[string]interface{}{"schemas": generator.components},
+    }
+}
+
+func (g *schemaGenerator) operation(link Link, doc apiDoc, names []string) map[string]interface{} {
+    parameters := make([]interface{}, 0)
+    for _, name := range names {
+        parameters = append(parameters, map[string]interface{}{
+            "name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+        })
+    }
+    for _, param := range doc.Query {
+        parameters = append(parameters, map[string]interface{}{
+            "name": param.Name, "in": "query", "description": param.Description, "schema": map[string]interface{}{"type": "string"},
+        })
+    }
+    for _, param := range doc.Headers {
+        parameters = append(parameters, map[string]interface{}{
+            "name": param.Name, "in": "header", "description": param.Description, "schema": map[string]interface{}{"type": "string"},
+        })
+    }
+    if link.Version {
+        parameters = append(parameters, map[string]interface{}{
+            "name": "If-Match", "in": "header", "description": "ETag of the latest GET, required when changing existing settings", "schema": map[string]interface{}{"type": "string"},
+        })
+    }
+    problem := map[string]interface{}{
+        "description": "Problem",
+        "content": map[string]interface{}{
+            "application/problem+json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Problem"}},
+        },
+    }
+    responses := map[string]interface{}{
+        "default": problem,
+        "429": map[string]interface{}{
+            "description": "Rate limit of the route class exceeded",
+            "headers": map[string]interface{}{
+                "Retry-After": map[string]interface{}{"description": "Seconds until the next call is allowed", "schema": map[string]interface{}{"type": "integer"}},
+            },
+            "content": problem["content"],
+        },
+    }
+    for _, status := range doc.successStatuses() {
+        response := map[string]interface{}{"description": http.StatusText(status)}
+        if doc.Response != nil {
+            schema := g.schemaOf(reflect.TypeOf(doc.Response))
+            if doc.Paged {
+                schema = map[string]interface{}{"oneOf": []interface{}{schema, g.pageSchema(schema)}}
+            }
+            response["content"] = map[string]interface{}{doc.contentType(): map[string]interface{}{"schema": schema}}
+        }
+        responses[fmt.Sprintf("%d", status)] = response
+    }
+    operation := map[string]interface{}{
+        "operationId": operationIdOf(link),
+        "summary":     doc.Summary,
+        "description": fmt.Sprintf("Route class %s, requires role %s.", link.Name, link.Role),
+        "tags":        []string{strings.Split(link.Name, ".")[0]},
+        "parameters":  parameters,
+        "responses":   responses,
+    }
+    if doc.Request != nil {
+        contentType := "application/json"
+        if _, isText := doc.Request.(string); isText {
+            contentType = "text/csv"
+        }
+        operation["requestBody"] = map[string]interface{}{
+            "required": true,
+            "content":  map[string]interface{}{contentType: map[string]interface{}{"schema": g.schemaOf(reflect.TypeOf(doc.Request))}},
+        }
+    }
+    return operation
+}
+
+// operationIdOf is the route class, made unique for the classes that have more than one path.
+func operationIdOf(link Link) string {
+    id := strings.ReplaceAll(link.Name, ".", "_")
+    if groups := link.Pattern.NumSubexp(); link.Name == "freshness.report" && groups > 0 {
+        id += fmt.Sprintf("_%d", groups)
+    }
+    return id
+}
+
+func (d *apiDoc) successStatuses() []int {
+    if len(d.Statuses) == 0 {
+        return []int{http.StatusOK}
+    }
+    return d.Statuses
+}
+
+func (d *apiDoc) contentType() string {
+    if d.Content == "" {
+        return "application/json"
+    }
+    return d.Content
+}
+
+func (g *schemaGenerator) pageSchema(items map[string]interface{}) map[string]interface{} {
+    return map[string]interface{}{
+        "type":     "object",
+        "required": []string{"items", "total"},
+        "properties": map[string]interface{}{
+            "items": items,
+            "total": map[string]interface{}{"type": "integer"},
+            "next":  map[string]interface{}{"type": "string"},
+        },
+    }
+}
+
+// pathOf turns the pattern of a link into an OpenAPI path, e.g. ^(name)/(name)/_$ into /{project}/{subsystem}/_, and
+// returns the names of the path parameters.
+func pathOf(pattern string, names []string) (string, []string, error) {
+    if names == nil {
+        names = []string{"project", "subsystem", "datapoint"}
+    }
+    pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$")
+    var path strings.Builder
+    depth := 0
+    used := 0
+    for i := 0; i < len(pattern); i++ {
+        c := pattern[i]
+        switch {
+        case c == '\\':
+            if depth == 0 {
+                return "", nil, fmt.Errorf("escaped character in the path: %s", pattern)
+            }
+            i++
+        case c == '(':
+            if depth == 0 {
+                if used == len(names) {
+                    return "", nil, fmt.Errorf("no name for group %d in %s", used+1, pattern)
+                }
+                path.WriteString("{" + names[used] + "}")
+                used++
+            }
+            depth++
+        case c == ')':
+            depth--
+        case depth == 0 && strings.IndexByte(".*+?[]{}|^$", c) >= 0:
+            return "", nil, fmt.Errorf("regular expression outside of a group: %s", pattern)
+        case depth == 0:
+            path.WriteByte(c)
+        }
+    }
+    result := path.String()
+    if !strings.HasPrefix(result, "/") {
+        result = "/" + result
+    }
+    return result, names[:used], nil
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) map[string]interface{} {
+    switch {
+    case t == reflect.TypeOf(time.Time{}):
+        return map[string]interface{}{"type": "string", "format": "date-time"}
+    case t == reflect.TypeOf(json.RawMessage{}):
+        return map[string]interface{}{}
+    }
+    switch t.Kind() {
+    case reflect.Ptr:
+        schema := g.schemaOf(t.Elem())
+        if _, isRef := schema["$ref"]; isRef {
+            return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+        }
+        schema["nullable"] = true
+        return schema
+    case reflect.Struct:
+        if !strings.HasPrefix(t.PkgPath(), modulePath) || t.Name() == "" {
+            // types of other libraries, like Stripe, are not described in detail
+            return map[string]interface{}{"type": "object"}
+        }
+        name := t.Name()
+        if _, found := g.components[name]; !found {
+            g.components[name] = map[string]interface{}{} // placeholder for recursive types
+            g.components[name] = g.structSchema(t)
+        }
+        return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return map[string]interface{}{"type": "string", "format": "byte"}
+        }
+        return map[string]interface{}{"type": "array", "items": g.schemaOf(t.Elem())}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": g.schemaOf(t.Elem())}
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+        return map[string]interface{}{"type": "integer", "format": "int32"}
+    case reflect.Int64, reflect.Uint64:
+        return map[string]interface{}{"type": "integer", "format": "int64"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    }
+    return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+    properties := map[string]interface{}{}
+    required := make([]string, 0)
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        name, omitEmpty, include := jsonNameOf(field)
+        if !include {
+            continue
+        }
+        var schema map[string]interface{}
+        if alternatives, found := fieldAlternatives[t.String()+"."+field.Name]; found {
+            oneOf := make([]interface{}, 0, len(alternatives))
+            for _, alternative := range alternatives {
+                oneOf = append(oneOf, g.schemaOf(reflect.TypeOf(alternative)))
+            }
+            schema = map[string]interface{}{"oneOf": oneOf}
+        } else {
+            schema = g.schemaOf(field.Type)
+        }
+        properties[name] = schema
+        if !omitEmpty {
+            required = append(required, name)
+        }
+    }
+    schema := map[string]interface{}{"type": "object", "properties": properties}
+    if len(required) > 0 {
+        schema["required"] = required
+    }
+    return schema
+}
+
+func jsonNameOf(field reflect.StructField) (string, bool, bool) {
+    if !field.IsExported() {
+        return "", false, false
+    }
+    tag := field.Tag.Get("json")
+    if tag == "-" {
+        return "", false, false
+    }
+    name, options, _ := strings.Cut(tag, ",")
+    if name == "" {
+        name = field.Name
+    }
+    return name, strings.Contains(options, "omitempty"), true
+}
+
+// checkApiDocs logs the links that are not, or can not be, described in the OpenAPI document. It runs at startup, so
+// that a route added without documentation shows up in the log right away.
+func checkApiDocs(links []Link) []string {
+    var problems []string
+    for _, link := range links {
+        doc, found := apiDocs[link.Name]
+        if !found {
+            problems = append(problems, fmt.Sprintf("%s %s (%s) is not documented", link.Method, link.Pattern, link.Name))
+            continue
+        }
+        if _, _, err := pathOf(link.Pattern.String(), doc.Params); err != nil {
+            problems = append(problems, fmt.Sprintf("%s: %s", link.Name, err.Error()))
+        }
+    }
+    for _, problem := range problems {
+        log.DefaultLogger.Error("OpenAPI: " + problem)
+    }
+    return problems
+}
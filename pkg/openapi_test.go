@@ -0,0 +1,461 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fakeCassandra serves one project with a subsystem, two datapoints with a day of samples, and a template.
+type fakeCassandra struct {
+    projects   []model.ProjectSettings
+    subsystems []model.SubsystemSettings
+    datapoints []model.DatapointSettings
+    templates  []model.SubsystemTemplate
+    samples    []model.TsPair
+}
+
+func createFakeCassandra(t *testing.T) *fakeCassandra {
+    fake := &fakeCassandra{
+        projects: []model.ProjectSettings{
+            {Name: "garden", Title: "Garden", City: "Uppsala", Country: "Sweden", Timezone: "Europe/Stockholm", Geolocation: "59.85,17.64"},
+        },
+        subsystems: []model.SubsystemSettings{
+            {Project: "garden", Name: "shed", Title: "Shed", Locallocation: "north corner"},
+        },
+    }
+    datapoints := `[
+        {"project": "garden", "subsystem": "shed", "name": "temperature", "pollinterval": "five_minutes", "timeToLive": "a",
+         "proc": {"unit": "C", "scaling": "lin", "k": 1, "m": 0, "min": -40, "max": 60, "scalefunc": "round(x, 1)"},
+         "datasourcetype": "web", "datasource": {"url": "https://example.com/weather.json", "authenticationType": "none",
+         "format": "jsondoc", "valueExpression": "$.temperature", "timestampType": "polltime"}},
+        {"project": "garden", "subsystem": "shed", "name": "humidity", "pollinterval": "five_minutes", "timeToLive": "a",
+         "proc": {"unit": "%", "scaling": "lin", "k": 1, "m": 0, "min": 0, "max": 100},
+         "datasourcetype": "ttnv3", "datasource": {"zone": "eu1", "application": "garden", "device": "shed-sensor",
+         "point": "humidity", "authorizationkey": "NNSXS.SECRET"}}
+    ]`
+    if err := json.Unmarshal([]byte(datapoints), &fake.datapoints); err != nil {
+        t.Fatal(err)
+    }
+    templates := `[{"name": "weather", "title": "Weather station", "parameters": [{"name": "device"}],
+        "subsystem": {"name": "{{device}}", "title": "Station {{device}}"},
+        "datapoints": [{"name": "temperature", "pollinterval": "five_minutes", "timeToLive": "a",
+         "proc": {"unit": "C", "scaling": "lin", "k": 1, "m": 0, "min": -40, "max": 60},
+         "datasourcetype": "ttnv3", "datasource": {"zone": "eu1", "application": "garden", "device": "{{device}}",
+         "point": "temperature", "authorizationkey": "NNSXS.SECRET"}}]}]`
+    if err := json.Unmarshal([]byte(templates), &fake.templates); err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now().Truncate(time.Minute)
+    for i := 12; i > 0; i-- {
+        fake.samples = append(fake.samples, model.TsPair{TS: now.Add(-time.Duration(i) * time.Hour), Value: 20 + float64(i)/3})
+    }
+    return fake
+}
+
+func (f *fakeCassandra) QueryTimeseries(_ int64, _ model.SensorRef, from time.Time, to time.Time, _ int) []model.TsPair {
+    var result []model.TsPair
+    for _, sample := range f.samples {
+        if !sample.TS.Before(from) && !sample.TS.After(to) {
+            result = append(result, sample)
+        }
+    }
+    return result
+}
+
+func (f *fakeCassandra) ReadTimeseries(orgId int64, sensor model.SensorRef, from time.Time, to time.Time) ([]model.TsPair, error) {
+    return f.QueryTimeseries(orgId, sensor, from, to, 0), nil
+}
+
+func (f *fakeCassandra) QueryLatestValue(_ int64, _ model.SensorRef, since time.Time) (*model.TsPair, error) {
+    latest := f.samples[len(f.samples)-1]
+    if latest.TS.Before(since) {
+        return nil, nil
+    }
+    return &latest, nil
+}
+
+func (f *fakeCassandra) QueryAlarmHistory(_ int64, _ model.SensorRef, _ time.Time, _ time.Time, _ int) ([]model.TsPair, error) {
+    return make([]model.TsPair, 0), nil
+}
+
+func (f *fakeCassandra) QueryAlarmStates(_ int64, sensor model.SensorRef) ([]model.TsPair, error) {
+    states := make([]model.TsPair, 0)
+    if sensor.Project == "garden" {
+        // humidity is in a minor alarm, temperature in a warning
+        states = append(states, model.TsPair{TS: time.Now(), Value: 3}, model.TsPair{TS: time.Now(), Value: 2})
+    }
+    return states, nil
+}
+
+func (f *fakeCassandra) FindAllProjects(_ int64) ([]model.ProjectSettings, error) {
+    return f.projects, nil
+}
+
+func (f *fakeCassandra) FindAllSubsystems(_ int64, projectName string) ([]model.SubsystemSettings, error) {
+    result := make([]model.SubsystemSettings, 0)
+    for _, subsystem := range f.subsystems {
+        if subsystem.Project == projectName {
+            result = append(result, subsystem)
+        }
+    }
+    return result, nil
+}
+
+func (f *fakeCassandra) FindAllDatapoints(_ int64, projectName string, subsystemName string) ([]model.DatapointSettings, error) {
+    result := make([]model.DatapointSettings, 0)
+    for _, datapoint := range f.datapoints {
+        if datapoint.Project == projectName && datapoint.Subsystem == subsystemName {
+            result = append(result, datapoint)
+        }
+    }
+    return result, nil
+}
+
+func (f *fakeCassandra) FindProjectsPage(orgId int64, _ int, _ []byte) (model.ResultPage, error) {
+    projects, err := f.FindAllProjects(orgId)
+    return model.ResultPage{Items: projects, Total: len(projects)}, err
+}
+
+func (f *fakeCassandra) FindSubsystemsPage(orgId int64, projectName string, _ int, _ []byte) (model.ResultPage, error) {
+    subsystems, err := f.FindAllSubsystems(orgId, projectName)
+    return model.ResultPage{Items: subsystems, Total: len(subsystems)}, err
+}
+
+func (f *fakeCassandra) FindDatapointsPage(orgId int64, projectName string, subsystemName string, _ int, _ []byte) (model.ResultPage, error) {
+    datapoints, err := f.FindAllDatapoints(orgId, projectName, subsystemName)
+    return model.ResultPage{Items: datapoints, Total: len(datapoints)}, err
+}
+
+func (f *fakeCassandra) CountDatapointsPerProject(_ int64) (map[string]int64, error) {
+    result := map[string]int64{}
+    for _, datapoint := range f.datapoints {
+        result[datapoint.Project]++
+    }
+    return result, nil
+}
+
+func (f *fakeCassandra) GetOrganization(_ int64) (model.OrganizationSettings, error) {
+    return model.OrganizationSettings{Name: "Gardeners", Email: "info@example.com", CurrentPlan: "basic"}, nil
+}
+
+func (f *fakeCassandra) GetProject(_ int64, name string) (model.ProjectSettings, error) {
+    for _, project := range f.projects {
+        if project.Name == name {
+            return project, nil
+        }
+    }
+    return model.ProjectSettings{}, nil
+}
+
+func (f *fakeCassandra) GetSubsystem(_ int64, projectName string, subsystemName string) (model.SubsystemSettings, error) {
+    for _, subsystem := range f.subsystems {
+        if subsystem.Project == projectName && subsystem.Name == subsystemName {
+            return subsystem, nil
+        }
+    }
+    return model.SubsystemSettings{}, nil
+}
+
+func (f *fakeCassandra) GetDatapoint(_ int64, projectName string, subsystemName string, datapointName string) (model.DatapointSettings, error) {
+    for _, datapoint := range f.datapoints {
+        if datapoint.Project == projectName && datapoint.Subsystem == subsystemName && datapoint.Name == datapointName {
+            return datapoint, nil
+        }
+    }
+    return model.DatapointSettings{}, nil
+}
+
+func (f *fakeCassandra) GetRoleOverrides(_ int64) (map[string]model.Role, error) {
+    return map[string]model.Role{"projects.export": model.Viewer}, nil
+}
+
+func (f *fakeCassandra) GetCurrentLimits(_ int64) (model.PlanLimits, error) {
+    return model.PlanLimits{MaxStorage: "c", MaxDatapoints: 50, MinPollInterval: "one_minute"}, nil
+}
+
+func (f *fakeCassandra) FindAllTemplates(_ int64) ([]model.SubsystemTemplate, error) {
+    return f.templates, nil
+}
+
+func (f *fakeCassandra) GetTemplate(_ int64, name string) (model.SubsystemTemplate, error) {
+    for _, template := range f.templates {
+        if template.Name == name {
+            return template, nil
+        }
+    }
+    return model.SubsystemTemplate{}, nil
+}
+
+func (f *fakeCassandra) Shutdown() {}
+
+func (f *fakeCassandra) Reinitialize() {}
+
+func (f *fakeCassandra) Err() error {
+    return nil
+}
+
+func (f *fakeCassandra) IsHealthy() bool {
+    return true
+}
+
+// recordingSender collects the parts of a response, which are more than one when the response is streamed.
+type recordingSender struct {
+    responses []*backend.CallResourceResponse
+}
+
+func (r *recordingSender) Send(response *backend.CallResourceResponse) error {
+    r.responses = append(r.responses, response)
+    return nil
+}
+
+func (r *recordingSender) body() []byte {
+    var body []byte
+    for _, response := range r.responses {
+        body = append(body, response.Body...)
+    }
+    return body
+}
+
+// The links are completed as in main, once for all tests.
+var testLinks sync.Once
+
+func createTestResourceHandler(t *testing.T) *ResourceHandler {
+    cassandra := createFakeCassandra(t)
+    commands := client.CreateCommandTracker()
+    clients := client.Clients{
+        Cassandra:   cassandra,
+        Commands:    commands,
+        Search:      client.CreateSearchIndexes(cassandra, commands),
+        Idempotency: client.CreateIdempotencyCache(),
+        Web:         client.CreateWebClient(),
+        Mqtt:        client.CreateMqttClient(),
+        Ttn:         client.CreateTtnClient(),
+    }
+    authorizations := CreateAuthorizations(cassandra)
+    rateLimiter := CreateRateLimiter(cassandra.GetCurrentLimits)
+    testLinks.Do(func() {
+        links = append(links, authorizations.Links()...)
+        links = append(links, rateLimiter.Links()...)
+        links = append(links, openApiLinks()...)
+    })
+    return &ResourceHandler{Clients: &clients, Authorizations: authorizations, RateLimiter: rateLimiter}
+}
+
+func TestApiDocsCoverAllLinks(t *testing.T) {
+    createTestResourceHandler(t)
+    if problems := checkApiDocs(links); len(problems) > 0 {
+        t.Errorf("the OpenAPI document is incomplete:\n%s", strings.Join(problems, "\n"))
+    }
+    document, err := json.Marshal(createOpenApi(links))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var decoded struct {
+        Paths map[string]map[string]interface{} `json:"paths"`
+    }
+    if err = json.Unmarshal(document, &decoded); err != nil {
+        t.Fatal(err)
+    }
+    for _, link := range links {
+        path, _, _ := pathOf(link.Pattern.String(), apiDocs[link.Name].Params)
+        if decoded.Paths[path][strings.ToLower(link.Method)] == nil {
+            t.Errorf("%s %s (%s) is missing in the OpenAPI document", link.Method, path, link.Name)
+        }
+    }
+}
+
+type contractCall struct {
+    route  string
+    method string
+    url    string
+    body   string
+}
+
+// contractCalls exercise the routes with the fake Cassandra. Writes are only planned, as there is no Pulsar to publish
+// them on.
+var contractCalls = []contractCall{
+    {"health", "GET", "/", ""},
+    {"projects.list", "GET", "_", ""},
+    {"projects.list", "GET", "_?limit=1", ""},
+    {"projects.get", "GET", "garden", ""},
+    {"subsystems.list", "GET", "garden/_", ""},
+    {"subsystems.list", "GET", "garden/_?limit=1&sort=title", ""},
+    {"subsystems.get", "GET", "garden/shed", ""},
+    {"datapoints.list", "GET", "garden/shed/_", ""},
+    {"datapoints.list", "GET", "garden/shed/_?limit=1", ""},
+    {"datapoints.get", "GET", "garden/shed/temperature", ""},
+    {"imports.bundle", "POST", "_import/bundle", ""}, // the body is the export of garden
+    {"projects.clone", "POST", "_clone/garden", `{"name": "orchard", "title": "Orchard"}`},
+    {"subsystems.clone", "POST", "_clone/garden/shed", `{"name": "barn", "substitutions": {"shed-sensor": "barn-sensor"}}`},
+    {"templates.list", "GET", "_templates", ""},
+    {"templates.get", "GET", "_templates/weather", ""},
+    {"templates.instantiate", "POST", "_templates/weather/_instantiate", `{"project": "garden", "values": {"device": "pond"}}`},
+    {"tests.expressions", "POST", "_test/expressions", `{"format": "jsondoc", "valueExpression": "$.temprature", "timestampType": "polltime", "document": "{\"temperature\": 21.5}"}`},
+    {"tests.scalefunc", "POST", "_test/scalefunc?datapoint=garden/shed/temperature", `{"inputs": [1.25]}`},
+    {"projects.export", "GET", "_export/garden", ""},
+    {"timeseries.export", "GET", "_export/_timeseries?datapoint=garden/shed/temperature&datapoint=garden/shed/humidity&layout=wide", ""},
+    {"freshness.report", "GET", "_freshness", ""},
+    {"freshness.report", "GET", "_freshness/garden/shed", ""},
+    {"search", "GET", "_search?q=shed", ""},
+    {"limits.current", "GET", "_limits/current", ""},
+    {"limits.usage", "GET", "_limits/usage", ""},
+    {"organization.get", "GET", "_organization", ""},
+    {"authorizations.list", "GET", "_authorizations", ""},
+    {"ratelimits.list", "GET", "_ratelimits", ""},
+    {"openapi", "GET", "_openapi", ""},
+}
+
+// uncheckedRoutes can't be called without Pulsar, Stripe or a remote server.
+var uncheckedRoutes = map[string]string{
+    "projects.update":       "publishes",
+    "projects.delete":       "publishes",
+    "projects.rename":       "publishes",
+    "subsystems.update":     "publishes",
+    "subsystems.delete":     "publishes",
+    "subsystems.rename":     "publishes",
+    "datapoints.update":     "publishes",
+    "datapoints.delete":     "publishes",
+    "datapoints.rename":     "publishes",
+    "imports.fvc1":          "publishes",
+    "imports.ttnv3":         "publishes",
+    "imports.timeseries":    "publishes",
+    "templates.update":      "publishes",
+    "templates.delete":      "publishes",
+    "timeseries.update":     "publishes",
+    "authorizations.update": "publishes",
+    "audit.list":            "reads Pulsar",
+    "commands.get":          "needs a submitted command",
+    "tests.web":             "fetches a remote document",
+    "tests.mqtt":            "connects to a broker",
+    "ttn.devices":           "calls The Things Network",
+    "ttn.points":            "calls The Things Network",
+    "plans.list":            "calls Stripe",
+    "plans.checkout":        "calls Stripe",
+    "checkout.success":      "calls Stripe",
+    "checkout.cancelled":    "calls Stripe",
+}
+
+func TestResponsesMatchApiDocs(t *testing.T) {
+    resourceHandler := createTestResourceHandler(t)
+    checked := map[string]bool{}
+    for _, call := range contractCalls {
+        checked[call.route] = true
+        t.Run(call.method+" "+call.url, func(t *testing.T) {
+            body := []byte(call.body)
+            if call.route == "imports.bundle" {
+                body = exportedBundle(t, resourceHandler)
+            }
+            sender := &recordingSender{}
+            err := resourceHandler.CallResource(context.Background(), &backend.CallResourceRequest{
+                PluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "admin", Role: string(model.Admin)}},
+                Method:        call.method,
+                URL:           call.url,
+                Body:          body,
+            }, sender)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(sender.responses) == 0 {
+                t.Fatal("no response")
+            }
+            if err = checkContract(call.route, sender.responses[0], sender.body()); err != nil {
+                t.Error(err)
+            }
+        })
+    }
+    for _, link := range links {
+        if _, unchecked := uncheckedRoutes[link.Name]; !unchecked && !checked[link.Name] {
+            t.Errorf("%s has no contract call", link.Name)
+        }
+    }
+}
+
+func exportedBundle(t *testing.T, resourceHandler *ResourceHandler) []byte {
+    sender := &recordingSender{}
+    err := resourceHandler.CallResource(context.Background(), &backend.CallResourceRequest{
+        PluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "admin", Role: string(model.Admin)}},
+        Method:        "GET",
+        URL:           "_export/garden?redact=false",
+    }, sender)
+    if err != nil || len(sender.responses) != 1 || sender.responses[0].Status != http.StatusOK {
+        t.Fatalf("unable to export garden: %v", err)
+    }
+    return sender.body()
+}
+
+// checkContract verifies that the response has a documented status, and that a JSON body decodes into the documented
+// type without unknown fields.
+func checkContract(route string, first *backend.CallResourceResponse, body []byte) error {
+    doc := apiDocs[route]
+    statuses := doc.successStatuses()
+    documented := false
+    for _, status := range statuses {
+        documented = documented || status == first.Status
+    }
+    if !documented {
+        return &contractError{route: route, message: "status " + http.StatusText(first.Status) + " is not documented: " + string(body)}
+    }
+    contentType := strings.Join(first.Headers["Content-Type"], "")
+    if doc.contentType() != "application/json" {
+        if !strings.HasPrefix(contentType, doc.contentType()) {
+            return &contractError{route: route, message: "content type " + contentType + " is not " + doc.contentType()}
+        }
+        return nil
+    }
+    if doc.Response == nil || len(body) == 0 {
+        return nil
+    }
+    err := decodeStrictly(body, reflect.TypeOf(doc.Response))
+    if err != nil && doc.Paged {
+        err = decodeStrictly(body, reflect.TypeOf(model.Page{}))
+    }
+    if err != nil {
+        return &contractError{route: route, message: "the body does not decode into " + reflect.TypeOf(doc.Response).String() + ": " + err.Error()}
+    }
+    return nil
+}
+
+type contractError struct {
+    route   string
+    message string
+}
+
+func (e *contractError) Error() string {
+    return e.route + ": " + e.message
+}
+
+func decodeStrictly(body []byte, t reflect.Type) error {
+    decoder := json.NewDecoder(bytes.NewReader(body))
+    decoder.DisallowUnknownFields()
+    return decoder.Decode(reflect.New(t).Interface())
+}
+
+func TestUncheckedRoutesExist(t *testing.T) {
+    createTestResourceHandler(t)
+    names := map[string]bool{}
+    for _, link := range links {
+        names[link.Name] = true
+    }
+    var unknown []string
+    for route := range uncheckedRoutes {
+        if !names[route] {
+            unknown = append(unknown, route)
+        }
+    }
+    sort.Strings(unknown)
+    if len(unknown) > 0 {
+        t.Errorf("unknown routes in uncheckedRoutes: %v", unknown)
+    }
+}
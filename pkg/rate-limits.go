@@ -0,0 +1,218 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "os"
+    . "regexp"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/handler"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// The plan of an organization rarely changes, so its limits are only read once a minute.
+const planLimitsCacheTime = time.Minute
+
+// When the plan limits can't be read, the read is tried again after this long.
+const planLimitsRetryTime = 10 * time.Second
+
+type cachedPlanLimits struct {
+    // loading is held while the limits are read, outside of the mutex of the RateLimiter, which guards the buckets.
+    loading sync.Mutex
+    limits  model.PlanLimits
+    loaded  time.Time
+}
+
+type bucketKey struct {
+    orgId int64
+    route string
+}
+
+type bucket struct {
+    tokens   float64
+    updated  time.Time
+    allowed  uint64
+    rejected uint64
+}
+
+// RateLimiter keeps a token bucket per organization and route class, so that a single organization calling a route in
+// a loop can't flood Pulsar and Cassandra for everyone else.
+type RateLimiter struct {
+    limits     map[string]model.RateLimit
+    planLimits func(orgId int64) (model.PlanLimits, error)
+    mutex      sync.Mutex
+    buckets    map[bucketKey]*bucket
+    plans      map[int64]*cachedPlanLimits
+}
+
+// CreateRateLimiter uses model.DefaultRateLimits, with the limits in the SENSETIF_RATE_LIMITS environment variable on
+// top, e.g. {"timeseries.update":{"rate":0.05,"burst":100,"perDatapoint":true}}.
+func CreateRateLimiter(planLimits func(orgId int64) (model.PlanLimits, error)) *RateLimiter {
+    limits := make(map[string]model.RateLimit)
+    for route, limit := range model.DefaultRateLimits {
+        limits[route] = limit
+    }
+    if config := os.Getenv("SENSETIF_RATE_LIMITS"); config != "" {
+        configured := make(map[string]model.RateLimit)
+        err := json.Unmarshal([]byte(config), &configured)
+        if err != nil {
+            log.DefaultLogger.Error(fmt.Sprintf("Ignoring SENSETIF_RATE_LIMITS, using the default limits: %s", err.Error()))
+        }
+        for route, limit := range configured {
+            if limit.Rate <= 0 || limit.Burst < 1 {
+                log.DefaultLogger.Error(fmt.Sprintf("Ignoring rate limit of %s, rate must be positive and burst at least 1", route))
+                continue
+            }
+            limits[route] = limit
+        }
+    }
+    return &RateLimiter{
+        limits:     limits,
+        planLimits: planLimits,
+        buckets:    make(map[bucketKey]*bucket),
+        plans:      make(map[int64]*cachedPlanLimits),
+    }
+}
+
+func (r *RateLimiter) Links() []Link {
+    return []Link{
+        {Name: "ratelimits.list", Role: model.Viewer, Method: "GET", Fn: r.ListRateLimits, Pattern: MustCompile(`^_ratelimits$`)},
+    }
+}
+
+// Allow takes a token from the bucket of the route class, or returns a model.RateLimitError telling when the next
+// token is available.
+func (r *RateLimiter) Allow(orgId int64, route string, write bool) error {
+    limit := r.limitOf(orgId, route, write)
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    b := r.refill(bucketKey{orgId, route}, limit)
+    if b.tokens < 1 {
+        b.rejected++
+        wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+        return &model.RateLimitError{Route: route, RetryAfter: wait}
+    }
+    b.tokens--
+    b.allowed++
+    return nil
+}
+
+// refill must be called with the mutex held. New buckets start full.
+func (r *RateLimiter) refill(key bucketKey, limit model.RateLimit) *bucket {
+    now := time.Now()
+    b, found := r.buckets[key]
+    if !found {
+        b = &bucket{tokens: limit.Burst, updated: now}
+        r.buckets[key] = b
+    }
+    b.tokens = math.Min(limit.Burst, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+    b.updated = now
+    return b
+}
+
+func (r *RateLimiter) limitOf(orgId int64, route string, write bool) model.RateLimit {
+    limit, found := r.limits[route]
+    if !found {
+        limit = r.limits[model.DefaultRateLimit]
+        if write {
+            limit = r.limits[model.DefaultWriteRateLimit]
+        }
+    }
+    if limit.PerDatapoint {
+        return limit.ForPlan(r.plan(orgId))
+    }
+    return limit
+}
+
+// plan returns the limits of the plan of the organization. If they can't be read, the ones read before are used, or
+// those of the free plan, and the read is tried again after a while.
+func (r *RateLimiter) plan(orgId int64) model.PlanLimits {
+    r.mutex.Lock()
+    cached, found := r.plans[orgId]
+    if !found {
+        cached = &cachedPlanLimits{limits: model.PlanLimits{MaxDatapoints: 50}}
+        r.plans[orgId] = cached
+    }
+    r.mutex.Unlock()
+
+    cached.loading.Lock()
+    defer cached.loading.Unlock()
+    if time.Since(cached.loaded) < planLimitsCacheTime {
+        return cached.limits
+    }
+    limits, err := r.planLimits(orgId)
+    if err != nil {
+        log.DefaultLogger.Error(fmt.Sprintf("Unable to read plan limits of %d, using the previous ones or the free plan: %s", orgId, err.Error()))
+        cached.loaded = time.Now().Add(planLimitsRetryTime - planLimitsCacheTime)
+        return cached.limits
+    }
+    cached.limits = limits
+    cached.loaded = time.Now()
+    return limits
+}
+
+// ListRateLimits returns the buckets of the organization; those that have been used since the plugin started, and
+// those with a limit of their own.
+//goland:noinspection GoUnusedParameter
+func (r *RateLimiter) ListRateLimits(orgId int64, params []string, body []byte, _ *handler.Request, clients *client.Clients) (*backend.CallResourceResponse, error) {
+    routes := map[string]bool{}
+    for route := range r.limits {
+        if route != model.DefaultRateLimit && route != model.DefaultWriteRateLimit {
+            routes[route] = true
+        }
+    }
+    r.mutex.Lock()
+    for key := range r.buckets {
+        if key.orgId == orgId {
+            routes[key.route] = true
+        }
+    }
+    r.mutex.Unlock()
+    result := make([]model.RateLimitStatus, 0, len(routes))
+    for route := range routes {
+        limit := r.limitOf(orgId, route, r.isWrite(route))
+        status := model.RateLimitStatus{Route: route, Rate: limit.Rate, Burst: limit.Burst, Tokens: limit.Burst}
+        r.mutex.Lock()
+        if _, found := r.buckets[bucketKey{orgId, route}]; found {
+            b := r.refill(bucketKey{orgId, route}, limit)
+            status.Tokens = math.Floor(b.tokens)
+            status.Allowed = b.allowed
+            status.Rejected = b.rejected
+        }
+        r.mutex.Unlock()
+        result = append(result, status)
+    }
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].Route < result[j].Route
+    })
+    rawJson, err := json.Marshal(result)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s", model.ErrServerError, err.Error())
+    }
+    return &backend.CallResourceResponse{
+        Status: http.StatusOK,
+        Body:   rawJson,
+    }, nil
+}
+
+// isWrite tells whether the route class changes something, i.e. is published rather than read.
+func (r *RateLimiter) isWrite(route string) bool {
+    if strings.HasPrefix(route, model.StreamRateLimitPrefix) {
+        return true
+    }
+    for _, link := range links {
+        if link.Name == route {
+            return link.Method != "GET"
+        }
+    }
+    return false
+}
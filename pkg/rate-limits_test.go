@@ -0,0 +1,52 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+)
+
+func TestPlanLimitsAreReadOutsideTheBucketLock(t *testing.T) {
+    reading := make(chan bool)
+    release := make(chan bool)
+    reads := 0
+    limiter := CreateRateLimiter(func(orgId int64) (model.PlanLimits, error) {
+        reads++
+        if reads == 1 {
+            reading <- true
+            <-release
+            return model.PlanLimits{MaxDatapoints: 500}, nil
+        }
+        return model.PlanLimits{}, errors.New("gocql: no hosts available in the pool")
+    })
+    done := make(chan error)
+    go func() {
+        done <- limiter.Allow(1, "timeseries.update", true)
+    }()
+    <-reading
+    // the buckets of other routes and organizations are usable while the plan of organization 1 is read
+    allowed := make(chan error)
+    go func() {
+        allowed <- limiter.Allow(2, "projects.list", false)
+    }()
+    select {
+    case err := <-allowed:
+        if err != nil {
+            t.Error(err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("Allow waited for the plan limits of another organization")
+    }
+    close(release)
+    if err := <-done; err != nil {
+        t.Error(err)
+    }
+
+    // when the plan can't be read again, the limits read before are kept
+    limiter.plans[1].loaded = time.Now().Add(-planLimitsCacheTime)
+    if plan := limiter.plan(1); plan.MaxDatapoints != 500 || reads != 2 {
+        t.Errorf("expected the previous plan after %d reads, got %+v", reads, plan)
+    }
+}
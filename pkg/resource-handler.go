@@ -2,75 +2,130 @@ package main
 
 import (
     "context"
+    "crypto/rand"
+    "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
     "net/http"
+    "net/url"
     . "regexp"
+    "strconv"
     "strings"
 
     "github.com/Sensetif/sensetif-datasource/pkg/client"
     "github.com/Sensetif/sensetif-datasource/pkg/handler"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
     "github.com/grafana/grafana-plugin-sdk-go/backend"
     "github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
 type ResourceHandler struct {
-    Clients *client.Clients
+    Clients        *client.Clients
+    Authorizations *Authorizations
+    RateLimiter    *RateLimiter
 }
 
 type Link struct {
+    Name    string     // route class, used for role overrides
+    Role    model.Role // minimum role required, unless overridden for the organization
+    Audit   bool       // accepted configuration changes are recorded in the audit trail
+    Version bool       // the request must carry If-Match with the ETag of the current settings, see handler.CheckVersion
     Pattern *Regexp
     Method  string
-    Fn      func(orgId int64, params []string, body []byte, clients *client.Clients) (*backend.CallResourceResponse, error)
+    Fn      func(orgId int64, params []string, body []byte, request *handler.Request, clients *client.Clients) (*backend.CallResourceResponse, error)
 }
 
-const projectRegexName = `[a-zA-Z][a-zA-Z0-9_.\-]*`
-const subsystemRegexName = `[a-zA-Z][a-zA-Z0-9_.\-]*`
-const datapointRegexName = `[a-zA-Z][a-zA-Z0-9_.\-$\[\]]*`
+const projectRegexName = model.ProjectNamePattern
+const subsystemRegexName = model.SubsystemNamePattern
+const datapointRegexName = model.DatapointNamePattern
+const templateRegexName = model.TemplateNamePattern
 
 var links = []Link{
     // Health??
-    {Method: "GET", Fn: Health, Pattern: MustCompile(`^/$`)},
+    {Name: "health", Role: model.Viewer, Method: "GET", Fn: Health, Pattern: MustCompile(`^/$`)},
 
     // Projects API
-    {Method: "GET", Fn: handler.ListProjects, Pattern: MustCompile(`^_$`)},
-    {Method: "GET", Fn: handler.GetProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
-    {Method: "PUT", Fn: handler.UpdateProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
-    {Method: "DELETE", Fn: handler.DeleteProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
-    {Method: "POST", Fn: handler.RenameProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
+    {Name: "projects.list", Role: model.Viewer, Method: "GET", Fn: handler.ListProjects, Pattern: MustCompile(`^_$`)},
+    {Name: "projects.get", Role: model.Viewer, Method: "GET", Fn: handler.GetProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
+    {Name: "projects.update", Role: model.Editor, Audit: true, Version: true, Method: "PUT", Fn: handler.UpdateProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
+    {Name: "projects.delete", Role: model.Admin, Audit: true, Version: true, Method: "DELETE", Fn: handler.DeleteProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
+    {Name: "projects.rename", Role: model.Admin, Audit: true, Version: true, Method: "POST", Fn: handler.RenameProject, Pattern: MustCompile(`^(` + projectRegexName + `)$`)},
 
     // Subsystems API
-    {Method: "GET", Fn: handler.ListSubsystems, Pattern: MustCompile(`^(` + projectRegexName + `)/_$`)},
-    {Method: "GET", Fn: handler.GetSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
-    {Method: "PUT", Fn: handler.UpdateSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
-    {Method: "DELETE", Fn: handler.DeleteSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
-    {Method: "POST", Fn: handler.RenameSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
+    {Name: "subsystems.list", Role: model.Viewer, Method: "GET", Fn: handler.ListSubsystems, Pattern: MustCompile(`^(` + projectRegexName + `)/_$`)},
+    {Name: "subsystems.get", Role: model.Viewer, Method: "GET", Fn: handler.GetSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
+    {Name: "subsystems.update", Role: model.Editor, Audit: true, Version: true, Method: "PUT", Fn: handler.UpdateSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
+    {Name: "subsystems.delete", Role: model.Admin, Audit: true, Version: true, Method: "DELETE", Fn: handler.DeleteSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
+    {Name: "subsystems.rename", Role: model.Admin, Audit: true, Version: true, Method: "POST", Fn: handler.RenameSubsystem, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
 
     // Datapoint API
-    {Method: "GET", Fn: handler.ListDatapoints, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/_$`)},
-    {Method: "GET", Fn: handler.GetDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
-    {Method: "PUT", Fn: handler.UpdateDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
-    {Method: "DELETE", Fn: handler.DeleteDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
-    {Method: "POST", Fn: handler.RenameDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
+    {Name: "datapoints.list", Role: model.Viewer, Method: "GET", Fn: handler.ListDatapoints, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/_$`)},
+    {Name: "datapoints.get", Role: model.Viewer, Method: "GET", Fn: handler.GetDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
+    {Name: "datapoints.update", Role: model.Editor, Audit: true, Version: true, Method: "PUT", Fn: handler.UpdateDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
+    {Name: "datapoints.delete", Role: model.Admin, Audit: true, Version: true, Method: "DELETE", Fn: handler.DeleteDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
+    {Name: "datapoints.rename", Role: model.Admin, Audit: true, Version: true, Method: "POST", Fn: handler.RenameDatapoint, Pattern: MustCompile(`^(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
 
     // Import API
-    {Method: "POST", Fn: handler.ImportLink2WebFvc1, Pattern: MustCompile(`^/_import/fvc1$`)},
-    {Method: "POST", Fn: handler.ImportTtnv3App, Pattern: MustCompile(`^/_import/ttnv3$`)},
+    {Name: "imports.fvc1", Role: model.Editor, Audit: true, Method: "POST", Fn: handler.ImportLink2WebFvc1, Pattern: MustCompile(`^/_import/fvc1$`)},
+    {Name: "imports.ttnv3", Role: model.Editor, Audit: true, Method: "POST", Fn: handler.ImportTtnv3App, Pattern: MustCompile(`^/_import/ttnv3$`)},
+    {Name: "imports.timeseries", Role: model.Editor, Method: "POST", Fn: handler.ImportTimeseries, Pattern: MustCompile(`^_import/_timeseries$`)},
+    {Name: "imports.bundle", Role: model.Editor, Audit: true, Method: "POST", Fn: handler.ImportBundle, Pattern: MustCompile(`^_import/bundle$`)},
+
+    // Clone API
+    {Name: "projects.clone", Role: model.Editor, Audit: true, Method: "POST", Fn: handler.CloneProject, Pattern: MustCompile(`^_clone/(` + projectRegexName + `)$`)},
+    {Name: "subsystems.clone", Role: model.Editor, Audit: true, Method: "POST", Fn: handler.CloneSubsystem, Pattern: MustCompile(`^_clone/(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
+
+    // Templates API
+    {Name: "templates.list", Role: model.Viewer, Method: "GET", Fn: handler.ListTemplates, Pattern: MustCompile(`^_templates$`)},
+    {Name: "templates.get", Role: model.Viewer, Method: "GET", Fn: handler.GetTemplate, Pattern: MustCompile(`^_templates/(` + templateRegexName + `)$`)},
+    {Name: "templates.update", Role: model.Editor, Audit: true, Version: true, Method: "PUT", Fn: handler.UpdateTemplate, Pattern: MustCompile(`^_templates/(` + templateRegexName + `)$`)},
+    {Name: "templates.delete", Role: model.Admin, Audit: true, Version: true, Method: "DELETE", Fn: handler.DeleteTemplate, Pattern: MustCompile(`^_templates/(` + templateRegexName + `)$`)},
+    {Name: "templates.instantiate", Role: model.Editor, Audit: true, Method: "POST", Fn: handler.InstantiateTemplate, Pattern: MustCompile(`^_templates/(` + templateRegexName + `)/_instantiate$`)},
+
+    // Datasource Test API
+    {Name: "tests.web", Role: model.Editor, Method: "POST", Fn: handler.TestWebDatasource, Pattern: MustCompile(`^_test/web$`)},
+    {Name: "tests.mqtt", Role: model.Editor, Method: "POST", Fn: handler.TestMqttDatasource, Pattern: MustCompile(`^_test/mqtt$`)},
+    {Name: "tests.expressions", Role: model.Editor, Method: "POST", Fn: handler.TestExpressions, Pattern: MustCompile(`^_test/expressions$`)},
+    {Name: "tests.scalefunc", Role: model.Editor, Method: "POST", Fn: handler.TestScaleFunc, Pattern: MustCompile(`^_test/scalefunc$`)},
+
+    // TTN Discovery API
+    {Name: "ttn.devices", Role: model.Editor, Method: "POST", Fn: handler.TtnDevices, Pattern: MustCompile(`^_ttn/devices$`)},
+    {Name: "ttn.points", Role: model.Editor, Method: "POST", Fn: handler.TtnPoints, Pattern: MustCompile(`^_ttn/points$`)},
+
+    // Export API
+    {Name: "projects.export", Role: model.Editor, Method: "GET", Fn: handler.ExportProject, Pattern: MustCompile(`^_export/(` + projectRegexName + `)$`)},
+    {Name: "timeseries.export", Role: model.Viewer, Method: "GET", Fn: handler.ExportTimeseries, Pattern: MustCompile(`^_export/_timeseries$`)},
+
+    // Freshness API
+    {Name: "freshness.report", Role: model.Viewer, Method: "GET", Fn: handler.FreshnessReport, Pattern: MustCompile(`^_freshness$`)},
+    {Name: "freshness.report", Role: model.Viewer, Method: "GET", Fn: handler.FreshnessReport, Pattern: MustCompile(`^_freshness/(` + projectRegexName + `)$`)},
+    {Name: "freshness.report", Role: model.Viewer, Method: "GET", Fn: handler.FreshnessReport, Pattern: MustCompile(`^_freshness/(` + projectRegexName + `)/(` + subsystemRegexName + `)$`)},
+
+    // Search API
+    {Name: "search", Role: model.Viewer, Method: "GET", Fn: handler.Search, Pattern: MustCompile(`^_search$`)},
+
+    // Commands API
+    {Name: "commands.get", Role: model.Viewer, Method: "GET", Fn: handler.GetCommand, Pattern: MustCompile(`^_commands/([0-9A-Za-z_.-]+)$`)},
+
+    // Audit API
+    {Name: "audit.list", Role: model.Admin, Method: "GET", Fn: handler.ListAudit, Pattern: MustCompile(`^_audit$`)},
 
     // Limits API
-    {Method: "GET", Fn: handler.CurrentLimits, Pattern: MustCompile(`^_limits/current$`)},
+    {Name: "limits.current", Role: model.Viewer, Method: "GET", Fn: handler.CurrentLimits, Pattern: MustCompile(`^_limits/current$`)},
+    {Name: "limits.usage", Role: model.Viewer, Method: "GET", Fn: handler.CurrentUsage, Pattern: MustCompile(`^_limits/usage$`)},
 
     // Plans API
-    {Method: "GET", Fn: handler.ListPlans, Pattern: MustCompile(`^_plans$`)},
-    {Method: "POST", Fn: handler.CheckOut, Pattern: MustCompile(`^_plans/checkout$`)},
-    {Method: "POST", Fn: handler.CheckOutSuccess, Pattern: MustCompile(`^_checkout/success$`)},
-    {Method: "POST", Fn: handler.CheckOutCancelled, Pattern: MustCompile(`^_checkout/cancelled$`)},
+    {Name: "plans.list", Role: model.Admin, Method: "GET", Fn: handler.ListPlans, Pattern: MustCompile(`^_plans$`)},
+    {Name: "plans.checkout", Role: model.Admin, Method: "POST", Fn: handler.CheckOut, Pattern: MustCompile(`^_plans/checkout$`)},
+    {Name: "checkout.success", Role: model.Admin, Method: "POST", Fn: handler.CheckOutSuccess, Pattern: MustCompile(`^_checkout/success$`)},
+    {Name: "checkout.cancelled", Role: model.Admin, Method: "POST", Fn: handler.CheckOutCancelled, Pattern: MustCompile(`^_checkout/cancelled$`)},
 
     // Organizations API
-    {Method: "GET", Fn: handler.GetOrganization, Pattern: MustCompile(`^_organization$`)},
+    {Name: "organization.get", Role: model.Viewer, Method: "GET", Fn: handler.GetOrganization, Pattern: MustCompile(`^_organization$`)},
 
     // Timeseries Update API
-    {Method: "PUT", Fn: handler.UpdateTimeseries, Pattern: MustCompile(`^_timeseries/(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
+    {Name: "timeseries.update", Role: model.Editor, Method: "PUT", Fn: handler.UpdateTimeseries, Pattern: MustCompile(`^_timeseries/(` + projectRegexName + `)/(` + subsystemRegexName + `)/(` + datapointRegexName + `)$`)},
 }
 
 //goland:noinspection GoUnusedParameter
@@ -81,32 +136,93 @@ func (p *ResourceHandler) CallResource(ctx context.Context, request *backend.Cal
         return err2
     }
     orgId := request.PluginContext.OrgID
-    log.DefaultLogger.Info(fmt.Sprintf("URL: %s; PATH: %s, Method: %s, OrgId: %d", request.URL, request.Path, request.Method, orgId))
+    requestId := requestIdOf(request)
+    log.DefaultLogger.Info(fmt.Sprintf("URL: %s; PATH: %s, Method: %s, OrgId: %d, RequestId: %s", request.URL, request.Path, request.Method, orgId, requestId))
 
+    path, query := splitQuery(request.URL)
+    stream := &streamSender{sender: sender, requestId: requestId}
+    info := &handler.Request{
+        Id:      requestId,
+        User:    request.PluginContext.User,
+        Query:   query,
+        Headers: request.Headers,
+        Authorize: func(route string) error {
+            return p.Authorizations.AuthorizeRoute(orgId, request.PluginContext.User, route)
+        },
+        Stream: stream,
+        Ctx:    ctx,
+    }
     for _, link := range links {
         if link.Method == request.Method {
-            parameters := link.Pattern.FindStringSubmatch(request.URL)
+            parameters := link.Pattern.FindStringSubmatch(path)
             if len(parameters) >= 1 {
-                result, err := link.Fn(orgId, parameters, request.Body, p.Clients)
-                if err == nil {
-                    log.DefaultLogger.Info(fmt.Sprintf("Result: %s", string(result.Body)))
-                    if result.Body == nil {
-                        result.Body = []byte("{}") // Maybe we always need to return a json body?
+                // authorized first, so that calls that are refused anyway don't use up the tokens of the organization
+                err := p.Authorizations.Authorize(orgId, request.PluginContext.User, link)
+                if err != nil {
+                    return sendProblem(err, requestId, sender)
+                }
+                err = p.RateLimiter.Allow(orgId, link.Name, link.Method != "GET")
+                if err != nil {
+                    return sendProblem(err, requestId, sender)
+                }
+                var before []byte
+                if link.Audit || link.Version {
+                    before, err = handler.Snapshot(orgId, link.Name, parameters, p.Clients)
+                    if err != nil && link.Version {
+                        // without the current settings, the version can't be checked
+                        return sendProblem(err, requestId, sender)
+                    }
+                    if err != nil {
+                        log.DefaultLogger.Error(fmt.Sprintf("Unable to read the state before the change of %v: %s", parameters, err.Error()))
                     }
-                    sendErr := sender.Send(result)
-                    if sendErr != nil {
-                        log.DefaultLogger.Error("could not write response to the client. " + sendErr.Error())
-                        return sendErr
+                }
+                if link.Version {
+                    err = handler.CheckVersion(before, info)
+                    if err != nil {
+                        return sendProblem(err, requestId, sender)
                     }
+                }
+                result, err := link.Fn(orgId, parameters, request.Body, info, p.Clients)
+                if err != nil && stream.started {
+                    // the status is sent already, so all that can be done is to cut the response short
+                    log.DefaultLogger.Error(fmt.Sprintf("Streamed response of %s failed: %s", link.Name, err.Error()))
+                    return err
+                }
+                if err != nil {
+                    return sendProblem(err, requestId, sender)
+                }
+                if result == nil {
+                    // the handler streamed the response through info.Stream
                     return nil
                 }
+                if link.Audit && result.Status == http.StatusAccepted {
+                    after := request.Body
+                    if request.Method == "DELETE" {
+                        after = nil
+                    }
+                    handler.RecordAudit(orgId, link.Name, parameters, before, after, info, p.Clients)
+                }
+                log.DefaultLogger.Info(fmt.Sprintf("Result: %s", string(result.Body)))
+                if result.Body == nil {
+                    result.Body = []byte("{}") // Maybe we always need to return a json body?
+                }
+                if result.Headers == nil {
+                    result.Headers = make(map[string][]string)
+                }
+                result.Headers[requestIdHeader] = []string{requestId}
+                sendErr := sender.Send(result)
+                if sendErr != nil {
+                    log.DefaultLogger.Error("could not write response to the client. " + sendErr.Error())
+                    return sendErr
+                }
+                return nil
             }
         }
     }
-    return notFound("", sender)
+    return sendProblem(fmt.Errorf("%w: no resource for %s %s", model.ErrNotFound, request.Method, request.URL), requestId, sender)
 }
 
-func Health(_ int64, _ []string, body []byte, _ *client.Clients) (*backend.CallResourceResponse, error) {
+func Health(_ int64, _ []string, body []byte, _ *handler.Request, _ *client.Clients) (*backend.CallResourceResponse, error) {
     body = []byte{}
     return &backend.CallResourceResponse{
         Status: http.StatusOK,
@@ -132,19 +248,85 @@ func handleFileRequests(request *backend.CallResourceRequest, sender backend.Cal
     return nil, false
 }
 
-func notFound(message string, sender backend.CallResourceResponseSender) error {
-    return sender.Send(&backend.CallResourceResponse{
-        Status: http.StatusNotFound,
-        Body:   createMessageJSON(message),
-    })
+// splitQuery separates the path, which the links are matched against, from the query parameters.
+func splitQuery(resourceUrl string) (string, url.Values) {
+    path, rawQuery, found := strings.Cut(resourceUrl, "?")
+    if !found {
+        return path, url.Values{}
+    }
+    query, err := url.ParseQuery(rawQuery)
+    if err != nil {
+        log.DefaultLogger.Warn(fmt.Sprintf("Ignoring malformed query \"%s\": %s", rawQuery, err.Error()))
+        return path, url.Values{}
+    }
+    return path, query
 }
 
-func createMessageJSON(message string) []byte {
-    response, _ := json.Marshal(struct {
-        Message string `json:"message"`
-    }{
-        Message: message,
-    })
+const requestIdHeader = "X-Request-Id"
+
+// streamSender adds the request id to the first part of a streamed response, and remembers that it was sent.
+type streamSender struct {
+    sender    backend.CallResourceResponseSender
+    requestId string
+    started   bool
+}
+
+func (s *streamSender) Send(response *backend.CallResourceResponse) error {
+    if !s.started {
+        if response.Headers == nil {
+            response.Headers = make(map[string][]string)
+        }
+        response.Headers[requestIdHeader] = []string{s.requestId}
+        s.started = true
+    }
+    return s.sender.Send(response)
+}
 
-    return response
+// requestIdOf returns the request id given by the caller, or creates a new one.
+func requestIdOf(request *backend.CallResourceRequest) string {
+    for name, values := range request.Headers {
+        if strings.EqualFold(name, requestIdHeader) && len(values) > 0 && values[0] != "" {
+            return values[0]
+        }
+    }
+    id := make([]byte, 8)
+    _, _ = rand.Read(id)
+    return hex.EncodeToString(id)
+}
+
+// sendProblem maps the error to its HTTP status and sends a model.Problem body. Details of internal errors are only
+// logged, and not exposed to the client.
+func sendProblem(err error, requestId string, sender backend.CallResourceResponseSender) error {
+    status := model.HttpStatusOf(err)
+    message := err.Error()
+    if status == http.StatusInternalServerError {
+        log.DefaultLogger.Error(fmt.Sprintf("Request %s failed: %+v", requestId, err))
+        message = model.ErrServerError.Error()
+    } else {
+        log.DefaultLogger.Info(fmt.Sprintf("Request %s rejected: %s", requestId, message))
+    }
+    problem := model.Problem{
+        Status:    status,
+        Error:     http.StatusText(status),
+        Message:   message,
+        RequestId: requestId,
+    }
+    var validationErr *model.ValidationError
+    if errors.As(err, &validationErr) {
+        problem.Errors = validationErr.Errors
+    }
+    headers := map[string][]string{
+        "Content-Type":  {"application/problem+json"},
+        requestIdHeader: {requestId},
+    }
+    var rateLimitErr *model.RateLimitError
+    if errors.As(err, &rateLimitErr) {
+        headers["Retry-After"] = []string{strconv.Itoa(rateLimitErr.RetryAfterSeconds())}
+    }
+    body, _ := json.Marshal(problem)
+    return sender.Send(&backend.CallResourceResponse{
+        Status:  status,
+        Headers: headers,
+        Body:    body,
+    })
 }
@@ -0,0 +1,282 @@
+package scalefunc
+
+import (
+    "math"
+    "sort"
+    "strings"
+)
+
+// function is a built-in function. maxArgs is -1 for any number of arguments, and argKind tells what argument i of
+// n must be. check, if set, does the checks of the arguments that need their values, like the tables of interp().
+type function struct {
+    minArgs int
+    maxArgs int
+    result  kind
+    argKind func(i int, n int) kind
+    check   func(p *parser, call *callNode) error
+    eval    func(e *evaluator, call *callNode) (float64, error)
+}
+
+// constants are the names, other than x, that can be used as values.
+var constants = map[string]float64{
+    "pi": math.Pi,
+    "e":  math.E,
+}
+
+var functions = map[string]*function{
+    "abs":   math1(math.Abs),
+    "sign":  math1(sign),
+    "sqrt":  math1(math.Sqrt),
+    "cbrt":  math1(math.Cbrt),
+    "exp":   math1(math.Exp),
+    "ln":    math1(math.Log),
+    "log10": math1(math.Log10),
+    "log2":  math1(math.Log2),
+    "sin":   math1(math.Sin),
+    "cos":   math1(math.Cos),
+    "tan":   math1(math.Tan),
+    "asin":  math1(math.Asin),
+    "acos":  math1(math.Acos),
+    "atan":  math1(math.Atan),
+    "sinh":  math1(math.Sinh),
+    "cosh":  math1(math.Cosh),
+    "tanh":  math1(math.Tanh),
+    "floor": math1(math.Floor),
+    "ceil":  math1(math.Ceil),
+    "trunc": math1(math.Trunc),
+    "log": math2(func(v float64, base float64) float64 {
+        return math.Log(v) / math.Log(base)
+    }),
+    "pow":   math2(math.Pow),
+    "atan2": math2(math.Atan2),
+    "hypot": math2(math.Hypot),
+    // round(v) rounds half away from zero, and round(v, n) to n decimals
+    "round": {minArgs: 1, maxArgs: 2, argKind: numbersOnly, check: checkRound, eval: func(e *evaluator, call *callNode) (float64, error) {
+        args, err := call.numbers(e, 0)
+        if err != nil {
+            return 0, err
+        }
+        if len(args) == 1 {
+            return math.Round(args[0]), nil
+        }
+        if args[1] != math.Trunc(args[1]) || args[1] < 0 || args[1] > 15 {
+            return 0, e.fail(call.pos, "the decimals of round() must be a whole number from 0 to 15")
+        }
+        scale := math.Pow(10, args[1])
+        return call.finite(e, math.Round(args[0]*scale)/scale, args)
+    }},
+    "min": {minArgs: 2, maxArgs: -1, argKind: numbersOnly, eval: func(e *evaluator, call *callNode) (float64, error) {
+        args, err := call.numbers(e, 0)
+        if err != nil {
+            return 0, err
+        }
+        result := args[0]
+        for _, v := range args[1:] {
+            result = math.Min(result, v)
+        }
+        return result, nil
+    }},
+    "max": {minArgs: 2, maxArgs: -1, argKind: numbersOnly, eval: func(e *evaluator, call *callNode) (float64, error) {
+        args, err := call.numbers(e, 0)
+        if err != nil {
+            return 0, err
+        }
+        result := args[0]
+        for _, v := range args[1:] {
+            result = math.Max(result, v)
+        }
+        return result, nil
+    }},
+    // clamp(v, lo, hi) limits v to the range lo to hi
+    "clamp": {minArgs: 3, maxArgs: 3, argKind: numbersOnly, check: checkClamp, eval: func(e *evaluator, call *callNode) (float64, error) {
+        args, err := call.numbers(e, 0)
+        if err != nil {
+            return 0, err
+        }
+        if args[1] > args[2] {
+            return 0, e.fail(call.pos, "the low limit %s of clamp() is above the high limit %s", formatNumber(args[1]), formatNumber(args[2]))
+        }
+        return math.Max(args[1], math.Min(args[2], args[0])), nil
+    }},
+    // if(c, a, b) is a if c is true and b if not; only the chosen one is evaluated
+    "if": {minArgs: 3, maxArgs: 3, argKind: conditionFirst, eval: func(e *evaluator, call *callNode) (float64, error) {
+        c, err := call.args[0].eval(e)
+        if err != nil {
+            return 0, err
+        }
+        if c != 0 {
+            return call.args[1].eval(e)
+        }
+        return call.args[2].eval(e)
+    }},
+    // piecewise(c1, v1, c2, v2, ..., default) is the value after the first true condition, or the default
+    "piecewise": {minArgs: 3, maxArgs: -1, argKind: conditionPairs, check: checkPiecewise, eval: func(e *evaluator, call *callNode) (float64, error) {
+        last := len(call.args) - 1
+        for i := 0; i < last; i += 2 {
+            c, err := call.args[i].eval(e)
+            if err != nil {
+                return 0, err
+            }
+            if c != 0 {
+                return call.args[i+1].eval(e)
+            }
+        }
+        return call.args[last].eval(e)
+    }},
+    // poly(v, c0, c1, ..., cn) is c0 + c1*v + ... + cn*v^n, the usual form of calibration curves
+    "poly": {minArgs: 2, maxArgs: -1, argKind: numbersOnly, eval: func(e *evaluator, call *callNode) (float64, error) {
+        args, err := call.numbers(e, 0)
+        if err != nil {
+            return 0, err
+        }
+        result := 0.0
+        for i := len(args) - 1; i > 0; i-- {
+            result = result*args[0] + args[i]
+        }
+        return call.finite(e, result, args)
+    }},
+    // interp(v, xs, ys) interpolates linearly in the table of points (xs[i], ys[i]). Below the first and above the
+    // last point, the value of that point is used.
+    "interp": {minArgs: 3, maxArgs: 3, argKind: valueAndTables, check: checkTables(2), eval: func(e *evaluator, call *callNode) (float64, error) {
+        v, err := call.args[0].eval(e)
+        if err != nil {
+            return 0, err
+        }
+        xs, ys := call.args[1].(*listNode).values, call.args[2].(*listNode).values
+        i := sort.SearchFloat64s(xs, v)
+        switch {
+        case i == 0:
+            return ys[0], nil
+        case i == len(xs):
+            return ys[len(ys)-1], nil
+        }
+        return ys[i-1] + (ys[i]-ys[i-1])*(v-xs[i-1])/(xs[i]-xs[i-1]), nil
+    }},
+    // step(v, xs, ys) is ys[i] of the last xs[i] that is at most v, or ys[0] if v is below all of them
+    "step": {minArgs: 3, maxArgs: 3, argKind: valueAndTables, check: checkTables(1), eval: func(e *evaluator, call *callNode) (float64, error) {
+        v, err := call.args[0].eval(e)
+        if err != nil {
+            return 0, err
+        }
+        xs, ys := call.args[1].(*listNode).values, call.args[2].(*listNode).values
+        i := sort.Search(len(xs), func(i int) bool { return xs[i] > v })
+        if i == 0 {
+            return ys[0], nil
+        }
+        return ys[i-1], nil
+    }},
+}
+
+func math1(fn func(float64) float64) *function {
+    return &function{minArgs: 1, maxArgs: 1, argKind: numbersOnly, eval: func(e *evaluator, call *callNode) (float64, error) {
+        args, err := call.numbers(e, 0)
+        if err != nil {
+            return 0, err
+        }
+        return call.finite(e, fn(args[0]), args)
+    }}
+}
+
+func math2(fn func(float64, float64) float64) *function {
+    return &function{minArgs: 2, maxArgs: 2, argKind: numbersOnly, eval: func(e *evaluator, call *callNode) (float64, error) {
+        args, err := call.numbers(e, 0)
+        if err != nil {
+            return 0, err
+        }
+        return call.finite(e, fn(args[0], args[1]), args)
+    }}
+}
+
+func sign(v float64) float64 {
+    switch {
+    case v > 0:
+        return 1
+    case v < 0:
+        return -1
+    }
+    return 0
+}
+
+// finite fails if a function isn't defined for the arguments, like ln(0), instead of returning NaN or an infinity.
+func (n *callNode) finite(e *evaluator, result float64, args []float64) (float64, error) {
+    if math.IsNaN(result) || math.IsInf(result, 0) {
+        formatted := make([]string, 0, len(args))
+        for _, arg := range args {
+            formatted = append(formatted, formatNumber(arg))
+        }
+        return 0, e.fail(n.pos, "%s(%s) is not a finite number", n.name, strings.Join(formatted, ", "))
+    }
+    return result, nil
+}
+
+func numbersOnly(int, int) kind {
+    return numberKind
+}
+
+func conditionFirst(i int, _ int) kind {
+    if i == 0 {
+        return conditionKind
+    }
+    return numberKind
+}
+
+func conditionPairs(i int, n int) kind {
+    if i%2 == 0 && i < n-1 {
+        return conditionKind
+    }
+    return numberKind
+}
+
+func valueAndTables(i int, _ int) kind {
+    if i == 0 {
+        return numberKind
+    }
+    return listKind
+}
+
+// checkRound checks the decimals of round(), if they are a constant.
+func checkRound(p *parser, call *callNode) error {
+    if len(call.args) < 2 {
+        return nil
+    }
+    if decimals, ok := call.args[1].(*numberNode); ok && (decimals.value != math.Trunc(decimals.value) || decimals.value < 0 || decimals.value > 15) {
+        return p.fail(call.pos, "the decimals of round() must be a whole number from 0 to 15")
+    }
+    return nil
+}
+
+// checkClamp checks the limits of clamp(), if they are constants.
+func checkClamp(p *parser, call *callNode) error {
+    lo, loConstant := call.args[1].(*numberNode)
+    hi, hiConstant := call.args[2].(*numberNode)
+    if loConstant && hiConstant && lo.value > hi.value {
+        return p.fail(call.pos, "the low limit %s of clamp() is above the high limit %s", formatNumber(lo.value), formatNumber(hi.value))
+    }
+    return nil
+}
+
+func checkPiecewise(p *parser, call *callNode) error {
+    if len(call.args)%2 == 0 {
+        return p.fail(call.pos, "piecewise() takes pairs of a condition and a value, and then the default value")
+    }
+    return nil
+}
+
+// checkTables checks that the tables of interp() and step() have the same length, and that xs is increasing.
+func checkTables(minSize int) func(p *parser, call *callNode) error {
+    return func(p *parser, call *callNode) error {
+        xs, ys := call.args[1].(*listNode), call.args[2].(*listNode)
+        if len(xs.values) < minSize {
+            return p.fail(xs.pos, "the table of %s() must have at least %d points", call.name, minSize)
+        }
+        if len(xs.values) != len(ys.values) {
+            return p.fail(ys.pos, "the lists of %s() must have the same length, but have %d and %d values", call.name, len(xs.values), len(ys.values))
+        }
+        for i := 1; i < len(xs.values); i++ {
+            if xs.values[i] <= xs.values[i-1] {
+                return p.fail(xs.pos, "the x values of %s() must be increasing, but %s comes after %s", call.name, formatNumber(xs.values[i]), formatNumber(xs.values[i-1]))
+            }
+        }
+        return nil
+    }
+}
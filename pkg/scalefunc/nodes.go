@@ -0,0 +1,221 @@
+package scalefunc
+
+import (
+    "math"
+    "strconv"
+)
+
+// kind is the type of value a node evaluates to. Conditions evaluate to 1 or 0, and lists are only allowed as the
+// tables of interp() and step(), which read them directly.
+type kind int
+
+const (
+    numberKind kind = iota
+    conditionKind
+    listKind
+)
+
+func (k kind) String() string {
+    switch k {
+    case conditionKind:
+        return "a condition"
+    case listKind:
+        return "a list"
+    }
+    return "a number"
+}
+
+type node interface {
+    eval(e *evaluator) (float64, error)
+    kind() kind
+    position() int
+}
+
+// numberNode is a number, or the value of a part of the expression that doesn't use x, which may be a condition.
+type numberNode struct {
+    pos       int
+    value     float64
+    condition bool
+}
+
+func (n *numberNode) eval(e *evaluator) (float64, error) {
+    return n.value, e.step(n.pos, 1)
+}
+
+func (n *numberNode) kind() kind {
+    if n.condition {
+        return conditionKind
+    }
+    return numberKind
+}
+
+func (n *numberNode) position() int { return n.pos }
+
+// inputNode is x, the value to scale.
+type inputNode struct {
+    pos int
+}
+
+func (n *inputNode) eval(e *evaluator) (float64, error) {
+    return e.x, e.step(n.pos, 1)
+}
+
+func (n *inputNode) kind() kind    { return numberKind }
+func (n *inputNode) position() int { return n.pos }
+
+type listNode struct {
+    pos    int
+    values []float64
+}
+
+func (n *listNode) eval(e *evaluator) (float64, error) {
+    return 0, e.fail(n.pos, "a list has no value")
+}
+
+func (n *listNode) kind() kind    { return listKind }
+func (n *listNode) position() int { return n.pos }
+
+type unaryNode struct {
+    pos     int
+    op      string
+    operand node
+}
+
+func (n *unaryNode) eval(e *evaluator) (float64, error) {
+    v, err := n.operand.eval(e)
+    if err != nil {
+        return 0, err
+    }
+    if err = e.step(n.pos, 1); err != nil {
+        return 0, err
+    }
+    if n.op == "!" {
+        return boolValue(v == 0), nil
+    }
+    return -v, nil
+}
+
+func (n *unaryNode) kind() kind {
+    if n.op == "!" {
+        return conditionKind
+    }
+    return numberKind
+}
+
+func (n *unaryNode) position() int { return n.pos }
+
+type binaryNode struct {
+    pos         int
+    op          string
+    left, right node
+}
+
+func (n *binaryNode) eval(e *evaluator) (float64, error) {
+    a, err := n.left.eval(e)
+    if err != nil {
+        return 0, err
+    }
+    // && and || don't evaluate the right side when the left decides, like in x > 0 && ln(x) > 1
+    switch {
+    case n.op == "&&" && a == 0:
+        return 0, nil
+    case n.op == "||" && a != 0:
+        return 1, nil
+    }
+    b, err := n.right.eval(e)
+    if err != nil {
+        return 0, err
+    }
+    if err = e.step(n.pos, 1); err != nil {
+        return 0, err
+    }
+    var result float64
+    switch n.op {
+    case "&&", "||":
+        return boolValue(b != 0), nil
+    case "==":
+        return boolValue(a == b), nil
+    case "!=":
+        return boolValue(a != b), nil
+    case "<":
+        return boolValue(a < b), nil
+    case "<=":
+        return boolValue(a <= b), nil
+    case ">":
+        return boolValue(a > b), nil
+    case ">=":
+        return boolValue(a >= b), nil
+    case "+":
+        result = a + b
+    case "-":
+        result = a - b
+    case "*":
+        result = a * b
+    case "/", "%":
+        if b == 0 {
+            return 0, e.fail(n.pos, "division by zero")
+        }
+        if n.op == "/" {
+            result = a / b
+        } else {
+            result = math.Mod(a, b)
+        }
+    case "^":
+        result = math.Pow(a, b)
+    }
+    if math.IsNaN(result) || math.IsInf(result, 0) {
+        return 0, e.fail(n.pos, "%s %s %s is not a finite number", formatNumber(a), n.op, formatNumber(b))
+    }
+    return result, nil
+}
+
+func (n *binaryNode) kind() kind {
+    switch n.op {
+    case "&&", "||", "==", "!=", "<", "<=", ">", ">=":
+        return conditionKind
+    }
+    return numberKind
+}
+
+func (n *binaryNode) position() int { return n.pos }
+
+type callNode struct {
+    pos  int
+    name string
+    fn   *function
+    args []node
+}
+
+func (n *callNode) eval(e *evaluator) (float64, error) {
+    if err := e.step(n.pos, 1); err != nil {
+        return 0, err
+    }
+    return n.fn.eval(e, n)
+}
+
+func (n *callNode) kind() kind    { return n.fn.result }
+func (n *callNode) position() int { return n.pos }
+
+// numbers evaluates all arguments from the first.
+func (n *callNode) numbers(e *evaluator, first int) ([]float64, error) {
+    values := make([]float64, 0, len(n.args)-first)
+    for _, arg := range n.args[first:] {
+        v, err := arg.eval(e)
+        if err != nil {
+            return nil, err
+        }
+        values = append(values, v)
+    }
+    return values, nil
+}
+
+func boolValue(b bool) float64 {
+    if b {
+        return 1
+    }
+    return 0
+}
+
+func formatNumber(v float64) string {
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,440 @@
+package scalefunc
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+type tokenType int
+
+const (
+    endToken tokenType = iota
+    numberToken
+    nameToken
+    operatorToken
+)
+
+type token struct {
+    typ  tokenType
+    pos  int
+    text string
+}
+
+// operators longest first, so that <= is found before <
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "^", "!", "(", ")", "[", "]", ","}
+
+// precedence of the binary operators, except ^ which binds tighter than the unary ones and is handled by parsePower
+var precedence = map[string]int{
+    "||": 1,
+    "&&": 2,
+    "==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+    "+": 4, "-": 4,
+    "*": 5, "/": 5, "%": 5,
+}
+
+type parser struct {
+    expression string
+    pos        int
+    token      token
+    nodes      int
+    depth      int
+}
+
+func (p *parser) fail(pos int, format string, args ...interface{}) error {
+    return &SyntaxError{Expression: p.expression, Position: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parse() (node, error) {
+    if strings.TrimSpace(p.expression) == "" {
+        return nil, p.fail(0, "the expression is empty")
+    }
+    if len(p.expression) > MaxLength {
+        return nil, p.fail(MaxLength, "the expression is longer than %d characters", MaxLength)
+    }
+    if err := p.next(); err != nil {
+        return nil, err
+    }
+    root, err := p.parseBinary(1)
+    if err != nil {
+        return nil, err
+    }
+    if p.token.typ != endToken {
+        return nil, p.fail(p.token.pos, "unexpected '%s'", p.token.text)
+    }
+    if root.kind() != numberKind {
+        return nil, p.fail(root.position(), "the function must give a number, not %s", root.kind())
+    }
+    return root, nil
+}
+
+// next reads the next token into p.token.
+func (p *parser) next() error {
+    for p.pos < len(p.expression) && strings.ContainsRune(" \t\r\n", rune(p.expression[p.pos])) {
+        p.pos++
+    }
+    start := p.pos
+    if p.pos == len(p.expression) {
+        p.token = token{typ: endToken, pos: start, text: "end of the expression"}
+        return nil
+    }
+    c := p.expression[p.pos]
+    switch {
+    case isDigit(c) || c == '.':
+        for p.pos < len(p.expression) && (isDigit(p.expression[p.pos]) || p.expression[p.pos] == '.') {
+            p.pos++
+        }
+        if p.pos < len(p.expression) && (p.expression[p.pos] == 'e' || p.expression[p.pos] == 'E') {
+            exponent := p.pos + 1
+            if exponent < len(p.expression) && (p.expression[exponent] == '+' || p.expression[exponent] == '-') {
+                exponent++
+            }
+            if exponent < len(p.expression) && isDigit(p.expression[exponent]) {
+                p.pos = exponent
+                for p.pos < len(p.expression) && isDigit(p.expression[p.pos]) {
+                    p.pos++
+                }
+            }
+        }
+        p.token = token{typ: numberToken, pos: start, text: p.expression[start:p.pos]}
+        return nil
+    case isLetter(c):
+        for p.pos < len(p.expression) && (isLetter(p.expression[p.pos]) || isDigit(p.expression[p.pos])) {
+            p.pos++
+        }
+        p.token = token{typ: nameToken, pos: start, text: p.expression[start:p.pos]}
+        return nil
+    }
+    for _, op := range operators {
+        if strings.HasPrefix(p.expression[p.pos:], op) {
+            p.pos += len(op)
+            p.token = token{typ: operatorToken, pos: start, text: op}
+            return nil
+        }
+    }
+    switch c {
+    case '=':
+        return p.fail(start, "use == to compare")
+    case '&', '|':
+        return p.fail(start, "use %c%c", c, c)
+    }
+    return p.fail(start, "unexpected character '%c'", c)
+}
+
+func (p *parser) is(text string) bool {
+    return p.token.typ == operatorToken && p.token.text == text
+}
+
+// add counts the nodes, so that the size of an expression is limited also when it is short, and folds them.
+func (p *parser) add(n node) (node, error) {
+    p.nodes++
+    if p.nodes > MaxNodes {
+        return nil, p.fail(n.position(), "the expression has more than %d parts", MaxNodes)
+    }
+    return p.fold(n)
+}
+
+// fold replaces a node whose operands don't use x by its value, so that the errors of the parts that are the same
+// for all inputs, like 1/0 or sqrt(-1), are found by Compile instead of by every evaluation. Lists are kept, as
+// interp() and step() read them directly.
+func (p *parser) fold(n node) (node, error) {
+    var operands []node
+    switch v := n.(type) {
+    case *unaryNode:
+        operands = []node{v.operand}
+    case *binaryNode:
+        if divisor, ok := v.right.(*numberNode); ok && divisor.value == 0 && (v.op == "/" || v.op == "%") {
+            return nil, p.fail(v.pos, "division by zero")
+        }
+        operands = []node{v.left, v.right}
+    case *callNode:
+        operands = v.args
+    default:
+        return n, nil
+    }
+    for _, operand := range operands {
+        switch operand.(type) {
+        case *numberNode, *listNode:
+        default:
+            return n, nil
+        }
+    }
+    e := &evaluator{f: &Func{expression: p.expression}}
+    value, err := n.eval(e)
+    if err == nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+        err = e.fail(n.position(), "the value is %g", value)
+    }
+    if evalErr, ok := err.(*EvalError); ok {
+        return nil, p.fail(evalErr.Position, "%s", evalErr.Message)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &numberNode{pos: n.position(), value: value, condition: n.kind() == conditionKind}, nil
+}
+
+func (p *parser) expect(n node, k kind) error {
+    if n.kind() != k {
+        return p.fail(n.position(), "expected %s but found %s", k, n.kind())
+    }
+    return nil
+}
+
+// parseBinary parses the operators of at least the given precedence, left to right.
+func (p *parser) parseBinary(minPrecedence int) (node, error) {
+    left, err := p.parseUnary()
+    if err != nil {
+        return nil, err
+    }
+    for p.token.typ == operatorToken && precedence[p.token.text] >= minPrecedence {
+        op := p.token
+        prec := precedence[op.text]
+        if err = p.next(); err != nil {
+            return nil, err
+        }
+        right, err := p.parseBinary(prec + 1)
+        if err != nil {
+            return nil, err
+        }
+        operands := numberKind
+        if op.text == "&&" || op.text == "||" {
+            operands = conditionKind
+        }
+        if err = p.expect(left, operands); err != nil {
+            return nil, err
+        }
+        if err = p.expect(right, operands); err != nil {
+            return nil, err
+        }
+        left, err = p.add(&binaryNode{pos: op.pos, op: op.text, left: left, right: right})
+        if err != nil {
+            return nil, err
+        }
+        if prec == precedence["<"] && p.token.typ == operatorToken && precedence[p.token.text] == prec {
+            return nil, p.fail(p.token.pos, "comparisons can't be chained, combine them with &&")
+        }
+    }
+    return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+    p.depth++
+    defer func() { p.depth-- }()
+    if p.depth > MaxDepth {
+        return nil, p.fail(p.token.pos, "the expression is nested more than %d levels", MaxDepth)
+    }
+    if p.is("-") || p.is("+") || p.is("!") {
+        op := p.token
+        if err := p.next(); err != nil {
+            return nil, err
+        }
+        operand, err := p.parseUnary()
+        if err != nil {
+            return nil, err
+        }
+        operands := numberKind
+        if op.text == "!" {
+            operands = conditionKind
+        }
+        if err = p.expect(operand, operands); err != nil {
+            return nil, err
+        }
+        if op.text == "+" {
+            return operand, nil
+        }
+        return p.add(&unaryNode{pos: op.pos, op: op.text, operand: operand})
+    }
+    return p.parsePower()
+}
+
+// parsePower parses a ^ b, which is right associative and binds tighter than a unary minus on its left, so that
+// -x^2 is -(x^2) and 2^-x is 2^(-x).
+func (p *parser) parsePower() (node, error) {
+    base, err := p.parsePrimary()
+    if err != nil {
+        return nil, err
+    }
+    if !p.is("^") {
+        return base, nil
+    }
+    op := p.token
+    if err = p.next(); err != nil {
+        return nil, err
+    }
+    exponent, err := p.parseUnary()
+    if err != nil {
+        return nil, err
+    }
+    if err = p.expect(base, numberKind); err != nil {
+        return nil, err
+    }
+    if err = p.expect(exponent, numberKind); err != nil {
+        return nil, err
+    }
+    return p.add(&binaryNode{pos: op.pos, op: "^", left: base, right: exponent})
+}
+
+func (p *parser) parsePrimary() (node, error) {
+    t := p.token
+    switch {
+    case t.typ == numberToken:
+        value, err := strconv.ParseFloat(t.text, 64)
+        if err != nil || math.IsInf(value, 0) {
+            return nil, p.fail(t.pos, "\"%s\" is not a valid number", t.text)
+        }
+        if err = p.next(); err != nil {
+            return nil, err
+        }
+        return p.add(&numberNode{pos: t.pos, value: value})
+    case t.typ == nameToken:
+        if err := p.next(); err != nil {
+            return nil, err
+        }
+        if p.is("(") {
+            return p.parseCall(t)
+        }
+        if t.text == "x" {
+            return p.add(&inputNode{pos: t.pos})
+        }
+        if value, ok := constants[t.text]; ok {
+            return p.add(&numberNode{pos: t.pos, value: value})
+        }
+        if _, ok := functions[t.text]; ok {
+            return nil, p.fail(p.token.pos, "%s is a function, expected ( after it", t.text)
+        }
+        return nil, p.fail(t.pos, "unknown name \"%s\", the input value is x", t.text)
+    case p.is("("):
+        if err := p.next(); err != nil {
+            return nil, err
+        }
+        inner, err := p.parseBinary(1)
+        if err != nil {
+            return nil, err
+        }
+        if !p.is(")") {
+            return nil, p.fail(t.pos, "the ( is not closed")
+        }
+        return inner, p.next()
+    case p.is("["):
+        return p.parseList()
+    }
+    return nil, p.fail(t.pos, "expected a value but found '%s'", t.text)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+    fn, ok := functions[name.text]
+    if !ok {
+        return nil, p.fail(name.pos, "unknown function %s(), expected one of %s", name.text, strings.Join(functionNames(), ", "))
+    }
+    call := &callNode{pos: name.pos, name: name.text, fn: fn}
+    if err := p.next(); err != nil {
+        return nil, err
+    }
+    for !p.is(")") {
+        if len(call.args) > 0 {
+            if !p.is(",") {
+                return nil, p.fail(p.token.pos, "expected , or ) in the arguments of %s()", name.text)
+            }
+            if err := p.next(); err != nil {
+                return nil, err
+            }
+        }
+        arg, err := p.parseBinary(1)
+        if err != nil {
+            return nil, err
+        }
+        call.args = append(call.args, arg)
+    }
+    if err := p.next(); err != nil {
+        return nil, err
+    }
+    n := len(call.args)
+    switch {
+    case fn.maxArgs == fn.minArgs && n != fn.minArgs:
+        return nil, p.fail(name.pos, "%s() takes %s, not %d", name.text, arguments(fn.minArgs), n)
+    case n < fn.minArgs:
+        return nil, p.fail(name.pos, "%s() takes at least %s, not %d", name.text, arguments(fn.minArgs), n)
+    case fn.maxArgs >= 0 && n > fn.maxArgs:
+        return nil, p.fail(name.pos, "%s() takes at most %s, not %d", name.text, arguments(fn.maxArgs), n)
+    }
+    for i, arg := range call.args {
+        if err := p.expect(arg, fn.argKind(i, n)); err != nil {
+            return nil, err
+        }
+    }
+    if fn.check != nil {
+        if err := fn.check(p, call); err != nil {
+            return nil, err
+        }
+    }
+    return p.add(call)
+}
+
+// parseList parses a table of numbers, like [0, 0.5, -1e3].
+func (p *parser) parseList() (node, error) {
+    list := &listNode{pos: p.token.pos}
+    if err := p.next(); err != nil {
+        return nil, err
+    }
+    for !p.is("]") {
+        if len(list.values) > 0 {
+            if !p.is(",") {
+                return nil, p.fail(p.token.pos, "expected , or ] in the list")
+            }
+            if err := p.next(); err != nil {
+                return nil, err
+            }
+        }
+        sign := 1.0
+        if p.is("-") {
+            sign = -1
+            if err := p.next(); err != nil {
+                return nil, err
+            }
+        }
+        if p.token.typ != numberToken {
+            return nil, p.fail(p.token.pos, "lists can only hold numbers")
+        }
+        value, err := strconv.ParseFloat(p.token.text, 64)
+        if err != nil || math.IsInf(value, 0) {
+            return nil, p.fail(p.token.pos, "\"%s\" is not a valid number", p.token.text)
+        }
+        list.values = append(list.values, sign*value)
+        if len(list.values) > MaxTableSize {
+            return nil, p.fail(p.token.pos, "lists can hold at most %d numbers", MaxTableSize)
+        }
+        if err = p.next(); err != nil {
+            return nil, err
+        }
+    }
+    if err := p.next(); err != nil {
+        return nil, err
+    }
+    return p.add(list)
+}
+
+func arguments(n int) string {
+    if n == 1 {
+        return "1 argument"
+    }
+    return strconv.Itoa(n) + " arguments"
+}
+
+func functionNames() []string {
+    names := make([]string, 0, len(functions))
+    for name := range functions {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func isDigit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
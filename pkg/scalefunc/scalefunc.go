@@ -0,0 +1,109 @@
+// Package scalefunc evaluates the custom scaling functions of datapoints, Processing.ScaleFunc, which turn the value
+// of a sensor into the value that is shown, e.g. with the calibration curve of the manufacturer. The language is a
+// small expression language over the input value x, with arithmetic, math functions, piecewise definitions, lookup
+// tables and polynomials, e.g.
+//
+//   poly(x, -0.41, 1.0132, 0.00021)
+//   interp(x, [0, 512, 1023], [0, 40, 100])
+//   piecewise(x < 0, 0, x > 100, 100, x * 1.8 + 32)
+//
+// There are no loops, assignments or other side effects, and expressions are limited in size, so that evaluating
+// one of them is cheap and always ends.
+package scalefunc
+
+import (
+    "fmt"
+    "math"
+)
+
+// Limits of expressions, checked by Compile.
+const (
+    MaxLength    = 4096
+    MaxNodes     = 500
+    MaxDepth     = 50
+    MaxTableSize = 256
+)
+
+// MaxSteps is the most operations an evaluation may do; the size limits keep real expressions far below it.
+const MaxSteps = 10000
+
+// SyntaxError tells where in the expression the problem is. Position is the byte offset, counted from 0.
+type SyntaxError struct {
+    Expression string
+    Position   int
+    Message    string
+}
+
+func (e *SyntaxError) Error() string {
+    return fmt.Sprintf("%s at position %d of \"%s\"", e.Message, e.Position, e.Expression)
+}
+
+// EvalError is a problem evaluating an expression for an input, e.g. division by zero. Position is the byte offset of
+// the operator or function that failed.
+type EvalError struct {
+    Expression string
+    Position   int
+    Message    string
+}
+
+func (e *EvalError) Error() string {
+    return fmt.Sprintf("%s at position %d of \"%s\"", e.Message, e.Position, e.Expression)
+}
+
+// Func is a compiled scaling function. It is safe for concurrent use.
+type Func struct {
+    expression string
+    root       node
+}
+
+// Compile parses and checks an expression, and computes the parts of it that don't use x. Their errors, like 1/0,
+// sqrt(-1) or clamp(x, 1, 0), are syntax errors. Evaluating can still fail where the function isn't defined, which
+// may be for some inputs, like ln(x) for x <= 0, or for all of them, like 1/(x-x).
+func Compile(expression string) (*Func, error) {
+    p := &parser{expression: expression}
+    root, err := p.parse()
+    if err != nil {
+        return nil, err
+    }
+    return &Func{expression: expression, root: root}, nil
+}
+
+// Expression returns the source of the function.
+func (f *Func) Expression() string {
+    return f.expression
+}
+
+// Eval returns the function of x. The result is always a finite number; NaN and infinities are errors.
+func (f *Func) Eval(x float64) (float64, error) {
+    e := &evaluator{f: f, x: x}
+    if math.IsNaN(x) || math.IsInf(x, 0) {
+        return 0, e.fail(0, "the input is %g", x)
+    }
+    result, err := f.root.eval(e)
+    if err != nil {
+        return 0, err
+    }
+    if math.IsNaN(result) || math.IsInf(result, 0) {
+        return 0, e.fail(f.root.position(), "the result is %g", result)
+    }
+    return result, nil
+}
+
+// evaluator holds the state of one evaluation.
+type evaluator struct {
+    f     *Func
+    x     float64
+    steps int
+}
+
+func (e *evaluator) step(pos int, n int) error {
+    e.steps += n
+    if e.steps > MaxSteps {
+        return e.fail(pos, "the evaluation takes more than %d steps", MaxSteps)
+    }
+    return nil
+}
+
+func (e *evaluator) fail(pos int, format string, args ...interface{}) error {
+    return &EvalError{Expression: e.f.expression, Position: pos, Message: fmt.Sprintf(format, args...)}
+}
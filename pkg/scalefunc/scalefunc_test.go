@@ -0,0 +1,115 @@
+package scalefunc
+
+import (
+    "errors"
+    "math"
+    "testing"
+)
+
+func TestEval(t *testing.T) {
+    tests := []struct {
+        expression string
+        x          float64
+        expected   float64
+    }{
+        {"x", 3, 3},
+        {"x * 1.8 + 32", 100, 212},
+        {"-x^2", 3, -9},
+        {"2^-x", 1, 0.5},
+        {"x % 4", 10, 2},
+        {"round(x, 1)", 21.46, 21.5},
+        {"round(x)", -2.5, -3},
+        {"poly(x, -0.41, 1.0132, 0.00021)", 0, -0.41},
+        {"interp(x, [0, 512, 1023], [0, 40, 100])", 256, 20},
+        {"interp(x, [0, 512, 1023], [0, 40, 100])", 2000, 100},
+        {"step(x, [0, 10, 20], [1, 2, 3])", 15, 2},
+        {"piecewise(x < 0, 0, x > 100, 100, x * 1.8 + 32)", -5, 0},
+        {"piecewise(x < 0, 0, x > 100, 100, x * 1.8 + 32)", 10, 50},
+        {"clamp(x, 0, 10)", 12, 10},
+        {"if(x > 0 && ln(x) > 1, 1, 0)", -1, 0},
+        {"if(1 > 2, x, -x)", 4, -4},
+        {"if(!(1 > 2) || x > 0, x, -x)", 4, 4},
+        {"x + 2 * pi - 2 * pi", 1, 1},
+        {"interp(5, [0, 10], [0, 20]) + x", 1, 11},
+        {"max(x, 1, 2 * 3)", 4, 6},
+    }
+    for _, test := range tests {
+        f, err := Compile(test.expression)
+        if err != nil {
+            t.Errorf("%s: %v", test.expression, err)
+            continue
+        }
+        value, err := f.Eval(test.x)
+        if err != nil || math.Abs(value-test.expected) > 1e-9 {
+            t.Errorf("%s of %v is %v, %v, expected %v", test.expression, test.x, value, err, test.expected)
+        }
+    }
+}
+
+func TestCompileErrors(t *testing.T) {
+    tests := []struct {
+        expression string
+        position   int
+    }{
+        {"", 0},
+        {"x +", 3},
+        {"y", 0},
+        {"foo(x)", 0},
+        {"x = 1", 2},
+        {"x > 1", 2},
+        {"x < 1 < 2", 6},
+        {"sqrt(x, 2)", 0},
+        {"interp(x, [0, 1], [0])", 18},
+        {"interp(x, [1, 0], [0, 1])", 10},
+        {"piecewise(x > 0, 1, 2, 3)", 20},
+        // the parts that don't use x are computed by Compile
+        {"1/0", 1},
+        {"x % 0", 2},
+        {"x / (2 - 2)", 2},
+        {"2^1024", 1},
+        {"sqrt(-1)", 0},
+        {"x + ln(0)", 4},
+        {"clamp(x, 1, 0)", 0},
+        {"round(x, 20)", 0},
+        {"round(x, 1.5)", 0},
+        {"if(x > 0, 1/0, x)", 11},
+    }
+    for _, test := range tests {
+        _, err := Compile(test.expression)
+        var syntaxErr *SyntaxError
+        if !errors.As(err, &syntaxErr) {
+            t.Errorf("%q: expected a syntax error, got %v", test.expression, err)
+            continue
+        }
+        if syntaxErr.Position != test.position {
+            t.Errorf("%q: error at %d, expected at %d: %v", test.expression, syntaxErr.Position, test.position, err)
+        }
+    }
+}
+
+func TestEvalErrors(t *testing.T) {
+    tests := []struct {
+        expression string
+        x          float64
+    }{
+        {"ln(x)", 0},
+        {"1 / x", 0},
+        {"1 / (x - x)", 5},
+        {"clamp(1, x, 0)", 2},
+        {"round(1, x)", 16},
+        {"x^x", 1000},
+        {"x", math.NaN()},
+    }
+    for _, test := range tests {
+        f, err := Compile(test.expression)
+        if err != nil {
+            t.Errorf("%s: %v", test.expression, err)
+            continue
+        }
+        _, err = f.Eval(test.x)
+        var evalErr *EvalError
+        if !errors.As(err, &evalErr) {
+            t.Errorf("%s of %v: expected an evaluation error, got %v", test.expression, test.x, err)
+        }
+    }
+}
@@ -0,0 +1,44 @@
+package streaming
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+    "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// How often pending commands are checked for timeouts while a _commands stream is open.
+const commandExpiryInterval = 30 * time.Second
+
+// RunCommandsStream sends the model.CommandStatus of the commands of the organization each time it changes, so that
+// the UI can show whether a change was applied without polling GET _commands/{id}.
+func (h *StreamHandler) RunCommandsStream(ctx context.Context, sender *backend.StreamSender, orgId int64) error {
+    log.DefaultLogger.Info("RunCommandsStream()")
+    updates, stop := h.commands.Subscribe(orgId)
+    defer stop()
+    ticker := time.NewTicker(commandExpiryInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            log.DefaultLogger.Info("Grafana sender: DONE")
+            return ctx.Err()
+        case <-ticker.C:
+            h.commands.Expire()
+        case status := <-updates:
+            payload, err := json.Marshal(status)
+            if err != nil {
+                log.DefaultLogger.Error(fmt.Sprintf("Could not marshall command status: %v", err))
+                continue
+            }
+            err = sender.SendJSON(payload)
+            if err != nil {
+                log.DefaultLogger.Error(fmt.Sprintf("Couldn't send frame: %v", err))
+                return err
+            }
+        }
+    }
+}
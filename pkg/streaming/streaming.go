@@ -1,23 +1,35 @@
 package streaming
 
 import (
+    "encoding/json"
+    "errors"
     "fmt"
     "strconv"
 
     "github.com/Sensetif/sensetif-datasource/pkg/client"
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
     "github.com/grafana/grafana-plugin-sdk-go/backend"
     "github.com/grafana/grafana-plugin-sdk-go/backend/log"
     "golang.org/x/net/context"
 )
 
+// Limiter decides whether an organization may call a route class right now, see model.RateLimit.
+type Limiter interface {
+    Allow(orgId int64, route string, write bool) error
+}
+
 type StreamHandler struct {
     pulsar    *client.PulsarClient
     cassandra *client.CassandraClient
+    commands  *client.CommandTracker
+    limiter   Limiter
 }
 
-func CreateStreamHandler(pulsarClient *client.PulsarClient) StreamHandler {
+func CreateStreamHandler(pulsarClient *client.PulsarClient, commands *client.CommandTracker, limiter Limiter) StreamHandler {
     return StreamHandler{
-        pulsar: pulsarClient,
+        pulsar:   pulsarClient,
+        commands: commands,
+        limiter:  limiter,
     }
 }
 
@@ -28,6 +40,11 @@ func (h *StreamHandler) SubscribeStream(ctx context.Context, req *backend.Subscr
     if req.Path == "_notifications" {
         return h.SubscribeNotificationsStream(orgId)
     }
+    if req.Path == "_commands" {
+        return &backend.SubscribeStreamResponse{
+            Status: backend.SubscribeStreamStatusOK,
+        }, nil
+    }
     if req.Path == "_alarms/status" {
         return &backend.SubscribeStreamResponse{
             Status: backend.SubscribeStreamStatusOK,
@@ -48,12 +65,37 @@ func (h *StreamHandler) PublishStream(ctx context.Context, req *backend.PublishS
     orgId := req.PluginContext.OrgID
     user := req.PluginContext.User.Login
     log.DefaultLogger.Info("PublishStream: " + req.Path + " from " + strconv.FormatInt(orgId, 10) + ":" + user)
-    if req.Path == "_alarms/status" {
-        return h.RunAlarmCommandsStream(ctx, req, orgId, user)
+    route := publishRouteOf(req.Path)
+    if route == "" {
+        log.DefaultLogger.Error(fmt.Sprintf("PublishStream requested unknown resource type: %s", req.Path))
+        return &backend.PublishStreamResponse{
+            Status: backend.PublishStreamStatusNotFound,
+        }, nil
     }
-    return &backend.PublishStreamResponse{
-        Status: backend.PublishStreamStatusPermissionDenied,
-    }, nil
+    err := h.limiter.Allow(orgId, model.StreamRateLimitPrefix+route, true)
+    if err != nil {
+        log.DefaultLogger.Info(fmt.Sprintf("PublishStream rejected: %s", err.Error()))
+        retryAfter := 0
+        var rateLimitErr *model.RateLimitError
+        if errors.As(err, &rateLimitErr) {
+            retryAfter = rateLimitErr.RetryAfterSeconds()
+        }
+        data, _ := json.Marshal(map[string]interface{}{"error": err.Error(), "retryAfter": retryAfter})
+        return &backend.PublishStreamResponse{
+            Status: backend.PublishStreamStatusPermissionDenied,
+            Data:   data,
+        }, nil
+    }
+    return h.RunAlarmCommandsStream(ctx, req, orgId, user)
+}
+
+// publishRouteOf returns the route class of a path that can be published to, or "" if it can't. The rate limiter keys
+// on the route class, as the path is chosen by the client, and a new path would otherwise get a new bucket.
+func publishRouteOf(path string) string {
+    if path == "_alarms/status" {
+        return path
+    }
+    return ""
 }
 
 func (h *StreamHandler) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
@@ -62,6 +104,9 @@ func (h *StreamHandler) RunStream(ctx context.Context, req *backend.RunStreamReq
     if req.Path == "_notifications" {
         return h.RunNotificationsStream(ctx, sender, orgId)
     }
+    if req.Path == "_commands" {
+        return h.RunCommandsStream(ctx, sender, orgId)
+    }
     if req.Path == "_alarms/status" {
         return h.RunAlarmsStatusStream(ctx, sender, orgId)
     }
@@ -0,0 +1,46 @@
+package streaming
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/Sensetif/sensetif-datasource/pkg/model"
+    "github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// exhaustedLimiter refuses everything, and records the route classes it was asked about.
+type exhaustedLimiter struct {
+    routes []string
+}
+
+func (l *exhaustedLimiter) Allow(_ int64, route string, _ bool) error {
+    l.routes = append(l.routes, route)
+    return &model.RateLimitError{Route: route, RetryAfter: time.Second}
+}
+
+func TestPublishStreamLimitsPerRouteClass(t *testing.T) {
+    limiter := &exhaustedLimiter{}
+    h := CreateStreamHandler(nil, nil, limiter)
+    publish := func(path string) backend.PublishStreamStatus {
+        response, err := h.PublishStream(context.Background(), &backend.PublishStreamRequest{
+            Path:          path,
+            PluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "editor"}},
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        return response.Status
+    }
+    if status := publish("_alarms/status"); status != backend.PublishStreamStatusPermissionDenied {
+        t.Errorf("expected the exhausted limit to deny, got %v", status)
+    }
+    for _, path := range []string{"_alarms/status/1", "_alarms/status?x", "_notifications"} {
+        if status := publish(path); status != backend.PublishStreamStatusNotFound {
+            t.Errorf("%s: expected not found, got %v", path, status)
+        }
+    }
+    if len(limiter.routes) != 1 || limiter.routes[0] != model.StreamRateLimitPrefix+"_alarms/status" {
+        t.Errorf("the limiter was asked about %v", limiter.routes)
+    }
+}